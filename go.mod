@@ -1,3 +1,5 @@
 module github.com/espennoreng/learn-go-with-tests
 
 go 1.24.4
+
+require github.com/mattn/go-sqlite3 v1.14.33
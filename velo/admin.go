@@ -0,0 +1,31 @@
+package velo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+)
+
+// BootstrapAdmin makes sure an admin named name exists, creating it with
+// password if there is no user by that name yet. Without it a fresh server
+// has nobody who can create orgs.
+func BootstrapAdmin(store models.UserStore, name, password string) (models.User, error) {
+	user, err := store.GetUserByName(name)
+	if err == nil {
+		if !user.IsAdmin {
+			return models.User{}, fmt.Errorf("user %q exists but is not an admin", name)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrNotFound) {
+		return models.User{}, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	return store.CreateUser(models.CreateUserInput{Name: name, PasswordHash: hash, IsAdmin: true})
+}
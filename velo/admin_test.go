@@ -0,0 +1,43 @@
+package velo_test
+
+import (
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestBootstrapAdmin(t *testing.T) {
+	auth.PasswordIterations = 1000
+
+	t.Run("creates the admin once", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+
+		first, err := velo.BootstrapAdmin(store, "root", "hunter2hunter2")
+		if err != nil {
+			t.Fatalf("BootstrapAdmin returned error: %v", err)
+		}
+		if !first.IsAdmin {
+			t.Error("expected the bootstrapped user to be an admin")
+		}
+
+		second, err := velo.BootstrapAdmin(store, "root", "hunter2hunter2")
+		if err != nil {
+			t.Fatalf("BootstrapAdmin returned error on second run: %v", err)
+		}
+		if second.ID != first.ID || len(store.Users) != 1 {
+			t.Errorf("expected the existing admin to be reused, got %+v", store.Users)
+		}
+	})
+
+	t.Run("refuses to promote an existing user", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.CreateUser(models.CreateUserInput{Name: "root"})
+
+		if _, err := velo.BootstrapAdmin(store, "root", "hunter2hunter2"); err == nil {
+			t.Error("expected an error for an existing non-admin user")
+		}
+	})
+}
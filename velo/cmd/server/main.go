@@ -1,20 +1,223 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/espennoreng/learn-go-with-tests/velo"
 	"github.com/espennoreng/learn-go-with-tests/velo/internal/store"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
 )
 
+// Server timeouts. Reading a request and writing its response are bounded,
+// except for the routes that stream, which lift their own deadlines.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = time.Minute
+	writeTimeout      = time.Minute
+	idleTimeout       = 2 * time.Minute
+)
+
+// config is everything the server can be configured with
+type config struct {
+	Addr          string
+	Store         string
+	DSN           string
+	LogLevel      slog.Level
+	AdminName     string
+	AdminPassword string
+	AuditLog      string
+	// WebhookAllowPrivate lets webhooks reach private addresses
+	WebhookAllowPrivate bool
+	Retention           time.Duration
+	ShutdownTimeout     time.Duration
+}
+
 func main() {
-	store := store.NewInMemoryAppStore()
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg, nil); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig reads the configuration from command line flags, which default
+// to the VELO_* environment variables named in their usage
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (config, error) {
+	envOr := func(key, fallback string) string {
+		if value, ok := lookupEnv(key); ok {
+			return value
+		}
+		return fallback
+	}
+
+	var cfg config
+	var logLevel, webhookAllowPrivate, retention, shutdownTimeout string
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&cfg.Addr, "addr", envOr("VELO_ADDR", ":8080"), "address to listen on (env VELO_ADDR)")
+	flags.StringVar(&cfg.Store, "store", envOr("VELO_STORE", "memory"), "storage backend: memory or sqlite (env VELO_STORE)")
+	flags.StringVar(&cfg.DSN, "dsn", envOr("VELO_DSN", "velo.db"), "path to the sqlite database file (env VELO_DSN)")
+	flags.StringVar(&logLevel, "log-level", envOr("VELO_LOG_LEVEL", "info"), "log level: debug, info, warn or error (env VELO_LOG_LEVEL)")
+	flags.StringVar(&cfg.AdminName, "admin-name", envOr("VELO_ADMIN_NAME", ""), "create this admin user on startup if it does not exist (env VELO_ADMIN_NAME)")
+	flags.StringVar(&cfg.AdminPassword, "admin-password", envOr("VELO_ADMIN_PASSWORD", ""), "password for the bootstrapped admin (env VELO_ADMIN_PASSWORD)")
+	flags.StringVar(&cfg.AuditLog, "audit-log", envOr("VELO_AUDIT_LOG", ""), fmt.Sprintf("append audit events to this JSON lines file instead of keeping the last %d in memory (env VELO_AUDIT_LOG)", api.DefaultAuditBuffer))
+	flags.StringVar(&webhookAllowPrivate, "webhook-allow-private", envOr("VELO_WEBHOOK_ALLOW_PRIVATE", "false"), "let webhooks be sent to loopback and private network addresses (env VELO_WEBHOOK_ALLOW_PRIVATE)")
+	flags.StringVar(&retention, "retention", envOr("VELO_RETENTION", "720h"), "how long soft-deleted items are kept before being purged (env VELO_RETENTION)")
+	flags.StringVar(&shutdownTimeout, "shutdown-timeout", envOr("VELO_SHUTDOWN_TIMEOUT", "30s"), "how long to wait for in-flight requests when shutting down (env VELO_SHUTDOWN_TIMEOUT)")
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	if err := cfg.LogLevel.UnmarshalText([]byte(logLevel)); err != nil {
+		return config{}, fmt.Errorf("invalid log level %q, want debug, info, warn or error", logLevel)
+	}
+	allowPrivate, err := strconv.ParseBool(webhookAllowPrivate)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid webhook-allow-private %q, want true or false", webhookAllowPrivate)
+	}
+	cfg.WebhookAllowPrivate = allowPrivate
+	if cfg.Retention, err = time.ParseDuration(retention); err != nil {
+		return config{}, fmt.Errorf("invalid retention %q, want a duration such as 720h", retention)
+	}
+	if cfg.ShutdownTimeout, err = time.ParseDuration(shutdownTimeout); err != nil {
+		return config{}, fmt.Errorf("invalid shutdown-timeout %q, want a duration such as 30s", shutdownTimeout)
+	}
+	if cfg.Store != "memory" && cfg.Store != "sqlite" {
+		return config{}, fmt.Errorf("unknown store %q, want memory or sqlite", cfg.Store)
+	}
+	// An admin without a password could never log in
+	if cfg.AdminName != "" && len(cfg.AdminPassword) < api.MinPasswordLength {
+		return config{}, fmt.Errorf("admin-password must be at least %d characters when admin-name is set", api.MinPasswordLength)
+	}
+	return cfg, nil
+}
+
+// run serves the API until ctx is cancelled, then stops accepting
+// connections and waits up to cfg.ShutdownTimeout for in-flight requests.
+// ready, if not nil, is called with the address once the server listens.
+func run(ctx context.Context, cfg config, ready func(addr string)) error {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	// Other packages log failures through the log package
+	slog.SetLogLoggerLevel(slog.LevelWarn)
+
+	var appStore models.AppStore
+	// subscriptions keeps webhooks across restarts if the store can
+	var subscriptions webhook.SubscriptionStore
+	switch cfg.Store {
+	case "sqlite":
+		sqlStore, err := store.OpenSQLite(cfg.DSN)
+		if err != nil {
+			return fmt.Errorf("could not open sqlite store: %w", err)
+		}
+		defer sqlStore.Close()
+		appStore = sqlStore
+		subscriptions = sqlStore
+	default:
+		appStore = store.NewInMemoryAppStore()
+	}
+
+	if cfg.AdminName != "" {
+		if _, err := velo.BootstrapAdmin(appStore, cfg.AdminName, cfg.AdminPassword); err != nil {
+			return fmt.Errorf("could not bootstrap admin: %w", err)
+		}
+	}
+
+	// The purger is stopped and waited for before the store is closed
+	purger := &velo.Purger{Store: appStore, Retention: cfg.Retention, Interval: time.Hour}
+	purgeCtx, stopPurging := context.WithCancel(ctx)
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		purger.Run(purgeCtx)
+	}()
+	defer func() {
+		stopPurging()
+		<-purged
+	}()
+
+	var opts []api.Option
+	if cfg.AuditLog != "" {
+		sink, err := audit.OpenFileSink(cfg.AuditLog)
+		if err != nil {
+			return fmt.Errorf("could not open audit log: %w", err)
+		}
+		defer sink.Close()
+		opts = append(opts, api.WithAuditSink(sink))
+	}
 
-	server := velo.NewAppServer(store)
+	webhooks := webhook.NewDispatcher()
+	webhooks.AllowPrivateAddresses = cfg.WebhookAllowPrivate
+	if subscriptions != nil {
+		if err := webhooks.Persist(subscriptions); err != nil {
+			return fmt.Errorf("could not load webhooks: %w", err)
+		}
+	}
+	defer webhooks.Close()
+	opts = append(opts, api.WithWebhooks(webhooks))
+
+	// Event streams never finish on their own, so they are ended once
+	// shutdown begins; other requests are drained
+	streamsDone := make(chan struct{})
+	opts = append(opts, api.WithStreamsDone(streamsDone))
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           velo.NewAppServer(appStore, opts...),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	server.RegisterOnShutdown(func() { close(streamsDone) })
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	slog.Info("server started", "addr", listener.Addr().String(), "store", cfg.Store)
+	if ready != nil {
+		ready(listener.Addr().String())
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", server); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not drain in-flight requests: %w", err)
 	}
+	slog.Info("server stopped")
+	return nil
 }
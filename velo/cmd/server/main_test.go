@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+)
+
+func TestLoadConfig(t *testing.T) {
+	env := func(vars map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			value, ok := vars[key]
+			return value, ok
+		}
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := loadConfig(nil, env(nil))
+		if err != nil {
+			t.Fatalf("loadConfig returned error: %v", err)
+		}
+		if cfg.Addr != ":8080" || cfg.Store != "memory" || cfg.LogLevel != slog.LevelInfo {
+			t.Errorf("got %+v, want :8080, memory and info", cfg)
+		}
+		if cfg.Retention != 30*24*time.Hour || cfg.ShutdownTimeout != 30*time.Second {
+			t.Errorf("got retention %v and shutdown timeout %v, want 30 days and 30s", cfg.Retention, cfg.ShutdownTimeout)
+		}
+	})
+
+	t.Run("flags override the environment", func(t *testing.T) {
+		vars := map[string]string{"VELO_ADDR": ":9000", "VELO_STORE": "sqlite", "VELO_DSN": "env.db", "VELO_LOG_LEVEL": "debug"}
+		cfg, err := loadConfig([]string{"-addr", ":9001", "-log-level", "warn"}, env(vars))
+		if err != nil {
+			t.Fatalf("loadConfig returned error: %v", err)
+		}
+		want := config{Addr: ":9001", Store: "sqlite", DSN: "env.db", LogLevel: slog.LevelWarn}
+		if cfg.Addr != want.Addr || cfg.Store != want.Store || cfg.DSN != want.DSN || cfg.LogLevel != want.LogLevel {
+			t.Errorf("got %+v, want %+v", cfg, want)
+		}
+	})
+
+	t.Run("reads durations from the environment", func(t *testing.T) {
+		vars := map[string]string{"VELO_RETENTION": "48h", "VELO_SHUTDOWN_TIMEOUT": "5s"}
+		cfg, err := loadConfig([]string{"-shutdown-timeout", "10s"}, env(vars))
+		if err != nil {
+			t.Fatalf("loadConfig returned error: %v", err)
+		}
+		if cfg.Retention != 48*time.Hour || cfg.ShutdownTimeout != 10*time.Second {
+			t.Errorf("got retention %v and shutdown timeout %v, want 48h from the environment and 10s from the flag", cfg.Retention, cfg.ShutdownTimeout)
+		}
+
+		if _, err := loadConfig(nil, env(map[string]string{"VELO_SHUTDOWN_TIMEOUT": "soon"})); err == nil {
+			t.Error("want an invalid VELO_SHUTDOWN_TIMEOUT rejected")
+		}
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		for _, args := range [][]string{{"-log-level", "loud"}, {"-store", "postgres"}, {"-retention", "forever"}, {"-webhook-allow-private", "maybe"}, {"-admin-name", "admin"}, {"-admin-name", "admin", "-admin-password", "short"}} {
+			if _, err := loadConfig(args, env(nil)); err == nil {
+				t.Errorf("loadConfig(%v) returned no error", args)
+			}
+		}
+	})
+}
+
+func TestRunShutsDownGracefully(t *testing.T) {
+	iterations := auth.PasswordIterations
+	auth.PasswordIterations = 1000
+	t.Cleanup(func() { auth.PasswordIterations = iterations })
+
+	cfg := config{
+		Addr:            "127.0.0.1:0",
+		Store:           "sqlite",
+		DSN:             filepath.Join(t.TempDir(), "velo.db"),
+		LogLevel:        slog.LevelError,
+		AdminName:       "admin",
+		AdminPassword:   "hunter2hunter2",
+		Retention:       time.Hour,
+		ShutdownTimeout: 5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrs := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, cfg, func(addr string) { addrs <- addr }) }()
+
+	var base string
+	select {
+	case addr := <-addrs:
+		base = "http://" + addr
+	case err := <-done:
+		t.Fatalf("run returned before listening: %v", err)
+	}
+
+	request := func(method, path, token, orgID, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", api.ContentTypeJSON)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if orgID != "" {
+			req.Header.Set(api.OrgHeader, orgID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return res
+	}
+
+	res := request(http.MethodPost, "/sessions", "", "", `{"name": "admin", "password": "hunter2hunter2"}`)
+	var session api.LoginResponse
+	json.NewDecoder(res.Body).Decode(&session)
+	res.Body.Close()
+
+	res = request(http.MethodPost, "/orgs", session.Token, "", `{"name": "acme"}`)
+	var org models.Org
+	json.NewDecoder(res.Body).Decode(&org)
+	res.Body.Close()
+
+	// An open event stream must not hold up the shutdown
+	stream := request(http.MethodGet, "/items/events", session.Token, org.ID, "")
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("got stream status %d, want %d", stream.StatusCode, http.StatusOK)
+	}
+
+	// An import still uploading when shutdown begins must be drained, not
+	// cut off. Expect: 100-continue holds the body back until the handler
+	// reads it, so the import is known to be running before the shutdown.
+	upload, uploading := io.Pipe()
+	reading := make(chan struct{})
+	req, _ := http.NewRequest(http.MethodPost, base+"/items/import", &signalReader{Reader: upload, first: reading})
+	req.Header.Set("Content-Type", api.ContentTypeCSV)
+	req.Header.Set("Expect", "100-continue")
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	req.Header.Set(api.OrgHeader, org.ID)
+	imported := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("import failed: %v", err)
+		}
+		imported <- res
+	}()
+	go uploading.Write([]byte("name\nbike\n"))
+	<-reading
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	uploading.Write([]byte("mug\n"))
+	uploading.Close()
+
+	select {
+	case res := <-imported:
+		if res == nil {
+			t.FailNow()
+		}
+		var report api.ImportReport
+		json.NewDecoder(res.Body).Decode(&report)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || len(report.Accepted) != 2 {
+			t.Errorf("got status %d and report %+v, want both lines imported", res.StatusCode, report)
+		}
+	case <-time.After(cfg.ShutdownTimeout):
+		t.Fatal("import did not finish")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned error: %v", err)
+		}
+	case <-time.After(cfg.ShutdownTimeout):
+		t.Fatal("server did not shut down")
+	}
+
+	if _, err := io.Copy(io.Discard, bufio.NewReader(stream.Body)); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+	if _, err := http.Get(base + "/openapi.json"); err == nil {
+		t.Error("server still accepts requests after shutting down")
+	}
+}
+
+// signalReader closes first when it is read from for the first time
+type signalReader struct {
+	io.Reader
+	first chan struct{}
+	once  sync.Once
+}
+
+func (r *signalReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { close(r.first) })
+	return r.Reader.Read(p)
+}
@@ -1,36 +1,499 @@
 package store
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/search"
 )
 
+// InMemoryAppStore keeps items, users and sessions in maps guarded by a mutex.
+// Everything is lost when the process exits.
 type InMemoryAppStore struct {
-	mu    sync.RWMutex
-	Items []models.Item
+	mu       sync.RWMutex
+	items    map[string]models.Item
+	users    map[string]models.User
+	sessions map[string]models.Session
+	orgs     map[string]models.Org
+	// members maps an org ID to its members, keyed by user ID
+	members map[string]map[string]models.Membership
+	index   *search.Index
 }
 
 func NewInMemoryAppStore() *InMemoryAppStore {
 	return &InMemoryAppStore{
-		sync.RWMutex{},
-		[]models.Item{},
+		items:    map[string]models.Item{},
+		users:    map[string]models.User{},
+		sessions: map[string]models.Session{},
+		orgs:     map[string]models.Org{},
+		members:  map[string]map[string]models.Membership{},
+		index:    search.NewIndex(),
 	}
 }
 
+// newID returns a random identifier with the given prefix, e.g. "item-3f9a..."
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate id: %v", err))
+	}
+	return prefix + "-" + hex.EncodeToString(b)
+}
+
+func (s *InMemoryAppStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := models.Item{
+		ID:         newID("item"),
+		Name:       input.Name,
+		ExternalID: input.ExternalID,
+		OrgID:      input.OrgID,
+		IsActive:   true,
+		CreatedBy:  input.CreatedBy,
+		Version:    1,
+	}
+	if err := s.checkExternalID(item); err != nil {
+		return models.Item{}, err
+	}
+	item.CreatedAt = time.Now().UTC()
+	item.UpdatedAt = item.CreatedAt
+	s.items[item.ID] = item
+	s.index.Put(item)
+	return item, nil
+}
+
 func (s *InMemoryAppStore) GetItem(id string) (models.Item, error) {
-	return models.Item{}, fmt.Errorf("item not found: %s", id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	return item, nil
 }
 
+// GetItems returns all items ordered by creation time
 func (s *InMemoryAppStore) GetItems() ([]models.Item, error) {
-	return []models.Item{}, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]models.Item, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].ID < items[j].ID
+		}
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+	return items, nil
+}
+
+func (s *InMemoryAppStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]models.Item, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	return models.QueryItemSlice(items, query), nil
+}
+
+func (s *InMemoryAppStore) SearchItems(query models.ItemSearch) ([]models.ItemSearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hits := s.index.Search(query)
+	results := make([]models.ItemSearchResult, len(hits))
+	for i, hit := range hits {
+		results[i] = models.ItemSearchResult{Item: s.items[hit.ID], Score: hit.Score}
+	}
+	return results, nil
 }
 
 func (s *InMemoryAppStore) DeleteItem(id string) error {
-	return fmt.Errorf("item not found when trying to delete it: %s", id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[id]; !ok {
+		return fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	delete(s.items, id)
+	s.index.Remove(id)
+	return nil
+}
+
+func (s *InMemoryAppStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok || item.IsDeleted() {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	deletedAt := at.UTC()
+	item.DeletedAt = &deletedAt
+	item.UpdatedAt = time.Now().UTC()
+	item.Version++
+	s.items[id] = item
+	s.index.Put(item)
+	return item, nil
+}
+
+func (s *InMemoryAppStore) RestoreItem(id string) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	if !item.IsDeleted() {
+		return models.Item{}, fmt.Errorf("item %s is not deleted: %w", id, models.ErrConflict)
+	}
+	item.DeletedAt = nil
+	item.UpdatedAt = time.Now().UTC()
+	item.Version++
+	s.items[id] = item
+	s.index.Put(item)
+	return item, nil
+}
+
+// PurgeItems permanently removes items soft-deleted before deletedBefore
+func (s *InMemoryAppStore) PurgeItems(deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, item := range s.items {
+		if item.DeletedBefore(deletedBefore) {
+			delete(s.items, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *InMemoryAppStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	if err := update.CheckVersion(item); err != nil {
+		return models.Item{}, err
+	}
+	item.Apply(update)
+	if err := s.checkExternalID(item); err != nil {
+		return models.Item{}, err
+	}
+	item.UpdatedAt = time.Now().UTC()
+	item.Version++
+	s.items[id] = item
+	s.index.Put(item)
+	return item, nil
+}
+
+// checkExternalID returns ErrConflict if another item in the org has the
+// item's external ID. Callers must hold the lock.
+func (s *InMemoryAppStore) checkExternalID(item models.Item) error {
+	for _, other := range s.items {
+		if item.SharesExternalID(other) {
+			return fmt.Errorf("external ID %q in org %q: %w", item.ExternalID, item.OrgID, models.ErrConflict)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryAppStore) CreateUser(input models.CreateUserInput) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nameTaken(input.Name, "") {
+		return models.User{}, fmt.Errorf("user name %q: %w", input.Name, models.ErrConflict)
+	}
+
+	user := models.User{
+		ID:           newID("user"),
+		Name:         input.Name,
+		PasswordHash: input.PasswordHash,
+		IsAdmin:      input.IsAdmin,
+		CreatedAt:    time.Now().UTC(),
+	}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *InMemoryAppStore) GetUser(id string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *InMemoryAppStore) GetUserByName(name string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Name == name {
+			return user, nil
+		}
+	}
+	return models.User{}, fmt.Errorf("user named %q: %w", name, models.ErrNotFound)
+}
+
+// nameTaken reports whether a user other than exceptID is called name.
+// Callers must hold the lock.
+func (s *InMemoryAppStore) nameTaken(name, exceptID string) bool {
+	for _, user := range s.users {
+		if user.Name == name && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *InMemoryAppStore) UpdateUser(id string, updates map[string]any) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+	}
+	user.ApplyUpdates(updates)
+	if s.nameTaken(user.Name, id) {
+		return models.User{}, fmt.Errorf("user name %q: %w", user.Name, models.ErrConflict)
+	}
+	s.users[id] = user
+	return user, nil
+}
+
+func (s *InMemoryAppStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+	}
+	delete(s.users, id)
+	for _, members := range s.members {
+		delete(members, id)
+	}
+	return nil
+}
+
+func (s *InMemoryAppStore) CreateSession(input models.CreateSessionInput) (models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[input.UserID]; !ok {
+		return models.Session{}, fmt.Errorf("user %s: %w", input.UserID, models.ErrNotFound)
+	}
+
+	session := models.Session{
+		ID:        newID("session"),
+		UserID:    input.UserID,
+		TokenHash: input.TokenHash,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: input.ExpiresAt,
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+func (s *InMemoryAppStore) GetSession(id string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return models.Session{}, fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+	}
+	return session, nil
+}
+
+func (s *InMemoryAppStore) GetSessionByToken(tokenHash string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.sessions {
+		if tokenHash != "" && session.TokenHash == tokenHash {
+			return session, nil
+		}
+	}
+	return models.Session{}, fmt.Errorf("session for token: %w", models.ErrNotFound)
+}
+
+func (s *InMemoryAppStore) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *InMemoryAppStore) CreateOrg(input models.CreateOrgInput) (models.Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := models.Org{
+		ID:        newID("org"),
+		Name:      input.Name,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.orgs[org.ID] = org
+	s.members[org.ID] = map[string]models.Membership{}
+	return org, nil
+}
+
+func (s *InMemoryAppStore) GetOrg(id string) (models.Org, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return models.Org{}, fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+	}
+	return org, nil
+}
+
+// GetOrgs returns all orgs ordered by creation time
+func (s *InMemoryAppStore) GetOrgs() ([]models.Org, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orgs := make([]models.Org, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		orgs = append(orgs, org)
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].CreatedAt.Equal(orgs[j].CreatedAt) {
+			return orgs[i].ID < orgs[j].ID
+		}
+		return orgs[i].CreatedAt.Before(orgs[j].CreatedAt)
+	})
+	return orgs, nil
+}
+
+func (s *InMemoryAppStore) DeleteOrg(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgs[id]; !ok {
+		return fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+	}
+	for _, item := range s.items {
+		if item.OrgID == id && !item.IsDeleted() {
+			return fmt.Errorf("org %s still has items: %w", id, models.ErrConflict)
+		}
+	}
+	// Soft-deleted items could otherwise be restored into an org that no
+	// longer exists
+	for itemID, item := range s.items {
+		if item.OrgID == id {
+			delete(s.items, itemID)
+		}
+	}
+	delete(s.orgs, id)
+	delete(s.members, id)
+	return nil
+}
+
+// AddMember adds a user to an org in the given role. Adding an existing
+// member changes their role.
+func (s *InMemoryAppStore) AddMember(orgID, userID string, role models.Role) (models.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members, ok := s.members[orgID]
+	if !ok {
+		return models.Membership{}, fmt.Errorf("org %s: %w", orgID, models.ErrNotFound)
+	}
+	if _, ok := s.users[userID]; !ok {
+		return models.Membership{}, fmt.Errorf("user %s: %w", userID, models.ErrNotFound)
+	}
+
+	membership, ok := members[userID]
+	if !ok {
+		membership = models.Membership{OrgID: orgID, UserID: userID, CreatedAt: time.Now().UTC()}
+	}
+	membership.Role = role
+	members[userID] = membership
+	return membership, nil
+}
+
+func (s *InMemoryAppStore) RemoveMember(orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.members[orgID][userID]; !ok {
+		return fmt.Errorf("membership of %s in %s: %w", userID, orgID, models.ErrNotFound)
+	}
+	delete(s.members[orgID], userID)
+	return nil
+}
+
+func (s *InMemoryAppStore) GetMembers(orgID string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	members, ok := s.members[orgID]
+	if !ok {
+		return nil, fmt.Errorf("org %s: %w", orgID, models.ErrNotFound)
+	}
+
+	out := make([]models.Membership, 0, len(members))
+	for _, m := range members {
+		out = append(out, m)
+	}
+	sortMemberships(out)
+	return out, nil
+}
+
+func (s *InMemoryAppStore) GetMemberships(userID string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []models.Membership{}
+	for _, members := range s.members {
+		if m, ok := members[userID]; ok {
+			out = append(out, m)
+		}
+	}
+	sortMemberships(out)
+	return out, nil
 }
 
-func (s *InMemoryAppStore) UpdateItem(id string, updates map[string]any) (models.Item, error) {
-	return models.Item{}, nil
+// sortMemberships orders memberships by when they were created
+func sortMemberships(memberships []models.Membership) {
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i], memberships[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if a.OrgID != b.OrgID {
+			return a.OrgID < b.OrgID
+		}
+		return a.UserID < b.UserID
+	})
 }
@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/internal/store"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/storetest"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestInMemoryAppStore(t *testing.T) {
+	storetest.Run(t, func() models.AppStore {
+		return store.NewInMemoryAppStore()
+	})
+}
+
+func TestInMemoryAppStoreWithHandler(t *testing.T) {
+	handler := api.NewHandler(store.NewInMemoryAppStore())
+
+	body, _ := json.Marshal(api.CreateItemRequest{Name: "served item"})
+	createResponse := testutils.MakeRequest(t, handler, http.MethodPost, "/items", body)
+	testutils.AssertStatus(t, createResponse.Code, http.StatusCreated)
+
+	var created models.Item
+	json.NewDecoder(createResponse.Body).Decode(&created)
+
+	getResponse := testutils.MakeRequest(t, handler, http.MethodGet, "/items/"+created.ID, nil)
+	testutils.AssertStatus(t, getResponse.Code, http.StatusOK)
+
+	deleteResponse := testutils.MakeRequest(t, handler, http.MethodDelete, "/items/"+created.ID, nil)
+	testutils.AssertStatus(t, deleteResponse.Code, http.StatusNoContent)
+
+	missingResponse := testutils.MakeRequest(t, handler, http.MethodGet, "/items/"+created.ID, nil)
+	testutils.AssertStatus(t, missingResponse.Code, http.StatusNotFound)
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a single versioned schema change read from migrations/
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the embedded migration files, named NNNN_description.sql,
+// and returns them ordered by version
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	names, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, name := range names {
+		base := strings.TrimPrefix(name, "migrations/")
+		prefix, _, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %q is not named NNNN_description.sql", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q has an invalid version: %w", base, err)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", other, base, version)
+		}
+		seen[version] = base
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: base, sql: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// migrate brings the schema up to the latest embedded version. Every
+// migration runs in its own transaction together with the row recording it,
+// so a failed migration leaves the database at the previous version.
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema_migrations: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// migrationChecks run before the migration of the same version. They stop
+// it with an error that says what needs fixing by hand when the data would
+// make it fail.
+var migrationChecks = map[int]func(tx *sql.Tx) error{
+	8: checkDuplicateExternalIDs,
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if check, ok := migrationChecks[m.version]; ok {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.version, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the highest applied migration, or 0 for a new database
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// checkDuplicateExternalIDs finds items sharing an external ID within an
+// org, which stop external IDs being made unique
+func checkDuplicateExternalIDs(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT org_id, external_id, group_concat(id, ', ' ORDER BY id) FROM items
+		WHERE external_id != ''
+		GROUP BY org_id, external_id HAVING COUNT(*) > 1
+		ORDER BY org_id, external_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var orgID, externalID, ids string
+		if err := rows.Scan(&orgID, &externalID, &ids); err != nil {
+			return err
+		}
+		duplicates = append(duplicates, fmt.Sprintf("org %q external ID %q is used by %s", orgID, externalID, ids))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("external IDs must be unique within an org; change or clear them and start again: %s", strings.Join(duplicates, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openLegacyDB returns a database migrated only up to version
+func openLegacyDB(t *testing.T, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:version] {
+		if err := applyMigration(db, m); err != nil {
+			t.Fatalf("could not apply %s: %v", m.name, err)
+		}
+	}
+	return db
+}
+
+func TestMigrateTypesLegacyItems(t *testing.T) {
+	// Version 2 is when is_active and deleted_at were text
+	db := openLegacyDB(t, 2)
+	migrations, _ := loadMigrations(migrationFiles)
+
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	_, err := db.Exec(`INSERT INTO items (id, name, is_active, created_at, deleted_at) VALUES
+		('live', 'live item', 'true', ?, ''),
+		('gone', 'deleted item', 'false', ?, '2024-02-01T10:30:00.000000000Z')`, created, created)
+	if err != nil {
+		t.Fatalf("could not insert legacy rows: %v", err)
+	}
+
+	s, err := NewSQLAppStore(db)
+	if err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+
+	version, _ := schemaVersion(db)
+	if version != len(migrations) {
+		t.Errorf("got schema version %d, want %d", version, len(migrations))
+	}
+
+	live, err := s.GetItem("live")
+	if err != nil {
+		t.Fatalf("could not read migrated item: %v", err)
+	}
+	if !live.IsActive || live.DeletedAt != nil || !live.UpdatedAt.Equal(created) {
+		t.Errorf("live item migrated to %+v", live)
+	}
+
+	gone, err := s.GetItem("gone")
+	if err != nil {
+		t.Fatalf("could not read migrated item: %v", err)
+	}
+	wantDeleted := time.Date(2024, 2, 1, 10, 30, 0, 0, time.UTC)
+	if gone.IsActive || gone.DeletedAt == nil || !gone.DeletedAt.Equal(wantDeleted) {
+		t.Errorf("deleted item migrated to %+v", gone)
+	}
+
+	purged, err := s.PurgeItems(wantDeleted.Add(time.Minute))
+	if err != nil || purged != 1 {
+		t.Errorf("expected the migrated deleted item to be purged, got %d, %v", purged, err)
+	}
+}
+
+func TestMigrateRenamesDuplicateUserNames(t *testing.T) {
+	// Before version 4 names could repeat
+	db := openLegacyDB(t, 3)
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	_, err := db.Exec(`INSERT INTO users (id, name, created_at) VALUES
+		('user-b', 'per', ?), ('user-a', 'per', ?), ('user-c', 'kari', ?)`, created, created.Add(time.Hour), created)
+	if err != nil {
+		t.Fatalf("could not insert legacy rows: %v", err)
+	}
+
+	s, err := NewSQLAppStore(db)
+	if err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+
+	for name, wantID := range map[string]string{"per": "user-b", "per-user-a": "user-a", "kari": "user-c"} {
+		user, err := s.GetUserByName(name)
+		if err != nil || user.ID != wantID {
+			t.Errorf("GetUserByName(%q) returned %+v, %v, want %s", name, user, err, wantID)
+		}
+	}
+}
+
+func TestMigrateReportsDuplicateExternalIDs(t *testing.T) {
+	// Before version 8 external IDs could repeat
+	db := openLegacyDB(t, 7)
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	_, err := db.Exec(`INSERT INTO items (id, name, external_id, org_id, created_at, updated_at) VALUES
+		('item-1', 'mug', 'sku-1', 'acme', ?, ?),
+		('item-2', 'cup', 'sku-1', 'acme', ?, ?),
+		('item-3', 'mug', 'sku-1', 'globex', ?, ?)`, created, created, created, created, created, created)
+	if err != nil {
+		t.Fatalf("could not insert legacy rows: %v", err)
+	}
+
+	_, err = NewSQLAppStore(db)
+	if err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	for _, want := range []string{"0008_unique_item_external_ids.sql", `org "acme" external ID "sku-1" is used by item-1, item-2`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q, want it to mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "globex") {
+		t.Errorf("got error %q, want only the org with duplicates", err)
+	}
+	if version, _ := schemaVersion(db); version != 7 {
+		t.Errorf("got schema version %d, want the database left at 7", version)
+	}
+
+	if _, err := db.Exec(`UPDATE items SET external_id = 'sku-2' WHERE id = 'item-2'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSQLAppStore(db); err != nil {
+		t.Errorf("could not migrate once the duplicates were fixed: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+CREATE TABLE items (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    external_id TEXT NOT NULL DEFAULT '',
+    org_id      TEXT NOT NULL DEFAULT '',
+    is_active   TEXT NOT NULL DEFAULT 'true',
+    created_at  TIMESTAMP NOT NULL,
+    created_by  TEXT NOT NULL DEFAULT '',
+    deleted_at  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX items_org_id ON items (org_id);
+
+CREATE TABLE users (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE sessions (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,2 @@
+CREATE INDEX items_org_created_at ON items (org_id, created_at, id);
+CREATE INDEX items_org_name ON items (org_id, name, id);
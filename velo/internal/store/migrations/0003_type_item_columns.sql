@@ -0,0 +1,35 @@
+-- is_active becomes a boolean, deleted_at a nullable timestamp and items gain
+-- updated_at. SQLite cannot change column types, so the table is rebuilt.
+CREATE TABLE items_typed (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    external_id TEXT NOT NULL DEFAULT '',
+    org_id      TEXT NOT NULL DEFAULT '',
+    is_active   BOOLEAN NOT NULL DEFAULT 1,
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL,
+    created_by  TEXT NOT NULL DEFAULT '',
+    deleted_at  TIMESTAMP NULL
+);
+
+INSERT INTO items_typed (id, name, external_id, org_id, is_active, created_at, updated_at, created_by, deleted_at)
+SELECT
+    id,
+    name,
+    external_id,
+    org_id,
+    CASE WHEN lower(is_active) IN ('true', '1', 't') THEN 1 ELSE 0 END,
+    created_at,
+    created_at,
+    created_by,
+    CASE WHEN deleted_at = '' THEN NULL
+         ELSE replace(replace(deleted_at, 'T', ' '), 'Z', '+00:00')
+    END
+FROM items;
+
+DROP TABLE items;
+ALTER TABLE items_typed RENAME TO items;
+
+CREATE INDEX items_org_id ON items (org_id);
+CREATE INDEX items_org_created_at ON items (org_id, created_at, id);
+CREATE INDEX items_org_name ON items (org_id, name, id);
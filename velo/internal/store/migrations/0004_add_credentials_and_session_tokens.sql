@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';
+
+-- Names used to be free to repeat. Logging in looks users up by name, so
+-- every user but the first with a name is renamed after its ID. None of
+-- them has a password yet.
+UPDATE users SET name = name || '-' || id
+WHERE EXISTS (
+    SELECT 1 FROM users AS earlier
+    WHERE earlier.name = users.name
+      AND (earlier.created_at < users.created_at OR (earlier.created_at = users.created_at AND earlier.id < users.id))
+);
+CREATE UNIQUE INDEX users_name ON users (name);
+
+ALTER TABLE sessions ADD COLUMN token_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN expires_at TIMESTAMP NULL;
+CREATE INDEX sessions_token_hash ON sessions (token_hash);
@@ -0,0 +1,2 @@
+-- Members added before roles existed could change items, so keep them as editors.
+ALTER TABLE memberships ADD COLUMN role TEXT NOT NULL DEFAULT 'editor';
@@ -0,0 +1 @@
+ALTER TABLE items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
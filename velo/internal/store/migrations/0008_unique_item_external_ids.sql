@@ -0,0 +1,4 @@
+-- An external ID names at most one item per org. Items without one are not constrained.
+-- checkDuplicateExternalIDs stops this migration with a list of the items to fix if
+-- any already share one.
+CREATE UNIQUE INDEX items_org_external_id ON items (org_id, external_id) WHERE external_id != '';
@@ -0,0 +1,10 @@
+-- Webhook subscriptions, secrets included, so they survive a restart. events is a
+-- JSON array of event types; an empty one subscribes to every type.
+CREATE TABLE webhooks (
+    id         TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL DEFAULT '',
+    url        TEXT NOT NULL,
+    events     TEXT NOT NULL DEFAULT '[]',
+    secret     TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
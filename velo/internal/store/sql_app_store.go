@@ -0,0 +1,763 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/search"
+)
+
+// SQLAppStore persists items, users and sessions in a SQL database.
+// The schema is created and upgraded by the embedded migrations. Item
+// names are searched with an in-memory index, built when the store is
+// opened, that only sees changes made through this store.
+type SQLAppStore struct {
+	db *sql.DB
+	// q runs every statement: the database itself, or the transaction of a
+	// store handed out by InTx
+	q  queryer
+	tx *sql.Tx
+
+	index *search.Index
+	// touched collects the items changed in a transaction, to be reindexed
+	// once it commits
+	touched *[]string
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path and
+// migrates it to the latest schema.
+func OpenSQLite(path string) (*SQLAppStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+
+	// SQLite allows a single writer, so share one connection rather than
+	// have concurrent requests fail with "database is locked".
+	db.SetMaxOpenConns(1)
+
+	s, err := NewSQLAppStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLAppStore wraps an open database, running any pending migrations
+func NewSQLAppStore(db *sql.DB) (*SQLAppStore, error) {
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
+	s := &SQLAppStore{db: db, q: db, index: search.NewIndex()}
+	items, err := s.GetItems()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		s.index.Put(item)
+	}
+	return s, nil
+}
+
+// InTx runs fn against a store bound to a new transaction, committing it
+// only if fn returns nil. It implements models.Transactor.
+func (s *SQLAppStore) InTx(fn func(models.AppStore) error) error {
+	var touched []string
+	err := s.inTx(func(tx *sql.Tx) error {
+		return fn(&SQLAppStore{db: s.db, q: tx, tx: tx, index: s.index, touched: &touched})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range touched {
+		item, err := s.GetItem(id)
+		switch {
+		case err == nil:
+			s.index.Put(item)
+		case errors.Is(err, models.ErrNotFound):
+			s.index.Remove(id)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// indexed brings the search index up to date with a changed item, or notes
+// it for later if the store is bound to a transaction
+func (s *SQLAppStore) indexed(item models.Item) {
+	if s.touched != nil {
+		*s.touched = append(*s.touched, item.ID)
+		return
+	}
+	s.index.Put(item)
+}
+
+// unindexed is indexed for an item that no longer exists
+func (s *SQLAppStore) unindexed(id string) {
+	if s.touched != nil {
+		*s.touched = append(*s.touched, id)
+		return
+	}
+	s.index.Remove(id)
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds. A store that
+// is already bound to a transaction runs fn in that one and leaves the
+// commit to its owner.
+func (s *SQLAppStore) inTx(fn func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close releases the underlying database
+func (s *SQLAppStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can still be reached
+func (s *SQLAppStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+const itemColumns = `id, name, external_id, org_id, is_active, created_at, updated_at, created_by, deleted_at, version`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanItem(row rowScanner) (models.Item, error) {
+	var item models.Item
+	var deletedAt sql.NullTime
+	err := row.Scan(&item.ID, &item.Name, &item.ExternalID, &item.OrgID, &item.IsActive, &item.CreatedAt, &item.UpdatedAt, &item.CreatedBy, &deletedAt, &item.Version)
+	if deletedAt.Valid {
+		item.DeletedAt = &deletedAt.Time
+	}
+	return item, err
+}
+
+func (s *SQLAppStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	item := models.Item{
+		ID:         newID("item"),
+		Name:       input.Name,
+		ExternalID: input.ExternalID,
+		OrgID:      input.OrgID,
+		IsActive:   true,
+		CreatedBy:  input.CreatedBy,
+		Version:    1,
+	}
+	item.CreatedAt = time.Now().UTC()
+	item.UpdatedAt = item.CreatedAt
+
+	_, err := s.q.Exec(`INSERT INTO items (`+itemColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.ID, item.Name, item.ExternalID, item.OrgID, item.IsActive, item.CreatedAt, item.UpdatedAt, item.CreatedBy, item.DeletedAt, item.Version)
+	if isUniqueViolation(err) {
+		return models.Item{}, fmt.Errorf("external ID %q in org %q: %w", item.ExternalID, item.OrgID, models.ErrConflict)
+	}
+	if err != nil {
+		return models.Item{}, fmt.Errorf("could not create item: %w", err)
+	}
+	s.indexed(item)
+	return item, nil
+}
+
+func (s *SQLAppStore) GetItem(id string) (models.Item, error) {
+	item, err := scanItem(s.q.QueryRow(`SELECT `+itemColumns+` FROM items WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Item{}, fmt.Errorf("could not get item %s: %w", id, err)
+	}
+	return item, nil
+}
+
+// GetItems returns all items ordered by creation time
+func (s *SQLAppStore) GetItems() ([]models.Item, error) {
+	rows, err := s.q.Query(`SELECT ` + itemColumns + ` FROM items ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list items: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read item: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// QueryItems filters, sorts and pages items in SQL, fetching one extra row
+// to learn whether another page follows
+func (s *SQLAppStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	var where []string
+	var args []any
+
+	if query.OrgID != "" {
+		where = append(where, "org_id = ?")
+		args = append(args, query.OrgID)
+	}
+	if query.CreatedBy != "" {
+		where = append(where, "created_by = ?")
+		args = append(args, query.CreatedBy)
+	}
+	if query.ExternalID != "" {
+		where = append(where, "external_id = ?")
+		args = append(args, query.ExternalID)
+	}
+	if !query.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if query.IsActive != nil {
+		where = append(where, "is_active = ?")
+		args = append(args, *query.IsActive)
+	}
+
+	column := "created_at"
+	if query.Sort() == models.SortByName {
+		column = "name"
+	}
+	direction, op := "ASC", ">"
+	if query.Descending {
+		direction, op = "DESC", "<"
+	}
+
+	if query.After != nil {
+		var key any = query.After.CreatedAt
+		if column == "name" {
+			key = query.After.Name
+		}
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+		args = append(args, key, key, query.After.ID)
+	}
+
+	stmt := `SELECT ` + itemColumns + ` FROM items`
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	limit := query.PageSize()
+	stmt += fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT ?`, column, direction)
+	args = append(args, limit+1)
+
+	rows, err := s.q.Query(stmt, args...)
+	if err != nil {
+		return models.ItemPage{}, fmt.Errorf("could not query items: %w", err)
+	}
+	defer rows.Close()
+
+	page := models.ItemPage{Items: []models.Item{}}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return models.ItemPage{}, fmt.Errorf("could not read item: %w", err)
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return models.ItemPage{}, err
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+func (s *SQLAppStore) DeleteItem(id string) error {
+	res, err := s.q.Exec(`DELETE FROM items WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not delete item %s: %w", id, err)
+	}
+	if err := expectOneRow(res, "item", id); err != nil {
+		return err
+	}
+	s.unindexed(id)
+	return nil
+}
+
+func (s *SQLAppStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
+	res, err := s.q.Exec(`UPDATE items SET deleted_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL`, at.UTC(), time.Now().UTC(), id)
+	if err != nil {
+		return models.Item{}, fmt.Errorf("could not delete item %s: %w", id, err)
+	}
+	if err := expectOneRow(res, "item", id); err != nil {
+		return models.Item{}, err
+	}
+	return s.reread(id)
+}
+
+func (s *SQLAppStore) RestoreItem(id string) (models.Item, error) {
+	res, err := s.q.Exec(`UPDATE items SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return models.Item{}, fmt.Errorf("could not restore item %s: %w", id, err)
+	}
+	if err := expectOneRow(res, "item", id); err != nil {
+		// The item may exist but not be deleted
+		if _, getErr := s.GetItem(id); getErr != nil {
+			return models.Item{}, getErr
+		}
+		return models.Item{}, fmt.Errorf("item %s is not deleted: %w", id, models.ErrConflict)
+	}
+	return s.reread(id)
+}
+
+// reread gets an item after changing it and updates the search index
+func (s *SQLAppStore) reread(id string) (models.Item, error) {
+	item, err := s.GetItem(id)
+	if err != nil {
+		return models.Item{}, err
+	}
+	s.indexed(item)
+	return item, nil
+}
+
+// SearchItems finds the matching item IDs in the index and reads the items
+// in order of relevance
+func (s *SQLAppStore) SearchItems(query models.ItemSearch) ([]models.ItemSearchResult, error) {
+	hits := s.index.Search(query)
+	if len(hits) == 0 {
+		return []models.ItemSearchResult{}, nil
+	}
+
+	args := make([]any, len(hits))
+	for i, hit := range hits {
+		args[i] = hit.ID
+	}
+	placeholders := strings.Repeat("?, ", len(hits)-1) + "?"
+	rows, err := s.q.Query(`SELECT `+itemColumns+` FROM items WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not search items: %w", err)
+	}
+	defer rows.Close()
+
+	items := map[string]models.Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not read item: %w", err)
+		}
+		items[item.ID] = item
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]models.ItemSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if item, ok := items[hit.ID]; ok {
+			results = append(results, models.ItemSearchResult{Item: item, Score: hit.Score})
+		}
+	}
+	return results, nil
+}
+
+// PurgeItems permanently removes items soft-deleted before deletedBefore
+func (s *SQLAppStore) PurgeItems(deletedBefore time.Time) (int, error) {
+	res, err := s.q.Exec(`DELETE FROM items WHERE deleted_at IS NOT NULL AND deleted_at < ?`, deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("could not purge items: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLAppStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	var item models.Item
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		item, err = scanItem(tx.QueryRow(`SELECT `+itemColumns+` FROM items WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("could not get item %s: %w", id, err)
+		}
+
+		if err := update.CheckVersion(item); err != nil {
+			return err
+		}
+		item.Apply(update)
+		item.UpdatedAt = time.Now().UTC()
+		item.Version++
+
+		_, err = tx.Exec(`UPDATE items SET name = ?, external_id = ?, org_id = ?, is_active = ?, updated_at = ?, version = ? WHERE id = ?`,
+			item.Name, item.ExternalID, item.OrgID, item.IsActive, item.UpdatedAt, item.Version, id)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("external ID %q in org %q: %w", item.ExternalID, item.OrgID, models.ErrConflict)
+		}
+		if err != nil {
+			return fmt.Errorf("could not update item %s: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Item{}, err
+	}
+	s.indexed(item)
+	return item, nil
+}
+
+const userColumns = `id, name, password_hash, is_admin, created_at`
+
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Name, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
+	return user, err
+}
+
+func (s *SQLAppStore) CreateUser(input models.CreateUserInput) (models.User, error) {
+	user := models.User{
+		ID:           newID("user"),
+		Name:         input.Name,
+		PasswordHash: input.PasswordHash,
+		IsAdmin:      input.IsAdmin,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	_, err := s.q.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?)`, user.ID, user.Name, user.PasswordHash, user.IsAdmin, user.CreatedAt)
+	if isUniqueViolation(err) {
+		return models.User{}, fmt.Errorf("user name %q: %w", user.Name, models.ErrConflict)
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("could not create user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *SQLAppStore) GetUser(id string) (models.User, error) {
+	user, err := scanUser(s.q.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("could not get user %s: %w", id, err)
+	}
+	return user, nil
+}
+
+func (s *SQLAppStore) GetUserByName(name string) (models.User, error) {
+	user, err := scanUser(s.q.QueryRow(`SELECT `+userColumns+` FROM users WHERE name = ?`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, fmt.Errorf("user named %q: %w", name, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("could not get user named %q: %w", name, err)
+	}
+	return user, nil
+}
+
+func (s *SQLAppStore) UpdateUser(id string, updates map[string]any) (models.User, error) {
+	var user models.User
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("could not get user %s: %w", id, err)
+		}
+
+		user.ApplyUpdates(updates)
+
+		_, err = tx.Exec(`UPDATE users SET name = ? WHERE id = ?`, user.Name, id)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("user name %q: %w", user.Name, models.ErrConflict)
+		}
+		if err != nil {
+			return fmt.Errorf("could not update user %s: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+func (s *SQLAppStore) DeleteUser(id string) error {
+	res, err := s.q.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not delete user %s: %w", id, err)
+	}
+	return expectOneRow(res, "user", id)
+}
+
+const sessionColumns = `id, user_id, token_hash, created_at, expires_at`
+
+func scanSession(row rowScanner) (models.Session, error) {
+	var session models.Session
+	var expiresAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.TokenHash, &session.CreatedAt, &expiresAt)
+	session.ExpiresAt = expiresAt.Time
+	return session, err
+}
+
+func (s *SQLAppStore) CreateSession(input models.CreateSessionInput) (models.Session, error) {
+	if _, err := s.GetUser(input.UserID); err != nil {
+		return models.Session{}, err
+	}
+
+	session := models.Session{
+		ID:        newID("session"),
+		UserID:    input.UserID,
+		TokenHash: input.TokenHash,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	var expiresAt sql.NullTime
+	if !session.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: session.ExpiresAt.UTC(), Valid: true}
+	}
+
+	_, err := s.q.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.TokenHash, session.CreatedAt, expiresAt)
+	if err != nil {
+		return models.Session{}, fmt.Errorf("could not create session: %w", err)
+	}
+	return session, nil
+}
+
+func (s *SQLAppStore) GetSession(id string) (models.Session, error) {
+	session, err := scanSession(s.q.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Session{}, fmt.Errorf("could not get session %s: %w", id, err)
+	}
+	return session, nil
+}
+
+func (s *SQLAppStore) GetSessionByToken(tokenHash string) (models.Session, error) {
+	if tokenHash == "" {
+		return models.Session{}, fmt.Errorf("session for token: %w", models.ErrNotFound)
+	}
+
+	session, err := scanSession(s.q.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token_hash = ?`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Session{}, fmt.Errorf("session for token: %w", models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Session{}, fmt.Errorf("could not get session by token: %w", err)
+	}
+	return session, nil
+}
+
+func (s *SQLAppStore) DeleteSession(id string) error {
+	res, err := s.q.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not delete session %s: %w", id, err)
+	}
+	return expectOneRow(res, "session", id)
+}
+
+const orgColumns = `id, name, created_at`
+
+func scanOrg(row rowScanner) (models.Org, error) {
+	var org models.Org
+	err := row.Scan(&org.ID, &org.Name, &org.CreatedAt)
+	return org, err
+}
+
+func (s *SQLAppStore) CreateOrg(input models.CreateOrgInput) (models.Org, error) {
+	org := models.Org{
+		ID:        newID("org"),
+		Name:      input.Name,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := s.q.Exec(`INSERT INTO orgs (`+orgColumns+`) VALUES (?, ?, ?)`, org.ID, org.Name, org.CreatedAt)
+	if err != nil {
+		return models.Org{}, fmt.Errorf("could not create org: %w", err)
+	}
+	return org, nil
+}
+
+func (s *SQLAppStore) GetOrg(id string) (models.Org, error) {
+	org, err := scanOrg(s.q.QueryRow(`SELECT `+orgColumns+` FROM orgs WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Org{}, fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+	}
+	if err != nil {
+		return models.Org{}, fmt.Errorf("could not get org %s: %w", id, err)
+	}
+	return org, nil
+}
+
+func (s *SQLAppStore) GetOrgs() ([]models.Org, error) {
+	rows, err := s.q.Query(`SELECT ` + orgColumns + ` FROM orgs ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list orgs: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []models.Org{}
+	for rows.Next() {
+		org, err := scanOrg(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan org: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// DeleteOrg removes an org; its memberships go with it via ON DELETE
+// CASCADE, and its soft-deleted items are purged along with it
+func (s *SQLAppStore) DeleteOrg(id string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		// The check for items is part of the delete, so no item can be
+		// created in between
+		res, err := tx.Exec(`DELETE FROM orgs WHERE id = ? AND NOT EXISTS (
+			SELECT 1 FROM items WHERE org_id = ? AND deleted_at IS NULL
+		)`, id, id)
+		if err != nil {
+			return fmt.Errorf("could not delete org %s: %w", id, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			_, err := scanOrg(tx.QueryRow(`SELECT `+orgColumns+` FROM orgs WHERE id = ?`, id))
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+			}
+			if err != nil {
+				return fmt.Errorf("could not get org %s: %w", id, err)
+			}
+			return fmt.Errorf("org %s still has items: %w", id, models.ErrConflict)
+		}
+
+		// Soft-deleted items could otherwise be restored into an org that
+		// no longer exists
+		if _, err := tx.Exec(`DELETE FROM items WHERE org_id = ?`, id); err != nil {
+			return fmt.Errorf("could not purge the items of org %s: %w", id, err)
+		}
+		return nil
+	})
+}
+
+const membershipColumns = `org_id, user_id, role, created_at`
+
+func scanMembership(row rowScanner) (models.Membership, error) {
+	var m models.Membership
+	err := row.Scan(&m.OrgID, &m.UserID, &m.Role, &m.CreatedAt)
+	return m, err
+}
+
+// AddMember adds a user to an org in the given role. Adding an existing
+// member changes their role.
+func (s *SQLAppStore) AddMember(orgID, userID string, role models.Role) (models.Membership, error) {
+	if _, err := s.GetOrg(orgID); err != nil {
+		return models.Membership{}, err
+	}
+	if _, err := s.GetUser(userID); err != nil {
+		return models.Membership{}, err
+	}
+
+	_, err := s.q.Exec(`INSERT INTO memberships (`+membershipColumns+`) VALUES (?, ?, ?, ?)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role`,
+		orgID, userID, role, time.Now().UTC())
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("could not add %s to org %s: %w", userID, orgID, err)
+	}
+
+	m, err := scanMembership(s.q.QueryRow(`SELECT `+membershipColumns+` FROM memberships WHERE org_id = ? AND user_id = ?`, orgID, userID))
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("could not get membership of %s in %s: %w", userID, orgID, err)
+	}
+	return m, nil
+}
+
+func (s *SQLAppStore) RemoveMember(orgID, userID string) error {
+	res, err := s.q.Exec(`DELETE FROM memberships WHERE org_id = ? AND user_id = ?`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("could not remove %s from org %s: %w", userID, orgID, err)
+	}
+	return expectOneRow(res, "membership of "+userID+" in", orgID)
+}
+
+func (s *SQLAppStore) GetMembers(orgID string) ([]models.Membership, error) {
+	if _, err := s.GetOrg(orgID); err != nil {
+		return nil, err
+	}
+	return s.queryMemberships(`WHERE org_id = ?`, orgID)
+}
+
+func (s *SQLAppStore) GetMemberships(userID string) ([]models.Membership, error) {
+	return s.queryMemberships(`WHERE user_id = ?`, userID)
+}
+
+func (s *SQLAppStore) queryMemberships(where string, arg string) ([]models.Membership, error) {
+	rows, err := s.q.Query(`SELECT `+membershipColumns+` FROM memberships `+where+` ORDER BY created_at, org_id, user_id`, arg)
+	if err != nil {
+		return nil, fmt.Errorf("could not list memberships: %w", err)
+	}
+	defer rows.Close()
+
+	memberships := []models.Membership{}
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan membership: %w", err)
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// expectOneRow turns a statement that touched no rows into a not found error
+func expectOneRow(res sql.Result, kind, id string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s %s: %w", kind, id, models.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package store_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/internal/store"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+	"github.com/espennoreng/learn-go-with-tests/velo/storetest"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func newTestSQLiteStore(t testing.TB, path string) *store.SQLAppStore {
+	t.Helper()
+
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("could not open sqlite store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLAppStore(t *testing.T) {
+	storetest.Run(t, func() models.AppStore {
+		return newTestSQLiteStore(t, filepath.Join(t.TempDir(), "velo.db"))
+	})
+}
+
+func TestSQLAppStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velo.db")
+
+	first, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("could not open sqlite store: %v", err)
+	}
+	created, err := first.CreateItem(models.CreateItemInput{Name: "survives restarts"})
+	if err != nil {
+		t.Fatalf("could not create item: %v", err)
+	}
+	first.Close()
+
+	// Reopening runs the migrations again, which must be a no-op
+	reopened := newTestSQLiteStore(t, path)
+
+	got, err := reopened.GetItem(created.ID)
+	if err != nil {
+		t.Fatalf("item was not persisted: %v", err)
+	}
+	if got.Name != created.Name {
+		t.Errorf("got name %q, want %q", got.Name, created.Name)
+	}
+	if !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("got CreatedAt %v, want %v", got.CreatedAt, created.CreatedAt)
+	}
+}
+
+func TestSQLAppStoreBatchIsTransactional(t *testing.T) {
+	s := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "velo.db"))
+	sink := audit.NewMemorySink(api.DefaultAuditBuffer)
+	handler := api.NewHandler(s, api.WithAuditSink(sink))
+	existing, _ := s.CreateItem(models.CreateItemInput{Name: "existing"})
+
+	body := []byte(`{"operations": [
+		{"op": "create", "item": {"name": "new"}},
+		{"op": "update", "id": "` + existing.ID + `", "patch": {"name": "renamed"}},
+		{"op": "delete", "id": "missing"},
+		{"op": "create", "item": {"name": "never"}}
+	]}`)
+	response := testutils.MakeRequest(t, handler, http.MethodPost, "/items:batch", body)
+	testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+	var got api.BatchResponse
+	json.NewDecoder(response.Body).Decode(&got)
+	if !got.Transactional || !got.RolledBack {
+		t.Errorf("got transactional %v, rolled back %v, want both", got.Transactional, got.RolledBack)
+	}
+	want := []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusNotFound, http.StatusFailedDependency}
+	for i, result := range got.Results {
+		if result.Status != want[i] {
+			t.Errorf("operation %d got status %d, want %d", i, result.Status, want[i])
+		}
+	}
+
+	items, _ := s.GetItems()
+	if len(items) != 1 || items[0].Name != "existing" {
+		t.Errorf("got items %+v, want only the untouched existing item", items)
+	}
+	if page, _ := sink.Query(audit.Filter{}); len(page.Events) != 0 {
+		t.Errorf("got %d audit events, want none for a rolled back batch", len(page.Events))
+	}
+
+	t.Run("commits when every operation succeeds", func(t *testing.T) {
+		body := []byte(`{"operations": [
+			{"op": "create", "item": {"name": "new"}},
+			{"op": "update", "id": "` + existing.ID + `", "patch": {"name": "renamed"}}
+		]}`)
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/items:batch", body)
+
+		var got api.BatchResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.RolledBack {
+			t.Error("want the batch to be committed")
+		}
+
+		items, _ := s.GetItems()
+		if len(items) != 2 {
+			t.Errorf("got %d items, want 2", len(items))
+		}
+		if item, _ := s.GetItem(existing.ID); item.Name != "renamed" {
+			t.Errorf("got name %q, want renamed", item.Name)
+		}
+		if page, _ := sink.Query(audit.Filter{}); len(page.Events) != 2 {
+			t.Errorf("got %d audit events, want one per operation", len(page.Events))
+		}
+	})
+}
+
+func TestSQLAppStoreSearchIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velo.db")
+
+	first, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("could not open sqlite store: %v", err)
+	}
+	created, _ := first.CreateItem(models.CreateItemInput{Name: "blue mug"})
+	first.Close()
+
+	t.Run("is rebuilt when the store is opened", func(t *testing.T) {
+		reopened := newTestSQLiteStore(t, path)
+
+		results, err := reopened.SearchItems(models.ItemSearch{Text: "mug"})
+		if err != nil {
+			t.Fatalf("SearchItems returned error: %v", err)
+		}
+		if len(results) != 1 || results[0].Item.ID != created.ID {
+			t.Errorf("got %+v, want the item created before reopening", results)
+		}
+	})
+
+	t.Run("ignores changes that were rolled back", func(t *testing.T) {
+		s := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "velo.db"))
+
+		errRollBack := errors.New("roll back")
+		err := s.InTx(func(tx models.AppStore) error {
+			if _, err := tx.CreateItem(models.CreateItemInput{Name: "red mug"}); err != nil {
+				return err
+			}
+			return errRollBack
+		})
+		if !errors.Is(err, errRollBack) {
+			t.Fatalf("got error %v, want %v", err, errRollBack)
+		}
+
+		if results, _ := s.SearchItems(models.ItemSearch{Text: "mug"}); len(results) != 0 {
+			t.Errorf("got %+v, want nothing from a rolled back transaction", results)
+		}
+	})
+}
+
+func TestSQLAppStorePutExternalItem(t *testing.T) {
+	s := newTestSQLiteStore(t, filepath.Join(t.TempDir(), "velo.db"))
+	handler := api.NewHandler(s)
+
+	first := testutils.MakeRequest(t, handler, http.MethodPut, "/orgs/org-1/items/external/ext-42", []byte(`{"name": "Blue mug"}`))
+	testutils.AssertStatus(t, first.Code, http.StatusCreated)
+	second := testutils.MakeRequest(t, handler, http.MethodPut, "/orgs/org-1/items/external/ext-42", []byte(`{"name": "Red mug"}`))
+	testutils.AssertStatus(t, second.Code, http.StatusOK)
+
+	items, _ := s.GetItems()
+	if len(items) != 1 || items[0].Name != "Red mug" {
+		t.Errorf("got items %+v, want one item named Red mug", items)
+	}
+}
+
+func TestSQLAppStoreKeepsWebhooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velo.db")
+
+	first := newTestSQLiteStore(t, path)
+	dispatcher := webhook.NewDispatcher()
+	defer dispatcher.Close()
+	if err := dispatcher.Persist(first); err != nil {
+		t.Fatalf("Persist returned error: %v", err)
+	}
+	kept, err := dispatcher.Subscribe("org-1", "https://example.com/kept", []string{"item.created"})
+	if err != nil {
+		t.Fatalf("Subscribe returned error: %v", err)
+	}
+	removed, _ := dispatcher.Subscribe("org-1", "https://example.com/removed", nil)
+	if _, err := dispatcher.Unsubscribe("org-1", removed.ID); err != nil {
+		t.Fatalf("Unsubscribe returned error: %v", err)
+	}
+	first.Close()
+
+	restarted := webhook.NewDispatcher()
+	defer restarted.Close()
+	if err := restarted.Persist(newTestSQLiteStore(t, path)); err != nil {
+		t.Fatalf("Persist returned error: %v", err)
+	}
+	got := restarted.Subscriptions("org-1")
+	if len(got) != 1 || got[0].ID != kept.ID || got[0].Secret != kept.Secret || !slices.Equal(got[0].Events, kept.Events) {
+		t.Errorf("got subscriptions %+v after restarting, want only %+v", got, kept)
+	}
+}
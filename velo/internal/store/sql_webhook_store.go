@@ -0,0 +1,70 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+)
+
+const webhookColumns = `id, org_id, url, events, secret, created_at`
+
+func scanWebhook(row rowScanner) (webhook.Subscription, error) {
+	var s webhook.Subscription
+	var events string
+	if err := row.Scan(&s.ID, &s.OrgID, &s.URL, &events, &s.Secret, &s.CreatedAt); err != nil {
+		return webhook.Subscription{}, err
+	}
+	if err := json.Unmarshal([]byte(events), &s.Events); err != nil {
+		return webhook.Subscription{}, fmt.Errorf("webhook %s has invalid events: %w", s.ID, err)
+	}
+	return s, nil
+}
+
+// CreateWebhook saves a subscription. It implements
+// webhook.SubscriptionStore.
+func (s *SQLAppStore) CreateWebhook(sub webhook.Subscription) error {
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	events, err := json.Marshal(sub.Events)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.q.Exec(`INSERT INTO webhooks (`+webhookColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		sub.ID, sub.OrgID, sub.URL, string(events), sub.Secret, sub.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("could not create webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhooks returns every saved subscription in the order they were
+// created
+func (s *SQLAppStore) GetWebhooks() ([]webhook.Subscription, error) {
+	rows, err := s.q.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []webhook.Subscription{}
+	for rows.Next() {
+		sub, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeleteWebhook removes a saved subscription
+func (s *SQLAppStore) DeleteWebhook(id string) error {
+	res, err := s.q.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not delete webhook %s: %w", id, err)
+	}
+	return expectOneRow(res, "webhook", id)
+}
@@ -1,8 +1,76 @@
 package models
 
-type AppStore interface {
+import (
+	"context"
+	"time"
+)
+
+// ItemStore persists items
+type ItemStore interface {
+	CreateItem(input CreateItemInput) (Item, error)
 	GetItem(id string) (Item, error)
 	GetItems() ([]Item, error)
+	QueryItems(query ItemQuery) (ItemPage, error)
+	SearchItems(search ItemSearch) ([]ItemSearchResult, error)
 	DeleteItem(id string) error
-	UpdateItem(id string, update map[string]any) (Item, error)
+	SoftDeleteItem(id string, at time.Time) (Item, error)
+	// RestoreItem undoes a soft delete. It fails with ErrConflict for an
+	// item that is not deleted.
+	RestoreItem(id string) (Item, error)
+	PurgeItems(deletedBefore time.Time) (int, error)
+	UpdateItem(id string, update ItemUpdate) (Item, error)
+}
+
+// UserStore persists users
+type UserStore interface {
+	CreateUser(input CreateUserInput) (User, error)
+	GetUser(id string) (User, error)
+	GetUserByName(name string) (User, error)
+	UpdateUser(id string, update map[string]any) (User, error)
+	DeleteUser(id string) error
+}
+
+// SessionStore persists sessions
+type SessionStore interface {
+	CreateSession(input CreateSessionInput) (Session, error)
+	GetSession(id string) (Session, error)
+	GetSessionByToken(tokenHash string) (Session, error)
+	DeleteSession(id string) error
+}
+
+// OrgStore persists organisations and their members
+type OrgStore interface {
+	CreateOrg(input CreateOrgInput) (Org, error)
+	GetOrg(id string) (Org, error)
+	GetOrgs() ([]Org, error)
+	// DeleteOrg removes an org, its memberships and its soft-deleted
+	// items. It fails with ErrConflict while the org still has items that
+	// are not deleted.
+	DeleteOrg(id string) error
+	AddMember(orgID, userID string, role Role) (Membership, error)
+	RemoveMember(orgID, userID string) error
+	GetMembers(orgID string) ([]Membership, error)
+	GetMemberships(userID string) ([]Membership, error)
+}
+
+// AppStore is the full storage contract the API depends on
+type AppStore interface {
+	ItemStore
+	UserStore
+	SessionStore
+	OrgStore
+}
+
+// Transactor is implemented by stores that can apply several changes
+// atomically. The store passed to fn sees the changes made so far; they are
+// committed if fn returns nil and rolled back otherwise.
+type Transactor interface {
+	InTx(fn func(tx AppStore) error) error
+}
+
+// Pinger is implemented by stores that depend on something that can become
+// unreachable, such as a database server. Ping reports whether the store can
+// serve requests.
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,19 @@
+package models
+
+import "errors"
+
+// ErrNotFound is wrapped by every store error caused by a missing record,
+// so callers can tell it apart from a failing backend with errors.Is.
+var ErrNotFound = errors.New("not found")
+
+// ErrForbidden is wrapped by errors for operations the caller may not
+// perform, such as moving an item into another organisation.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrVersionMismatch is wrapped by store errors for conditional updates of
+// a record that has changed since the caller read it.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrConflict is wrapped by store errors caused by a uniqueness constraint,
+// such as creating a user with a name that is already taken.
+var ErrConflict = errors.New("conflict")
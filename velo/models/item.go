@@ -1,14 +1,146 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type Item struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	ExternalID string    `json:"external_id"`
-	OrgID      string    `json:"org_id"`
-	IsActive   string    `json:"is_active"`
-	CreatedAt  time.Time `json:"created_at"`
-	CreatedBy  string    `json:"created_by"`
-	DeletedAt  string    `json:"deleted_at"`
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	ExternalID string     `json:"external_id"`
+	OrgID      string     `json:"org_id"`
+	IsActive   bool       `json:"is_active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	CreatedBy  string     `json:"created_by"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+	// Version starts at 1 and goes up by one with every change to the item
+	Version int64 `json:"version"`
+}
+
+// UnmarshalJSON decodes an item, also accepting the legacy encoding where
+// is_active was the string "true"/"false" and deleted_at was "" when unset.
+func (i *Item) UnmarshalJSON(data []byte) error {
+	type plainItem Item
+	aux := struct {
+		*plainItem
+		IsActive  json.RawMessage `json:"is_active"`
+		DeletedAt json.RawMessage `json:"deleted_at"`
+	}{plainItem: (*plainItem)(i)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	isActive, err := DecodeLegacyBool(aux.IsActive)
+	if err != nil {
+		return fmt.Errorf("is_active: %w", err)
+	}
+	i.IsActive = isActive
+
+	deletedAt, err := decodeLegacyTime(aux.DeletedAt)
+	if err != nil {
+		return fmt.Errorf("deleted_at: %w", err)
+	}
+	i.DeletedAt = deletedAt
+	return nil
+}
+
+// DecodeLegacyBool accepts true, false, "true", "false" and "" (false)
+func DecodeLegacyBool(raw json.RawMessage) (bool, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false, nil
+	}
+
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return false, fmt.Errorf("want a boolean, got %s", raw)
+	}
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// decodeLegacyTime accepts null, "" (unset) and RFC 3339 timestamps
+func decodeLegacyTime(raw json.RawMessage) (*time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		return nil, nil
+	}
+
+	var t time.Time
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// IsDeleted reports whether the item has been soft-deleted
+func (i Item) IsDeleted() bool {
+	return i.DeletedAt != nil
+}
+
+// SharesExternalID reports whether two different items in the same org
+// have the same external ID, which stores must not allow. Items without an
+// external ID never clash.
+func (i Item) SharesExternalID(other Item) bool {
+	return i.ExternalID != "" && i.ID != other.ID && i.OrgID == other.OrgID && i.ExternalID == other.ExternalID
+}
+
+// DeletedBefore reports whether the item was soft-deleted before t
+func (i Item) DeletedBefore(t time.Time) bool {
+	return i.DeletedAt != nil && i.DeletedAt.Before(t)
+}
+
+// CreateItemInput holds the fields a caller may set when creating an item
+type CreateItemInput struct {
+	Name       string
+	ExternalID string
+	OrgID      string
+	CreatedBy  string
+}
+
+// ItemUpdate is a partial update of an item. Nil fields are left unchanged.
+type ItemUpdate struct {
+	Name       *string
+	ExternalID *string
+	OrgID      *string
+	IsActive   *bool
+
+	// IfVersion, when set, only lets the update apply to the item at that
+	// version. Stores reject it with ErrVersionMismatch otherwise.
+	IfVersion int64
+}
+
+// CheckVersion returns ErrVersionMismatch if the update is conditional on a
+// version other than the item's
+func (u ItemUpdate) CheckVersion(item Item) error {
+	if u.IfVersion != 0 && u.IfVersion != item.Version {
+		return fmt.Errorf("item %s is at version %d, not %d: %w", item.ID, item.Version, u.IfVersion, ErrVersionMismatch)
+	}
+	return nil
+}
+
+// Apply copies the fields set in update onto the item
+func (i *Item) Apply(update ItemUpdate) {
+	if update.Name != nil {
+		i.Name = *update.Name
+	}
+	if update.ExternalID != nil {
+		i.ExternalID = *update.ExternalID
+	}
+	if update.OrgID != nil {
+		i.OrgID = *update.OrgID
+	}
+	if update.IsActive != nil {
+		i.IsActive = *update.IsActive
+	}
 }
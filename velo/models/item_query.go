@@ -0,0 +1,137 @@
+package models
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// ItemSort names the field item listings are ordered by
+type ItemSort string
+
+const (
+	SortByCreatedAt ItemSort = "created_at"
+	SortByName      ItemSort = "name"
+)
+
+// Page size limits for ItemQuery
+const (
+	DefaultItemLimit = 50
+	MaxItemLimit     = 200
+)
+
+// ItemCursor marks the last item of a page. The next page starts with the
+// first item ordered after it, which keeps pages stable while items are added.
+type ItemCursor struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// CursorFor returns the cursor positioned at item
+func CursorFor(item Item) ItemCursor {
+	return ItemCursor{ID: item.ID, Name: item.Name, CreatedAt: item.CreatedAt}
+}
+
+// ItemQuery filters, orders and pages a listing of items.
+// Zero-valued filters match every item.
+type ItemQuery struct {
+	OrgID      string
+	CreatedBy  string
+	ExternalID string
+	IsActive   *bool
+
+	// IncludeDeleted also lists soft-deleted items
+	IncludeDeleted bool
+
+	SortBy     ItemSort
+	Descending bool
+
+	Limit int
+	After *ItemCursor
+}
+
+// ItemPage is one page of an item listing
+type ItemPage struct {
+	Items   []Item
+	HasMore bool
+}
+
+// PageSize returns the limit to use, applying the default and maximum
+func (q ItemQuery) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultItemLimit
+	}
+	return min(q.Limit, MaxItemLimit)
+}
+
+// Sort returns the sort field to use, defaulting to creation time
+func (q ItemQuery) Sort() ItemSort {
+	if q.SortBy == "" {
+		return SortByCreatedAt
+	}
+	return q.SortBy
+}
+
+// Matches reports whether item passes the query's filters
+func (q ItemQuery) Matches(item Item) bool {
+	if item.IsDeleted() && !q.IncludeDeleted {
+		return false
+	}
+	if q.OrgID != "" && item.OrgID != q.OrgID {
+		return false
+	}
+	if q.CreatedBy != "" && item.CreatedBy != q.CreatedBy {
+		return false
+	}
+	if q.ExternalID != "" && item.ExternalID != q.ExternalID {
+		return false
+	}
+	if q.IsActive != nil && item.IsActive != *q.IsActive {
+		return false
+	}
+	return true
+}
+
+// Compare orders two items by the query's sort field, breaking ties by ID
+func (q ItemQuery) Compare(a, b ItemCursor) int {
+	var c int
+	switch q.Sort() {
+	case SortByName:
+		c = cmp.Compare(a.Name, b.Name)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.ID, b.ID)
+	}
+	if q.Descending {
+		return -c
+	}
+	return c
+}
+
+// QueryItemSlice applies q to an in-memory slice of items. Stores that keep
+// their items in memory use it to implement QueryItems.
+func QueryItemSlice(items []Item, q ItemQuery) ItemPage {
+	matched := make([]Item, 0, len(items))
+	for _, item := range items {
+		if !q.Matches(item) {
+			continue
+		}
+		if q.After != nil && q.Compare(CursorFor(item), *q.After) <= 0 {
+			continue
+		}
+		matched = append(matched, item)
+	}
+
+	slices.SortFunc(matched, func(a, b Item) int {
+		return q.Compare(CursorFor(a), CursorFor(b))
+	})
+
+	limit := q.PageSize()
+	if len(matched) > limit {
+		return ItemPage{Items: matched[:limit], HasMore: true}
+	}
+	return ItemPage{Items: matched}
+}
@@ -0,0 +1,30 @@
+package models
+
+// Result limits for ItemSearch
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// ItemSearch finds live items whose names contain words starting with each
+// word of Text. An empty OrgID searches every org.
+type ItemSearch struct {
+	Text  string
+	OrgID string
+	Limit int
+}
+
+// ResultLimit returns the limit to use, applying the default and maximum
+func (s ItemSearch) ResultLimit() int {
+	if s.Limit <= 0 {
+		return DefaultSearchLimit
+	}
+	return min(s.Limit, MaxSearchLimit)
+}
+
+// ItemSearchResult is an item found by a search, most relevant first.
+// Scores only compare results of the same search.
+type ItemSearchResult struct {
+	Item  Item    `json:"item"`
+	Score float64 `json:"score"`
+}
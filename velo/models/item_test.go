@@ -0,0 +1,77 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+func TestItemUnmarshalJSON(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		json          string
+		wantActive    bool
+		wantDeletedAt *time.Time
+	}{
+		{"typed fields", `{"is_active": true, "deleted_at": null}`, true, nil},
+		{"typed deleted", `{"is_active": false, "deleted_at": "2024-05-01T12:00:00Z"}`, false, &deletedAt},
+		{"legacy strings", `{"is_active": "true", "deleted_at": ""}`, true, nil},
+		{"legacy inactive", `{"is_active": "false", "deleted_at": "2024-05-01T12:00:00.000000000Z"}`, false, &deletedAt},
+		{"legacy empty flag", `{"is_active": ""}`, false, nil},
+		{"fields missing", `{"id": "item-001"}`, false, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var item models.Item
+			if err := json.Unmarshal([]byte(tc.json), &item); err != nil {
+				t.Fatalf("could not unmarshal %s: %v", tc.json, err)
+			}
+
+			if item.IsActive != tc.wantActive {
+				t.Errorf("got IsActive %v, want %v", item.IsActive, tc.wantActive)
+			}
+			switch {
+			case tc.wantDeletedAt == nil && item.DeletedAt != nil:
+				t.Errorf("got DeletedAt %v, want nil", *item.DeletedAt)
+			case tc.wantDeletedAt != nil && (item.DeletedAt == nil || !item.DeletedAt.Equal(*tc.wantDeletedAt)):
+				t.Errorf("got DeletedAt %v, want %v", item.DeletedAt, *tc.wantDeletedAt)
+			}
+		})
+	}
+
+	t.Run("decodes the other fields", func(t *testing.T) {
+		var item models.Item
+		err := json.Unmarshal([]byte(`{"id": "item-001", "name": "An item", "is_active": "true"}`), &item)
+		if err != nil {
+			t.Fatalf("could not unmarshal: %v", err)
+		}
+		if item.ID != "item-001" || item.Name != "An item" {
+			t.Errorf("got %+v", item)
+		}
+	})
+
+	t.Run("rejects an invalid flag", func(t *testing.T) {
+		var item models.Item
+		if err := json.Unmarshal([]byte(`{"is_active": "sometimes"}`), &item); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("round trips", func(t *testing.T) {
+		original := models.Item{ID: "item-001", IsActive: true, DeletedAt: &deletedAt}
+		data, _ := json.Marshal(original)
+
+		var decoded models.Item
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("could not unmarshal %s: %v", data, err)
+		}
+		if !decoded.IsActive || decoded.DeletedAt == nil || !decoded.DeletedAt.Equal(deletedAt) {
+			t.Errorf("round trip changed the item: %s decoded to %+v", data, decoded)
+		}
+	})
+}
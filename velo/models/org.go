@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Org is an organisation. Items belong to exactly one org and users see
+// only the items of the orgs they are members of.
+type Org struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateOrgInput holds the fields a caller may set when creating an org
+type CreateOrgInput struct {
+	Name string
+}
+
+// Membership records that a user belongs to an org, and in which role
+type Membership struct {
+	OrgID     string    `json:"org_id"`
+	UserID    string    `json:"user_id"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
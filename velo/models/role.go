@@ -0,0 +1,38 @@
+package models
+
+// Role is what a member may do inside an org. Each role includes the
+// permissions of the roles before it: viewer, editor, admin.
+type Role string
+
+const (
+	// RoleViewer may read the org's items
+	RoleViewer Role = "viewer"
+	// RoleEditor may also create and change items
+	RoleEditor Role = "editor"
+	// RoleAdmin may also delete and restore items
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists every valid role, least privileged first
+var Roles = []Role{RoleViewer, RoleEditor, RoleAdmin}
+
+// rank orders roles by privilege; unknown roles rank below viewer
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Valid reports whether r is one of Roles
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Includes reports whether a member with role r has every permission of
+// required
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && r.rank() >= required.rank()
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Session is a logged in user. Clients authenticate with the token issued at
+// login; only its hash is stored.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	TokenHash string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the session is no longer valid at now
+func (s Session) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// CreateSessionInput holds the fields a caller may set when creating a session
+type CreateSessionInput struct {
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+}
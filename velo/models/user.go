@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type User struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"-"`
+	IsAdmin      bool      `json:"is_admin"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateUserInput holds the fields a caller may set when creating a user.
+// Users without a PasswordHash cannot log in.
+type CreateUserInput struct {
+	Name         string
+	PasswordHash string
+	IsAdmin      bool
+}
+
+// ApplyUpdates copies the recognised keys in updates onto the user
+func (u *User) ApplyUpdates(updates map[string]any) {
+	if name, ok := updates["Name"].(string); ok {
+		u.Name = name
+	}
+}
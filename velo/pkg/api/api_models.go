@@ -1,6 +1,17 @@
 package api
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+)
 
 // CreateItemRequest represents the data for creating a new item.
 type CreateItemRequest struct {
@@ -10,18 +21,397 @@ type CreateItemRequest struct {
 // Validate ensures the request data is valid.
 func (c *CreateItemRequest) Validate() error {
 	if c.Name == "" {
-		return errors.New("name is a required field")
+		return &ValidationError{Fields: []RejectedField{{Field: "name", Reason: "is a required field"}}}
+	}
+	return nil
+}
+
+// PutItemRequest is the full state of an item synced from a partner system.
+// IsActive defaults to true.
+type PutItemRequest struct {
+	Name     string `json:"name"`
+	IsActive *bool  `json:"is_active,omitempty"`
+}
+
+// Validate ensures the request data is valid.
+func (p *PutItemRequest) Validate() error {
+	if p.Name == "" {
+		return &ValidationError{Fields: []RejectedField{{Field: "name", Reason: "is a required field"}}}
 	}
 	return nil
 }
 
+// MinPasswordLength is the shortest password accepted for a user.
+const MinPasswordLength = 8
+
+// CreateUserRequest represents the data for creating a new user. Users
+// created without a password cannot log in.
 type CreateUserRequest struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
 }
 
+// Validate ensures the request data is valid.
 func (c *CreateUserRequest) Validate() error {
+	var rejected []RejectedField
+	if c.Name == "" {
+		rejected = append(rejected, RejectedField{Field: "name", Reason: "is a required field"})
+	}
+	if c.Password != "" && len(c.Password) < MinPasswordLength {
+		rejected = append(rejected, RejectedField{Field: "password", Reason: fmt.Sprintf("must be at least %d characters", MinPasswordLength)})
+	}
+	if len(rejected) > 0 {
+		return &ValidationError{Fields: rejected}
+	}
+	return nil
+}
+
+// LoginRequest holds the credentials exchanged for a session token.
+type LoginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// Validate ensures the request data is valid.
+func (l *LoginRequest) Validate() error {
+	var rejected []RejectedField
+	if l.Name == "" {
+		rejected = append(rejected, RejectedField{Field: "name", Reason: "is a required field"})
+	}
+	if l.Password == "" {
+		rejected = append(rejected, RejectedField{Field: "password", Reason: "is a required field"})
+	}
+	if len(rejected) > 0 {
+		return &ValidationError{Fields: rejected}
+	}
+	return nil
+}
+
+// LoginResponse is returned by a successful login. The token is only ever
+// shown here; send it as "Authorization: Bearer <token>".
+type LoginResponse struct {
+	Token   string         `json:"token"`
+	Session models.Session `json:"session"`
+}
+
+// HealthStatus is returned by the health and readiness checks
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// CreateOrgRequest represents the data for creating a new org.
+type CreateOrgRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate ensures the request data is valid.
+func (c *CreateOrgRequest) Validate() error {
 	if c.Name == "" {
-		return errors.New("name ise a required field")
+		return &ValidationError{Fields: []RejectedField{{Field: "name", Reason: "is a required field"}}}
+	}
+	return nil
+}
+
+// AddMemberRequest sets the role a user has in an org.
+type AddMemberRequest struct {
+	Role models.Role `json:"role"`
+}
+
+// Validate ensures the request data is valid.
+func (a *AddMemberRequest) Validate() error {
+	if !a.Role.Valid() {
+		return &ValidationError{Fields: []RejectedField{{Field: "role", Reason: fmt.Sprintf("must be one of %v", models.Roles)}}}
+	}
+	return nil
+}
+
+// Batch operation kinds
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// MaxBatchOperations is the most operations a single batch may contain.
+const MaxBatchOperations = 1000
+
+// BatchRequest applies several item changes in one request.
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one change in a batch: a create with Item, an update of
+// ID with Patch, or a delete of ID.
+type BatchOperation struct {
+	Op    string             `json:"op"`
+	ID    string             `json:"id,omitempty"`
+	Item  *CreateItemRequest `json:"item,omitempty"`
+	Patch *UpdateItemRequest `json:"patch,omitempty"`
+}
+
+// Validate ensures every operation in the batch is valid, naming rejected
+// fields by their position, such as "operations[2].item.name".
+func (b *BatchRequest) Validate() error {
+	var rejected []RejectedField
+	switch {
+	case len(b.Operations) == 0:
+		rejected = append(rejected, RejectedField{Field: "operations", Reason: "must contain at least one operation"})
+	case len(b.Operations) > MaxBatchOperations:
+		rejected = append(rejected, RejectedField{Field: "operations", Reason: fmt.Sprintf("must contain at most %d operations", MaxBatchOperations)})
+	}
+
+	for i, op := range b.Operations {
+		prefix := fmt.Sprintf("operations[%d].", i)
+		reject := func(field, reason string) {
+			rejected = append(rejected, RejectedField{Field: prefix + field, Reason: reason})
+		}
+		nested := func(field string, err error) {
+			var validationErr *ValidationError
+			if errors.As(err, &validationErr) {
+				for _, f := range validationErr.Fields {
+					reject(field+"."+f.Field, f.Reason)
+				}
+			}
+		}
+
+		switch op.Op {
+		case BatchCreate:
+			if op.Item == nil {
+				reject("item", "is a required field")
+			} else {
+				nested("item", op.Item.Validate())
+			}
+		case BatchUpdate:
+			if op.ID == "" {
+				reject("id", "is a required field")
+			}
+			if op.Patch == nil {
+				reject("patch", "is a required field")
+			} else {
+				nested("patch", op.Patch.Validate())
+			}
+		case BatchDelete:
+			if op.ID == "" {
+				reject("id", "is a required field")
+			}
+		default:
+			reject("op", fmt.Sprintf("must be one of %s, %s or %s", BatchCreate, BatchUpdate, BatchDelete))
+		}
+	}
+
+	if len(rejected) > 0 {
+		return &ValidationError{Fields: rejected}
 	}
 	return nil
 }
+
+// BatchResponse reports the outcome of every operation in a batch, in
+// request order. A Transactional batch is all or nothing: if any operation
+// fails, RolledBack is set and none of the changes are kept. Otherwise each
+// operation stands on its own.
+type BatchResponse struct {
+	Transactional bool          `json:"transactional"`
+	RolledBack    bool          `json:"rolled_back"`
+	Results       []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one operation: the status the equivalent
+// single-item request would have had, with the item or a problem.
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	Item   *models.Item `json:"item,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+// ImportReport lists which lines of an import created items and which were
+// rejected. Lines are numbered from 1, counting a CSV header row.
+type ImportReport struct {
+	Accepted []ImportedLine `json:"accepted"`
+	Rejected []RejectedLine `json:"rejected"`
+}
+
+// ImportedLine is a line that created an item
+type ImportedLine struct {
+	Line int    `json:"line"`
+	ID   string `json:"id"`
+}
+
+// RejectedLine is a line that did not create an item, with the problem the
+// equivalent POST /items would have answered
+type RejectedLine struct {
+	Line  int     `json:"line"`
+	Error Problem `json:"error"`
+}
+
+// CreateWebhookRequest subscribes a URL to events. Events lists the event
+// types wanted, or is empty for all of them.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Validate ensures the request data is valid.
+func (c *CreateWebhookRequest) Validate() error {
+	var rejected []RejectedField
+	if u, err := url.Parse(c.URL); c.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		rejected = append(rejected, RejectedField{Field: "url", Reason: "must be an absolute http or https URL"})
+	}
+	for i, event := range c.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			rejected = append(rejected, RejectedField{Field: fmt.Sprintf("events[%d]", i), Reason: fmt.Sprintf("must be one of %v", WebhookEvents)})
+		}
+	}
+	if len(rejected) > 0 {
+		return &ValidationError{Fields: rejected}
+	}
+	return nil
+}
+
+// CreateWebhookResponse is returned when a webhook is created. The secret
+// that signs its deliveries is only ever shown here.
+type CreateWebhookResponse struct {
+	Secret       string               `json:"secret"`
+	Subscription webhook.Subscription `json:"subscription"`
+}
+
+// RejectedField explains why a field in a request was not accepted.
+type RejectedField struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError is returned by Validate when one or more fields are rejected.
+type ValidationError struct {
+	Fields []RejectedField
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		names[i] = f.Field
+	}
+	return fmt.Sprintf("rejected fields: %s", strings.Join(names, ", "))
+}
+
+// UpdateItemRequest is a JSON Merge Patch (RFC 7396) of an item, keyed by the
+// item's JSON field names. A string field set to null is reset to "" and a
+// field that is absent is left unchanged.
+type UpdateItemRequest struct {
+	Name       *string
+	ExternalID *string
+	OrgID      *string
+	IsActive   *bool
+
+	rejected []RejectedField
+}
+
+// readOnlyItemFields are item fields that exist but cannot be patched.
+var readOnlyItemFields = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+	"created_by": true,
+	"deleted_at": true,
+	"version":    true,
+}
+
+// fieldDecoder stores a patched value in the request, returning the reason
+// it was rejected if the value is not acceptable.
+type fieldDecoder func(u *UpdateItemRequest, raw json.RawMessage) (reason string)
+
+// writableItemFields maps each patchable JSON field to its decoder.
+var writableItemFields = map[string]fieldDecoder{
+	"name":        stringField(func(u *UpdateItemRequest) **string { return &u.Name }),
+	"external_id": stringField(func(u *UpdateItemRequest) **string { return &u.ExternalID }),
+	"org_id":      stringField(func(u *UpdateItemRequest) **string { return &u.OrgID }),
+	"is_active":   decodeIsActive,
+}
+
+// stringField decodes a string, treating null as ""
+func stringField(target func(*UpdateItemRequest) **string) fieldDecoder {
+	return func(u *UpdateItemRequest, raw json.RawMessage) string {
+		value := ""
+		if string(raw) != "null" {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return "must be a string or null"
+			}
+		}
+		*target(u) = &value
+		return ""
+	}
+}
+
+// decodeIsActive accepts a boolean, or the legacy "true"/"false" strings
+func decodeIsActive(u *UpdateItemRequest, raw json.RawMessage) string {
+	if string(raw) == "null" {
+		return "must be a boolean"
+	}
+	value, err := models.DecodeLegacyBool(raw)
+	if err != nil {
+		return "must be a boolean"
+	}
+	u.IsActive = &value
+	return ""
+}
+
+// UnmarshalJSON reads a merge patch document. Problems with individual fields
+// are collected and reported by Validate rather than failing the decode.
+func (u *UpdateItemRequest) UnmarshalJSON(data []byte) error {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+	if patch == nil {
+		return errors.New("merge patch must be a JSON object")
+	}
+
+	*u = UpdateItemRequest{}
+	for field, raw := range patch {
+		if readOnlyItemFields[field] {
+			u.reject(field, "field is read-only")
+			continue
+		}
+
+		decode, ok := writableItemFields[field]
+		if !ok {
+			u.reject(field, "unknown field")
+			continue
+		}
+
+		if reason := decode(u, raw); reason != "" {
+			u.reject(field, reason)
+		}
+	}
+	return nil
+}
+
+func (u *UpdateItemRequest) reject(field, reason string) {
+	u.rejected = append(u.rejected, RejectedField{Field: field, Reason: reason})
+}
+
+// Validate reports every rejected field, or nil if the patch can be applied.
+func (u *UpdateItemRequest) Validate() error {
+	rejected := append([]RejectedField(nil), u.rejected...)
+	if u.Name != nil && *u.Name == "" {
+		rejected = append(rejected, RejectedField{Field: "name", Reason: "must not be empty"})
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Field < rejected[j].Field
+	})
+	return &ValidationError{Fields: rejected}
+}
+
+// ItemUpdate converts the request into the store's update type.
+func (u *UpdateItemRequest) ItemUpdate() models.ItemUpdate {
+	return models.ItemUpdate{
+		Name:       u.Name,
+		ExternalID: u.ExternalID,
+		OrgID:      u.OrgID,
+		IsActive:   u.IsActive,
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+)
+
+// Resource types recorded in audit events
+const (
+	ResourceItem       = "item"
+	ResourceUser       = "user"
+	ResourceSession    = "session"
+	ResourceOrg        = "org"
+	ResourceMembership = "membership"
+	ResourceWebhook    = "webhook"
+)
+
+// DefaultAuditBuffer is how many audit events are kept in memory when no
+// other sink is configured
+const DefaultAuditBuffer = 10000
+
+var auditParams = []Param{
+	{In: "query", Name: "resource_type", Type: "string", Description: "Only events for item, user, session, org, membership or webhook resources"},
+	{In: "query", Name: "resource_id", Type: "string", Description: "Only events for this resource"},
+	{In: "query", Name: "actor_id", Type: "string", Description: "Only events caused by this user"},
+	{In: "query", Name: "since", Type: "string", Description: "Only events at or after this RFC 3339 time"},
+	{In: "query", Name: "until", Type: "string", Description: "Only events before this RFC 3339 time"},
+	{In: "query", Name: "limit", Type: "integer", Description: "Page size, at most 200"},
+	{In: "query", Name: "cursor", Type: "string", Description: "Opaque cursor from the previous page's Link header"},
+}
+
+// Page sizes of GET /audit
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// auditCursor is the position encoded, as base64url JSON, in an opaque
+// GET /audit cursor
+type auditCursor struct {
+	After int `json:"a"`
+}
+
+func encodeAuditCursor(after int) string {
+	data, _ := json.Marshal(auditCursor{After: after})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAuditCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	var token auditCursor
+	if err := json.Unmarshal(data, &token); err != nil {
+		return 0, err
+	}
+	if token.After < 1 {
+		return 0, fmt.Errorf("cursor has no position")
+	}
+	return token.After, nil
+}
+
+// auditEvent describes a change to a resource made by the request's user.
+// before is nil for a create and after is nil for a delete.
+func (h *Handler) auditEvent(r *http.Request, action audit.Action, resourceType, resourceID, orgID string, before, after any) audit.Event {
+	event := audit.Event{
+		Time:         time.Now().UTC(),
+		OrgID:        orgID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		event.ActorID = user.ID
+	}
+
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		log.Printf("could not diff %s %s for the audit log: %v", resourceType, resourceID, err)
+	}
+	event.Changes = changes
+	return event
+}
+
+// record writes events to the audit sink and publishes them to webhooks.
+// The change has already been made, so a failure is logged rather than
+// failing the request.
+func (h *Handler) record(events ...audit.Event) {
+	for _, event := range events {
+		if err := h.audit.Record(event); err != nil {
+			log.Printf("could not record audit event for %s %s: %v", event.ResourceType, event.ResourceID, err)
+		}
+		h.publish(event)
+	}
+}
+
+// getAudit lists audit events matching the query parameters, oldest first
+// and a page at a time. Org admins see the events of their org; system
+// admins who have not selected an org see every event.
+func (h *Handler) getAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if filter.OrgID, err = orgScope(r); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	page, err := h.audit.Query(filter)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if page.Next != 0 {
+		w.Header().Set("Link", nextPageLink(r.URL, encodeAuditCursor(page.Next)))
+	}
+	respondWithJSON(w, http.StatusOK, page.Events)
+}
+
+// parseAuditFilter reads the parameters of GET /audit. Every invalid
+// parameter is reported in the returned error.
+func parseAuditFilter(values url.Values) (audit.Filter, error) {
+	filter := audit.Filter{
+		ResourceType: values.Get("resource_type"),
+		ResourceID:   values.Get("resource_id"),
+		ActorID:      values.Get("actor_id"),
+		Limit:        defaultAuditLimit,
+	}
+	var rejected []RejectedField
+
+	filter.Since = timeParam(values, "since", &rejected)
+	filter.Until = timeParam(values, "until", &rejected)
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		rejected = append(rejected, RejectedField{Field: "until", Reason: "must be after since"})
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			rejected = append(rejected, RejectedField{Field: "limit", Reason: fmt.Sprintf("must be a number between 1 and %d", maxAuditLimit)})
+		} else {
+			filter.Limit = limit
+		}
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		after, err := decodeAuditCursor(raw)
+		if err != nil {
+			rejected = append(rejected, RejectedField{Field: "cursor", Reason: "is not a valid cursor"})
+		} else {
+			filter.After = after
+		}
+	}
+
+	if len(rejected) > 0 {
+		return audit.Filter{}, &ValidationError{Fields: rejected}
+	}
+	return filter, nil
+}
+
+// timeParam parses an optional RFC 3339 query parameter, recording a
+// rejection if it is malformed
+func timeParam(values url.Values, name string, rejected *[]RejectedField) time.Time {
+	raw := values.Get(name)
+	if raw == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		*rejected = append(*rejected, RejectedField{Field: name, Reason: "must be an RFC 3339 time"})
+		return time.Time{}
+	}
+	return t
+}
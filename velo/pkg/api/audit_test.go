@@ -0,0 +1,230 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func getAuditEvents(t testing.TB, server http.Handler, token, query string) []audit.Event {
+	t.Helper()
+
+	response := makeRequestWithToken(t, server, http.MethodGet, "/audit"+query, token, nil)
+	testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+	var events []audit.Event
+	json.NewDecoder(response.Body).Decode(&events)
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	t.Run("records who changed an item and how", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "bike"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+		var item models.Item
+		json.NewDecoder(response.Body).Decode(&item)
+
+		makeRequestWithToken(t, server, http.MethodPatch, "/items/"+item.ID, session.Token, []byte(`{"is_active": false}`))
+		makeRequestWithToken(t, server, http.MethodDelete, "/items/"+item.ID, session.Token, nil)
+
+		events := getAuditEvents(t, server, session.Token, "?resource_type=item&resource_id="+item.ID)
+		if len(events) != 3 {
+			t.Fatalf("got %d events, want 3", len(events))
+		}
+		for i, action := range []audit.Action{audit.ActionCreate, audit.ActionUpdate, audit.ActionDelete} {
+			if events[i].Action != action || events[i].ActorID != session.Session.UserID || events[i].OrgID != item.OrgID {
+				t.Errorf("got event %+v, want %s by %s", events[i], action, session.Session.UserID)
+			}
+		}
+
+		if change := events[0].Changes["name"]; change.Before != nil || change.After != "bike" {
+			t.Errorf("got name change %+v on create, want null to bike", change)
+		}
+		if change := events[1].Changes["is_active"]; change.Before != true || change.After != false {
+			t.Errorf("got is_active change %+v, want true to false", change)
+		}
+		if _, ok := events[1].Changes["name"]; ok {
+			t.Error("want the unchanged name left out of the update")
+		}
+		if change := events[2].Changes["deleted_at"]; change.Before != nil || change.After == nil {
+			t.Errorf("got deleted_at change %+v, want it to be set", change)
+		}
+	})
+
+	t.Run("records users, sessions and memberships", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		admin := login(t, server, "admin", "hunter2hunter2")
+		acme := store.Orgs[0].ID
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "new", Password: "hunter2hunter2"})
+		response := testutils.MakeRequest(t, server, http.MethodPost, "/users", body)
+		var user models.User
+		json.NewDecoder(response.Body).Decode(&user)
+
+		body, _ = json.Marshal(api.AddMemberRequest{Role: models.RoleViewer})
+		makeRequestWithToken(t, server, http.MethodPut, "/orgs/"+acme+"/members/"+user.ID, admin.Token, body)
+		body, _ = json.Marshal(api.AddMemberRequest{Role: models.RoleEditor})
+		makeRequestWithToken(t, server, http.MethodPut, "/orgs/"+acme+"/members/"+user.ID, admin.Token, body)
+
+		events := getAuditEvents(t, server, admin.Token, "?resource_type=user&resource_id="+user.ID)
+		if len(events) != 1 || events[0].Action != audit.ActionCreate || events[0].ActorID != "" {
+			t.Errorf("got user events %+v, want one anonymous create", events)
+		}
+
+		events = getAuditEvents(t, server, admin.Token, "?resource_type=membership&resource_id="+user.ID)
+		if len(events) != 2 || events[0].Action != audit.ActionCreate || events[1].Action != audit.ActionUpdate {
+			t.Fatalf("got membership events %+v, want a create then an update", events)
+		}
+		if change := events[1].Changes["role"]; change.Before != "viewer" || change.After != "editor" {
+			t.Errorf("got role change %+v, want viewer to editor", change)
+		}
+
+		events = getAuditEvents(t, server, admin.Token, "?resource_type=session&actor_id="+admin.Session.UserID)
+		if len(events) != 1 || events[0].ResourceID != admin.Session.ID {
+			t.Errorf("got session events %+v, want the admin's login", events)
+		}
+	})
+
+	t.Run("records webhooks without their secret", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateWebhookRequest{URL: "https://example.com/hook"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/webhooks", session.Token, body)
+		var created api.CreateWebhookResponse
+		json.NewDecoder(response.Body).Decode(&created)
+		makeRequestWithToken(t, server, http.MethodDelete, "/webhooks/"+created.Subscription.ID, session.Token, nil)
+
+		events := getAuditEvents(t, server, session.Token, "?resource_type=webhook&resource_id="+created.Subscription.ID)
+		if len(events) != 2 || events[0].Action != audit.ActionCreate || events[1].Action != audit.ActionDelete {
+			t.Fatalf("got webhook events %+v, want a create then a delete", events)
+		}
+		if change := events[0].Changes["url"]; change.After != "https://example.com/hook" {
+			t.Errorf("got url change %+v, want the webhook's URL", change)
+		}
+		for _, event := range events {
+			if _, ok := event.Changes["secret"]; ok {
+				t.Errorf("got the secret in %+v", event)
+			}
+		}
+	})
+
+	t.Run("shows org admins only their org's events", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+		globex := login(t, server, "globex-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "secret"})
+		makeRequestWithToken(t, server, http.MethodPost, "/items", acme.Token, body)
+
+		if events := getAuditEvents(t, server, globex.Token, "?resource_type=item"); len(events) != 0 {
+			t.Errorf("got %d events, want none from another org", len(events))
+		}
+	})
+
+	t.Run("filters by time range", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "bike"})
+		makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+
+		past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		if events := getAuditEvents(t, server, session.Token, "?resource_type=item&since="+past+"&until="+future); len(events) != 1 {
+			t.Errorf("got %d events in range, want 1", len(events))
+		}
+		if events := getAuditEvents(t, server, session.Token, "?resource_type=item&since="+future); len(events) != 0 {
+			t.Errorf("got %d events after now, want none", len(events))
+		}
+	})
+
+	t.Run("follows Link headers through every page", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+		for _, name := range []string{"a", "b", "c"} {
+			body, _ := json.Marshal(api.CreateItemRequest{Name: name})
+			makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+		}
+
+		var names []any
+		url := "/audit?resource_type=item&limit=2"
+		for pages := 0; url != ""; pages++ {
+			if pages > 2 {
+				t.Fatal("pagination did not terminate")
+			}
+			response := makeRequestWithToken(t, server, http.MethodGet, url, session.Token, nil)
+			testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+			var events []audit.Event
+			json.NewDecoder(response.Body).Decode(&events)
+			for _, event := range events {
+				names = append(names, event.Changes["name"].After)
+			}
+
+			url = ""
+			if link := response.Header().Get("Link"); link != "" {
+				target, _, _ := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+				url = target
+			}
+		}
+
+		if want := []any{"a", "b", "c"}; !slices.Equal(names, want) {
+			t.Errorf("got %v, want %v", names, want)
+		}
+	})
+
+	t.Run("rejects invalid paging", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		for _, query := range []string{"?limit=0", "?limit=201", "?cursor=nonsense"} {
+			response := makeRequestWithToken(t, server, http.MethodGet, "/audit"+query, session.Token, nil)
+			testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejects a malformed time range", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/audit?since=yesterday", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		response = makeRequestWithToken(t, server, http.MethodGet, "/audit?since=2025-02-01T00:00:00Z&until=2025-01-01T00:00:00Z", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("is for org admins", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		editor, _ := store.CreateUser(models.CreateUserInput{Name: "editor", PasswordHash: store.Users[0].PasswordHash})
+		store.AddMember(store.Orgs[0].ID, editor.ID, models.RoleEditor)
+		session := login(t, server, "editor", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/audit", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("writes to the configured sink", func(t *testing.T) {
+		sink := audit.NewMemorySink(api.DefaultAuditBuffer)
+		handler := api.NewHandler(testutils.NewStubAppStore(), api.WithAuditSink(sink))
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "bike"})
+		testutils.MakeRequest(t, handler, http.MethodPost, "/items", body)
+
+		if page, _ := sink.Query(audit.Filter{ResourceType: api.ResourceItem}); len(page.Events) != 1 {
+			t.Errorf("got %d events in the sink, want 1", len(page.Events))
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+)
+
+// errBatchFailed rolls back a transactional batch once an operation fails
+var errBatchFailed = errors.New("batch operation failed")
+
+// batchItems applies a batch of item operations. The whole batch is
+// validated before anything runs. When the store is a models.Transactor the
+// operations run in one transaction that is rolled back if any of them
+// fails; otherwise they run one by one and each result stands on its own.
+func (h *Handler) batchItems(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	transactor, transactional := h.store.(models.Transactor)
+	if !transactional {
+		store, err := h.itemStore(r)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+
+		response := BatchResponse{Results: make([]BatchResult, len(req.Operations))}
+		for i, op := range req.Operations {
+			var event *audit.Event
+			response.Results[i], event = h.runBatchOperation(r, store, i, op)
+			if event != nil {
+				h.record(*event)
+			}
+		}
+		respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// Events are only recorded once the transaction has committed
+	response := BatchResponse{Transactional: true, Results: make([]BatchResult, len(req.Operations))}
+	var events []audit.Event
+	err := transactor.InTx(func(tx models.AppStore) error {
+		store, err := scopeStore(r, tx)
+		if err != nil {
+			return err
+		}
+
+		for i, op := range req.Operations {
+			var event *audit.Event
+			response.Results[i], event = h.runBatchOperation(r, store, i, op)
+			if event == nil {
+				rollBack(response.Results, i)
+				return errBatchFailed
+			}
+			events = append(events, *event)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		respondWithError(w, err)
+		return
+	}
+
+	response.RolledBack = err != nil
+	if !response.RolledBack {
+		h.record(events...)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// rollBack marks every result but the failed one as 424 Failed Dependency:
+// those before it were undone and those after it never ran
+func rollBack(results []BatchResult, failed int) {
+	for i := range results {
+		if i == failed {
+			continue
+		}
+		detail := fmt.Sprintf("rolled back because operation %d failed", failed)
+		if i > failed {
+			detail = fmt.Sprintf("not attempted because operation %d failed", failed)
+		}
+		problem := NewProblem(http.StatusFailedDependency, detail)
+		results[i] = BatchResult{Index: i, Status: problem.Status, Error: &problem}
+	}
+}
+
+// runBatchOperation applies one operation, checking it against the same
+// policy as the equivalent single-item request. It returns the audit event
+// for the change, or nil if the operation failed.
+func (h *Handler) runBatchOperation(r *http.Request, store models.AppStore, index int, op BatchOperation) (BatchResult, *audit.Event) {
+	item, status, event, err := h.applyBatchOperation(r, store, op)
+	if err != nil {
+		problem := problemFor(err)
+		return BatchResult{Index: index, Status: problem.Status, Error: &problem}, nil
+	}
+	result := BatchResult{Index: index, Status: status}
+	if status != http.StatusNoContent {
+		result.Item = &item
+	}
+	return result, &event
+}
+
+func (h *Handler) applyBatchOperation(r *http.Request, store models.AppStore, op BatchOperation) (models.Item, int, audit.Event, error) {
+	method, path := http.MethodPost, "/items"
+	switch op.Op {
+	case BatchUpdate:
+		method, path = http.MethodPatch, "/items/"+op.ID
+	case BatchDelete:
+		method, path = http.MethodDelete, "/items/"+op.ID
+	}
+	if err := h.authorizeOperation(r, method, path); err != nil {
+		return models.Item{}, 0, audit.Event{}, err
+	}
+
+	if op.Op == BatchCreate {
+		input := models.CreateItemInput{Name: op.Item.Name}
+		if user, ok := UserFromContext(r.Context()); ok {
+			input.CreatedBy = user.ID
+		}
+		item, err := store.CreateItem(input)
+		if err != nil {
+			return models.Item{}, 0, audit.Event{}, err
+		}
+		return item, http.StatusCreated, h.auditEvent(r, audit.ActionCreate, ResourceItem, item.ID, item.OrgID, nil, item), nil
+	}
+
+	current, err := liveItem(store, op.ID, false)
+	if err != nil {
+		return models.Item{}, 0, audit.Event{}, err
+	}
+
+	if op.Op == BatchUpdate {
+		item, err := store.UpdateItem(op.ID, op.Patch.ItemUpdate())
+		if err != nil {
+			return models.Item{}, 0, audit.Event{}, err
+		}
+		return item, http.StatusOK, h.auditEvent(r, audit.ActionUpdate, ResourceItem, op.ID, item.OrgID, current, item), nil
+	}
+
+	item, err := store.SoftDeleteItem(op.ID, time.Now())
+	if err != nil {
+		return models.Item{}, 0, audit.Event{}, err
+	}
+	return item, http.StatusNoContent, h.auditEvent(r, audit.ActionDelete, ResourceItem, op.ID, item.OrgID, current, item), nil
+}
+
+// authorizeOperation checks the caller may make the request an operation
+// stands in for. Without an authenticated user every operation is allowed,
+// as it is by Authorize.
+func (h *Handler) authorizeOperation(r *http.Request, method, path string) error {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+
+	policy, ok := h.policies.Lookup(method, path)
+	if !ok {
+		return nil
+	}
+	role, _ := RoleFromContext(r.Context())
+	if policy.Allows(rbac.Principal{Role: role, IsAdmin: user.IsAdmin}) {
+		return nil
+	}
+	return roleRequired(policy)
+}
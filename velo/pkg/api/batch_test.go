@@ -0,0 +1,136 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestBatchItems(t *testing.T) {
+	t.Run("applies each operation and reports its status", func(t *testing.T) {
+		store := testutils.NewStubAppStoreWithData()
+		handler := api.NewHandler(store)
+		first, second := store.Items[0].ID, store.Items[1].ID
+
+		body := []byte(`{"operations": [
+			{"op": "create", "item": {"name": "new"}},
+			{"op": "update", "id": "` + first + `", "patch": {"name": "renamed"}},
+			{"op": "delete", "id": "` + second + `"},
+			{"op": "delete", "id": "missing"}
+		]}`)
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/items:batch", body)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var got api.BatchResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.Transactional || got.RolledBack {
+			t.Errorf("got transactional %v, rolled back %v, want neither", got.Transactional, got.RolledBack)
+		}
+		assertBatchStatuses(t, got, http.StatusCreated, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+
+		if got.Results[0].Item == nil || got.Results[0].Item.Name != "new" {
+			t.Errorf("got created item %+v, want one named new", got.Results[0].Item)
+		}
+		if item, _ := store.GetItem(first); item.Name != "renamed" {
+			t.Errorf("got name %q, want the update to be kept", item.Name)
+		}
+		if item, _ := store.GetItem(second); !item.IsDeleted() {
+			t.Error("want the delete to be kept")
+		}
+	})
+
+	t.Run("rejects the whole batch if any operation is invalid", func(t *testing.T) {
+		store := testutils.NewStubAppStoreWithData()
+		handler := api.NewHandler(store)
+
+		body := []byte(`{"operations": [
+			{"op": "create", "item": {"name": "fine"}},
+			{"op": "create", "item": {"name": ""}},
+			{"op": "update", "patch": {"id": "x"}},
+			{"op": "rename"}
+		]}`)
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/items:batch", body)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+		var fields []string
+		for _, f := range problem.InvalidParams {
+			fields = append(fields, f.Field)
+		}
+		want := []string{"operations[1].item.name", "operations[2].id", "operations[2].patch.id", "operations[3].op"}
+		if len(fields) != len(want) {
+			t.Fatalf("got rejected fields %v, want %v", fields, want)
+		}
+		for i := range want {
+			if fields[i] != want[i] {
+				t.Errorf("got rejected fields %v, want %v", fields, want)
+				break
+			}
+		}
+
+		if len(store.Items) != 2 {
+			t.Errorf("got %d items, want nothing created", len(store.Items))
+		}
+	})
+
+	t.Run("rejects an empty batch", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/items:batch", []byte(`{"operations": []}`))
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("checks each operation against its route's role", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		acme := store.Orgs[0].ID
+		editor, _ := store.CreateUser(models.CreateUserInput{Name: "editor", PasswordHash: store.Users[0].PasswordHash})
+		store.AddMember(acme, editor.ID, models.RoleEditor)
+		session := login(t, server, "editor", "hunter2hunter2")
+
+		body := []byte(`{"operations": [
+			{"op": "create", "item": {"name": "allowed"}},
+			{"op": "delete", "id": "` + store.Items[0].ID + `"}
+		]}`)
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items:batch", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var got api.BatchResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		assertBatchStatuses(t, got, http.StatusCreated, http.StatusForbidden)
+		if got.Results[0].Item.OrgID != acme || got.Results[0].Item.CreatedBy != editor.ID {
+			t.Errorf("got item %+v, want it created in acme by the editor", got.Results[0].Item)
+		}
+	})
+
+	t.Run("viewers cannot send batches", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		viewer, _ := store.CreateUser(models.CreateUserInput{Name: "viewer", PasswordHash: store.Users[0].PasswordHash})
+		store.AddMember(store.Orgs[0].ID, viewer.ID, models.RoleViewer)
+		session := login(t, server, "viewer", "hunter2hunter2")
+
+		body := []byte(`{"operations": [{"op": "create", "item": {"name": "denied"}}]}`)
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items:batch", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+}
+
+func assertBatchStatuses(t testing.TB, got api.BatchResponse, want ...int) {
+	t.Helper()
+
+	if len(got.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got.Results), len(want))
+	}
+	for i, result := range got.Results {
+		if result.Index != i {
+			t.Errorf("result %d has index %d", i, result.Index)
+		}
+		if result.Status != want[i] {
+			t.Errorf("operation %d got status %d, want %d", i, result.Status, want[i])
+		}
+	}
+}
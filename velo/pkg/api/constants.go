@@ -2,5 +2,12 @@ package api
 
 // Content types
 const (
-	ContentTypeJSON = "application/json"
+	ContentTypeJSON        = "application/json"
+	ContentTypeProblemJSON = "application/problem+json"
+	ContentTypeCSV         = "text/csv"
+	ContentTypeNDJSON      = "application/x-ndjson"
 )
+
+// OrgHeader selects which of the user's orgs a request operates in. It may be
+// omitted by users who belong to exactly one org.
+const OrgHeader = "X-Org-ID"
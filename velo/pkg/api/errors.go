@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/idempotency"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type          string          `json:"type"`
+	Title         string          `json:"title"`
+	Status        int             `json:"status"`
+	Detail        string          `json:"detail,omitempty"`
+	InvalidParams []RejectedField `json:"invalid_params,omitempty"`
+}
+
+// NewProblem returns a problem for status using the generic "about:blank" type.
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+var (
+	// errEmptyBody is returned when a request that needs a body has none.
+	errEmptyBody = errors.New("request body is required")
+	// errMalformedJSON wraps any error from decoding a request body.
+	errMalformedJSON = errors.New("request body is not valid JSON")
+	// errUnauthenticated is returned when a request lacks a valid session.
+	errUnauthenticated = errors.New("a valid session token is required")
+	// errInvalidCredentials is returned when a login does not match a user.
+	errInvalidCredentials = errors.New("the name or password is incorrect")
+	// errNoOrg is returned when an authenticated user has not selected an
+	// org to work in.
+	errNoOrg = fmt.Errorf("%w: select an org with the %s header", models.ErrForbidden, OrgHeader)
+	// errNotMember is returned when the selected org is not one of the user's.
+	errNotMember = fmt.Errorf("%w: you are not a member of that org", models.ErrForbidden)
+	// errNotAcceptable is returned when none of the media types in the
+	// Accept header can be produced.
+	errNotAcceptable = fmt.Errorf("the Accept header must allow %s or %s", ContentTypeCSV, ContentTypeNDJSON)
+	// errUnsupportedMediaType is returned for a body in a format the route
+	// does not read.
+	errUnsupportedMediaType = fmt.Errorf("the Content-Type must be %s or %s", ContentTypeCSV, ContentTypeNDJSON)
+	// errNotReady is returned by the readiness check when the store cannot
+	// be reached.
+	errNotReady = errors.New("the store cannot be reached")
+	// errOtherOrg is returned when a path names an org other than the one
+	// the request operates in.
+	errOtherOrg = fmt.Errorf("%w: the path names an org other than the one selected with the %s header", models.ErrForbidden, OrgHeader)
+)
+
+// roleRequired explains which role a policy needed that the caller lacked
+func roleRequired(policy rbac.Policy) error {
+	if policy.Role == rbac.RoleSystemAdmin {
+		return fmt.Errorf("%w: %s %s is for admins only", models.ErrForbidden, policy.Method, policy.Pattern)
+	}
+	return fmt.Errorf("%w: %s %s requires the %s role", models.ErrForbidden, policy.Method, policy.Pattern, policy.Role)
+}
+
+// decodeJSON decodes the request body into v.
+func decodeJSON(r *http.Request, v any) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return errEmptyBody
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedJSON, err)
+	}
+	return nil
+}
+
+// problemFor maps an error from decoding, validation or the store onto a
+// problem. Unrecognised errors become a 500 without leaking their message.
+func problemFor(err error) Problem {
+	var validationErr *ValidationError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &validationErr):
+		p := NewProblem(http.StatusBadRequest, "the request contains invalid fields")
+		p.InvalidParams = validationErr.Fields
+		return p
+	case errors.Is(err, errEmptyBody), errors.Is(err, errMalformedJSON):
+		return NewProblem(http.StatusBadRequest, err.Error())
+	case errors.As(err, &tooLarge):
+		return NewProblem(http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body must be at most %d bytes", tooLarge.Limit))
+	case errors.Is(err, errNotAcceptable):
+		return NewProblem(http.StatusNotAcceptable, err.Error())
+	case errors.Is(err, errUnsupportedMediaType):
+		return NewProblem(http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, errNotReady):
+		return NewProblem(http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, errUnauthenticated), errors.Is(err, errInvalidCredentials):
+		return NewProblem(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, models.ErrForbidden):
+		return NewProblem(http.StatusForbidden, err.Error())
+	case errors.Is(err, models.ErrNotFound):
+		return NewProblem(http.StatusNotFound, err.Error())
+	case errors.Is(err, idempotency.ErrMismatch):
+		return NewProblem(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, idempotency.ErrInProgress):
+		return NewProblem(http.StatusConflict, err.Error())
+	case errors.Is(err, models.ErrVersionMismatch):
+		return NewProblem(http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, models.ErrConflict):
+		return NewProblem(http.StatusConflict, err.Error())
+	default:
+		return NewProblem(http.StatusInternalServerError, "an unexpected error occurred")
+	}
+}
+
+// respondWithError writes the problem that corresponds to err
+func respondWithError(w http.ResponseWriter, err error) {
+	respondWithProblem(w, problemFor(err))
+}
+
+// respondWithProblem writes p as an application/problem+json response
+func respondWithProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", ContentTypeProblemJSON)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
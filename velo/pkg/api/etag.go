@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// itemETag is the strong entity tag of an item's current version
+func itemETag(item models.Item) string {
+	return fmt.Sprintf(`"%d"`, item.Version)
+}
+
+// respondWithItem sends an item along with its ETag
+func respondWithItem(w http.ResponseWriter, status int, item models.Item) {
+	w.Header().Set("ETag", itemETag(item))
+	respondWithJSON(w, status, item)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// etag. If-Match uses the strong comparison, which never matches a weak
+// "W/" tag; If-None-Match uses the weak comparison, which ignores the prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if tag, isWeak := strings.CutPrefix(candidate, "W/"); isWeak {
+			if !weak {
+				continue
+			}
+			candidate = tag
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestItemETags(t *testing.T) {
+	newHandler := func() http.Handler {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+		return api.NewHandler(store)
+	}
+
+	t.Run("GET, POST and PATCH return an ETag", func(t *testing.T) {
+		handler := newHandler()
+
+		responses := map[string]*httptest.ResponseRecorder{
+			"GET":   testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil),
+			"POST":  testutils.MakeRequest(t, handler, http.MethodPost, "/items", []byte(`{"name": "new"}`)),
+			"PATCH": testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(`{"name": "renamed"}`)),
+		}
+		for method, response := range responses {
+			if response.Header().Get("ETag") == "" {
+				t.Errorf("expected %s to return an ETag", method)
+			}
+		}
+		if responses["GET"].Header().Get("ETag") == responses["PATCH"].Header().Get("ETag") {
+			t.Error("expected the ETag to change when the item changes")
+		}
+	})
+
+	t.Run("If-None-Match with the current ETag returns 304", func(t *testing.T) {
+		handler := newHandler()
+		etag := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil).Header().Get("ETag")
+
+		response := testutils.MakeRequestWithHeader(t, handler, http.MethodGet, "/items/item-001", "If-None-Match", etag, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotModified)
+		if response.Body.Len() != 0 {
+			t.Errorf("expected an empty body, got %q", response.Body.String())
+		}
+
+		response = testutils.MakeRequestWithHeader(t, handler, http.MethodGet, "/items/item-001", "If-None-Match", `W/`+etag, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotModified)
+
+		response = testutils.MakeRequestWithHeader(t, handler, http.MethodGet, "/items/item-001", "If-None-Match", `"stale"`, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("If-Match with the current ETag applies the patch", func(t *testing.T) {
+		handler := newHandler()
+		etag := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil).Header().Get("ETag")
+
+		response := testutils.MakeRequestWithHeader(t, handler, http.MethodPatch, "/items/item-001", "If-Match", etag, []byte(`{"name": "mine"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("If-Match with a stale ETag returns 412", func(t *testing.T) {
+		handler := newHandler()
+		etag := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil).Header().Get("ETag")
+
+		// Another editor gets there first
+		testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(`{"name": "theirs"}`))
+
+		response := testutils.MakeRequestWithHeader(t, handler, http.MethodPatch, "/items/item-001", "If-Match", etag, []byte(`{"name": "mine"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusPreconditionFailed)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+
+		// If-Match uses the strong comparison, so even a current weak tag fails
+		current := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil).Header().Get("ETag")
+		response = testutils.MakeRequestWithHeader(t, handler, http.MethodPatch, "/items/item-001", "If-Match", "W/"+current, []byte(`{"name": "mine"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusPreconditionFailed)
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/feed"
+)
+
+// DefaultReplayBuffer is how many item changes are kept for clients
+// resuming GET /items/events unless configured
+const DefaultReplayBuffer = 1000
+
+// ContentTypeEventStream is the media type of a Server-Sent Events stream
+const ContentTypeEventStream = "text/event-stream"
+
+// eventStreamKeepAlive is how often an idle stream sends a comment, so that
+// proxies do not time it out
+const eventStreamKeepAlive = 15 * time.Second
+
+// errStreamingUnsupported is returned when the response cannot be flushed
+// as events are written
+var errStreamingUnsupported = errors.New("the connection does not support streaming")
+
+var itemEventsParams = []Param{
+	{In: "header", Name: "Last-Event-ID", Type: "integer", Description: "Resume after this event, replaying the changes since"},
+}
+
+// streamItemEvents sends the item changes of the caller's org, or of every
+// org for a system admin who has not selected one, as Server-Sent Events
+// until the client disconnects. Each event's id can be sent back as
+// Last-Event-ID to resume. If some changes since then are no
+// longer buffered, the stream starts with a reset event and the client
+// should reload the items.
+func (h *Handler) streamItemEvents(w http.ResponseWriter, r *http.Request) {
+	var lastID uint64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			respondWithError(w, &ValidationError{Fields: []RejectedField{{Field: "Last-Event-ID", Reason: "must be an event id"}}})
+			return
+		}
+	}
+
+	orgID, err := orgScope(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, errStreamingUnsupported)
+		return
+	}
+
+	// A stream outlives any server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	sub, replay, missed := h.feed.Subscribe(orgID, lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if missed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, change := range replay {
+		writeChange(w, change)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streamsDone:
+			return
+		case change, ok := <-sub.C:
+			if !ok {
+				// The client fell behind; it can reconnect and resume
+				return
+			}
+			writeChange(w, change)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// writeChange writes one change as an event named after its type
+func writeChange(w http.ResponseWriter, change feed.Change) {
+	data, _ := json.Marshal(change)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Type, data)
+}
@@ -0,0 +1,194 @@
+package api_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/feed"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+// sseEvent is one event read from a stream
+type sseEvent struct {
+	id, name string
+	change   feed.Change
+}
+
+// openEventStream connects to GET /items/events, returning a channel of the
+// events read until the test ends. The stream is closed before servers
+// registered with t.Cleanup earlier.
+func openEventStream(t *testing.T, url, token, lastEventID string) <-chan sseEvent {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/items/events", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not open event stream: %v", err)
+	}
+	testutils.AssertStatus(t, res.StatusCode, http.StatusOK)
+	if got := res.Header.Get("Content-Type"); got != api.ContentTypeEventStream {
+		t.Fatalf("got content type %q, want %q", got, api.ContentTypeEventStream)
+	}
+
+	events := make(chan sseEvent)
+	go func() {
+		defer res.Body.Close()
+		defer close(events)
+
+		var event sseEvent
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				json.Unmarshal([]byte(value), &event.change)
+			case "":
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("event stream ended")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func TestItemEvents(t *testing.T) {
+	t.Run("streams the org's item changes", func(t *testing.T) {
+		handler, store := newTenantServer(t)
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		acme := login(t, handler, "acme-user", "hunter2hunter2")
+		globex := login(t, handler, "globex-user", "hunter2hunter2")
+
+		events := openEventStream(t, server.URL, acme.Token, "")
+
+		makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[1].ID, globex.Token, []byte(`{"name": "theirs"}`))
+		makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[0].ID, acme.Token, []byte(`{"name": "ours"}`))
+		makeRequestWithToken(t, handler, http.MethodDelete, "/items/"+store.Items[0].ID, acme.Token, nil)
+
+		event := nextEvent(t, events)
+		if event.name != feed.ItemUpdated || event.change.Item.Name != "ours" {
+			t.Errorf("got event %+v, want acme's update", event)
+		}
+		if event := nextEvent(t, events); event.name != feed.ItemDeleted || event.change.Item.ID != store.Items[0].ID {
+			t.Errorf("got event %+v, want acme's delete", event)
+		}
+	})
+
+	t.Run("resumes after Last-Event-ID", func(t *testing.T) {
+		handler, store := newTenantServer(t)
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		acme := login(t, handler, "acme-user", "hunter2hunter2")
+
+		for _, name := range []string{"one", "two", "three"} {
+			makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[0].ID, acme.Token, []byte(`{"name": "`+name+`"}`))
+		}
+
+		first := openEventStream(t, server.URL, acme.Token, "")
+		makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[0].ID, acme.Token, []byte(`{"name": "four"}`))
+		if event := nextEvent(t, first); event.id != "4" {
+			t.Fatalf("got event id %q on a fresh stream, want only the new change 4", event.id)
+		}
+
+		events := openEventStream(t, server.URL, acme.Token, "1")
+		for _, want := range []string{"two", "three", "four"} {
+			if event := nextEvent(t, events); event.change.Item.Name != want {
+				t.Errorf("got replayed change %+v, want %s", event.change, want)
+			}
+		}
+	})
+
+	t.Run("starts with a reset when changes are no longer buffered", func(t *testing.T) {
+		broker := feed.NewBroker(1)
+		handler, store := newTenantServer(t, api.WithChangeFeed(broker))
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		acme := login(t, handler, "acme-user", "hunter2hunter2")
+
+		for _, name := range []string{"one", "two", "three"} {
+			makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[0].ID, acme.Token, []byte(`{"name": "`+name+`"}`))
+		}
+
+		events := openEventStream(t, server.URL, acme.Token, "1")
+		if event := nextEvent(t, events); event.name != "reset" {
+			t.Errorf("got event %+v, want a reset", event)
+		}
+		if event := nextEvent(t, events); event.change.Item.Name != "three" {
+			t.Errorf("got event %+v, want the buffered change", event)
+		}
+	})
+
+	t.Run("streams every org's changes to a system admin", func(t *testing.T) {
+		handler, store := newTenantServer(t)
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		admin := login(t, handler, "admin", "hunter2hunter2")
+		globex := login(t, handler, "globex-user", "hunter2hunter2")
+
+		events := openEventStream(t, server.URL, admin.Token, "")
+		makeRequestWithToken(t, handler, http.MethodPatch, "/items/"+store.Items[1].ID, globex.Token, []byte(`{"name": "theirs"}`))
+
+		if event := nextEvent(t, events); event.change.Item.Name != "theirs" {
+			t.Errorf("got event %+v, want globex's update", event)
+		}
+	})
+
+	t.Run("refuses callers without an org", func(t *testing.T) {
+		// Without RequireSession in front there is no user, and the handler
+		// must not fall back to every org's changes
+		handler := api.NewHandler(testutils.NewStubAppStoreWithData())
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items/events", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("rejects a malformed Last-Event-ID", func(t *testing.T) {
+		handler, _ := newTenantServer(t)
+		acme := login(t, handler, "acme-user", "hunter2hunter2")
+
+		req := httptest.NewRequest(http.MethodGet, "/items/events", nil)
+		req.Header.Set("Authorization", "Bearer "+acme.Token)
+		req.Header.Set("Last-Event-ID", "yesterday")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
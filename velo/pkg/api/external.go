@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/tenant"
+)
+
+// putExternalItem creates or replaces the item a partner system knows by
+// an external ID. A soft-deleted item with that ID is restored. When the
+// store is a models.Transactor the lookup and the change happen in one
+// transaction; otherwise a concurrent create of the same ID gets a 409.
+func (h *Handler) putExternalItem(w http.ResponseWriter, r *http.Request) {
+	var req PutItemRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	orgID, externalID := r.PathValue("org"), r.PathValue("externalID")
+	var item models.Item
+	var previous *models.Item
+	put := func(store models.AppStore) error {
+		scoped, err := externalItemStore(r, store, orgID)
+		if err != nil {
+			return err
+		}
+		item, previous, err = putByExternalID(r, scoped, externalID, req)
+		return err
+	}
+
+	var err error
+	if transactor, ok := h.store.(models.Transactor); ok {
+		err = transactor.InTx(put)
+	} else {
+		err = put(h.store)
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if previous != nil {
+		h.record(h.auditEvent(r, audit.ActionUpdate, ResourceItem, item.ID, item.OrgID, *previous, item))
+		respondWithItem(w, http.StatusOK, item)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceItem, item.ID, item.OrgID, nil, item))
+	w.Header().Set("Location", fmt.Sprintf("/items/%s", item.ID))
+	respondWithItem(w, http.StatusCreated, item)
+}
+
+// externalItemStore confines store to the org named in the path, which
+// must be the org the request operates in when a user is authenticated
+func externalItemStore(r *http.Request, store models.AppStore, orgID string) (models.AppStore, error) {
+	if selected, ok := OrgFromContext(r.Context()); ok {
+		if selected != orgID {
+			return nil, errOtherOrg
+		}
+	} else if _, ok := UserFromContext(r.Context()); ok {
+		return nil, errNoOrg
+	}
+	return tenant.Scope(store, orgID), nil
+}
+
+// putByExternalID creates or replaces the item with externalID in a scoped
+// store. It returns the item as it was before, or nil if it was created.
+func putByExternalID(r *http.Request, store models.AppStore, externalID string, req PutItemRequest) (models.Item, *models.Item, error) {
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	page, err := store.QueryItems(models.ItemQuery{ExternalID: externalID, IncludeDeleted: true, Limit: 1})
+	if err != nil {
+		return models.Item{}, nil, err
+	}
+
+	if len(page.Items) == 0 {
+		input := models.CreateItemInput{Name: req.Name, ExternalID: externalID}
+		if user, ok := UserFromContext(r.Context()); ok {
+			input.CreatedBy = user.ID
+		}
+		item, err := store.CreateItem(input)
+		if err != nil || isActive {
+			return item, nil, err
+		}
+		item, err = store.UpdateItem(item.ID, models.ItemUpdate{IsActive: &isActive})
+		return item, nil, err
+	}
+
+	current := page.Items[0]
+	if current.IsDeleted() {
+		if _, err := store.RestoreItem(current.ID); err != nil {
+			return models.Item{}, nil, err
+		}
+	}
+	item, err := store.UpdateItem(current.ID, models.ItemUpdate{Name: &req.Name, IsActive: &isActive})
+	if err != nil {
+		return models.Item{}, nil, err
+	}
+	return item, &current, nil
+}
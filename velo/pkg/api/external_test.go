@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestPutExternalItem(t *testing.T) {
+	const url = "/orgs/org-1/items/external/ext-42"
+
+	decodeItem := func(t testing.TB, response *http.Response) models.Item {
+		t.Helper()
+		var item models.Item
+		if err := json.NewDecoder(response.Body).Decode(&item); err != nil {
+			t.Fatalf("could not decode item: %v", err)
+		}
+		return item
+	}
+
+	t.Run("creates then replaces the item", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodPut, url, []byte(`{"name": "Blue mug"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+		created := decodeItem(t, response.Result())
+		if created.ExternalID != "ext-42" || created.OrgID != "org-1" || !created.IsActive {
+			t.Errorf("got %+v, want an active item in org-1 with external ID ext-42", created)
+		}
+		if got, want := response.Header().Get("Location"), "/items/"+created.ID; got != want {
+			t.Errorf("got Location %q, want %q", got, want)
+		}
+
+		response = testutils.MakeRequest(t, handler, http.MethodPut, url, []byte(`{"name": "Red mug", "is_active": false}`))
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		replaced := decodeItem(t, response.Result())
+		if replaced.ID != created.ID || replaced.Name != "Red mug" || replaced.IsActive {
+			t.Errorf("got %+v, want item %s renamed and inactive", replaced, created.ID)
+		}
+
+		response = testutils.MakeRequest(t, handler, http.MethodGet, "/items?external_id=ext-42", nil)
+		var items []models.Item
+		json.NewDecoder(response.Body).Decode(&items)
+		testutils.AssertContainsIDs(t, items, created.ID)
+	})
+
+	t.Run("restores a soft-deleted item", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		existing, _ := store.CreateItem(models.CreateItemInput{Name: "Blue mug", ExternalID: "ext-42", OrgID: "org-1"})
+		store.SoftDeleteItem(existing.ID, time.Now())
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodPut, url, []byte(`{"name": "Blue mug"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		if item := decodeItem(t, response.Result()); item.ID != existing.ID || item.IsDeleted() {
+			t.Errorf("got %+v, want item %s restored", item, existing.ID)
+		}
+	})
+
+	t.Run("rejects an item without a name", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		response := testutils.MakeRequest(t, handler, http.MethodPut, url, []byte(`{}`))
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("only writes to the caller's org", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+		orgs, _ := store.GetOrgs()
+		acme, globex := orgs[0], orgs[1]
+
+		response := makeRequestWithToken(t, server, http.MethodPut, "/orgs/"+globex.ID+"/items/external/ext-42", session.Token, []byte(`{"name": "Blue mug"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+
+		response = makeRequestWithToken(t, server, http.MethodPut, "/orgs/"+acme.ID+"/items/external/ext-42", session.Token, []byte(`{"name": "Blue mug"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+		if item := decodeItem(t, response.Result()); item.OrgID != acme.ID {
+			t.Errorf("got org %q, want %q", item.OrgID, acme.ID)
+		}
+	})
+}
+
+func TestUpdateItemExternalIDConflict(t *testing.T) {
+	store := testutils.NewStubAppStore()
+	store.CreateItem(models.CreateItemInput{Name: "first", ExternalID: "ext-1"})
+	second, _ := store.CreateItem(models.CreateItemInput{Name: "second"})
+	handler := api.NewHandler(store)
+
+	response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/"+second.ID, []byte(`{"external_id": "ext-1"}`))
+	testutils.AssertStatus(t, response.Code, http.StatusConflict)
+}
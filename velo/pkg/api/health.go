@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+)
+
+// readyTimeout bounds how long the readiness check waits for the store
+const readyTimeout = 2 * time.Second
+
+// healthz answers as long as the server is running
+func (h *Handler) healthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// readyz answers once the store can be reached. Stores that are not
+// models.Pinger are always ready.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	if h.pinger != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := h.pinger.Ping(ctx); err != nil {
+			log.Printf("readiness check failed: %v", err)
+			respondWithError(w, errNotReady)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, HealthStatus{Status: "ready"})
+}
+
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := h.metrics.WriteText(w); err != nil {
+		log.Printf("could not write metrics: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+// pingStore is a store whose readiness is decided by err
+type pingStore struct {
+	models.AppStore
+	err error
+}
+
+func (s pingStore) Ping(context.Context) error {
+	return s.err
+}
+
+func TestHealthChecks(t *testing.T) {
+	status := func(t testing.TB, response *http.Response) string {
+		t.Helper()
+		var health api.HealthStatus
+		if err := json.NewDecoder(response.Body).Decode(&health); err != nil {
+			t.Fatalf("could not decode health status: %v", err)
+		}
+		return health.Status
+	}
+
+	t.Run("healthz answers without a session", func(t *testing.T) {
+		server := api.RequireSession(testutils.NewStubAppStore(), api.NewHandler(testutils.NewStubAppStore()))
+
+		response := testutils.MakeRequest(t, server, http.MethodGet, "/healthz", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		if got := status(t, response.Result()); got != "ok" {
+			t.Errorf("got status %q, want ok", got)
+		}
+	})
+
+	t.Run("readyz answers when the store can be pinged", func(t *testing.T) {
+		handler := api.NewHandler(pingStore{AppStore: testutils.NewStubAppStore()})
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/readyz", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		if got := status(t, response.Result()); got != "ready" {
+			t.Errorf("got status %q, want ready", got)
+		}
+	})
+
+	t.Run("readyz answers for stores that cannot be pinged", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/readyz", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("readyz fails when the store cannot be reached", func(t *testing.T) {
+		handler := api.NewHandler(pingStore{AppStore: testutils.NewStubAppStore(), err: errors.New("connection refused")})
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/readyz", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusServiceUnavailable)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+		if strings.Contains(response.Body.String(), "connection refused") {
+			t.Error("problem leaks the store's error")
+		}
+	})
+}
+
+func TestServeMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounterVec("jobs_total", "Jobs run.").Inc()
+	handler := api.NewHandler(testutils.NewStubAppStore(), api.WithMetrics(registry))
+
+	response := testutils.MakeRequest(t, handler, http.MethodGet, "/metrics", nil)
+	testutils.AssertStatus(t, response.Code, http.StatusOK)
+	testutils.AssertContentType(t, response, metrics.ContentType)
+	if want := "jobs_total 1\n"; !strings.Contains(response.Body.String(), want) {
+		t.Errorf("got\n%s\nwant it to contain %q", response.Body.String(), want)
+	}
+}
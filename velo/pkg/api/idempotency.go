@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/idempotency"
+)
+
+// IdempotencyKeyHeader lets clients retry create requests safely: repeats
+// with the same key get the first response replayed.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentRequestSize and maxIdempotentResponseSize bound the bodies
+// kept in the cache for each key. They leave room for a full batch.
+const (
+	maxIdempotentRequestSize  = 4 << 20
+	maxIdempotentResponseSize = 4 << 20
+)
+
+// idempotent records the response to requests carrying an Idempotency-Key
+// and replays it for repeats. Keys are scoped to the caller and route, and
+// reusing one with a different body gets a 422. Server errors, panics and
+// responses too large to keep are not recorded, so the request can be
+// retried.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, &ValidationError{Fields: []RejectedField{{Field: IdempotencyKeyHeader, Reason: "must be at most 255 characters"}}})
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestSize)); err != nil {
+				respondWithError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		scope := idempotencyScope(r, key)
+		fingerprint := sha256.Sum256(body)
+		recorded, err := h.idempotency.Begin(scope, hex.EncodeToString(fingerprint[:]))
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if recorded != nil {
+			replay(w, recorded)
+			return
+		}
+
+		// Release the key unless a response is recorded for it, even if
+		// next panics
+		completed := false
+		defer func() {
+			if !completed {
+				h.idempotency.Abandon(scope)
+			}
+		}()
+
+		capture := &responseCapture{ResponseWriter: w, status: http.StatusOK, limit: maxIdempotentResponseSize}
+		next(capture, r)
+
+		if capture.status >= http.StatusInternalServerError || capture.overflowed {
+			return
+		}
+		h.idempotency.Complete(scope, idempotency.Response{
+			Status: capture.status,
+			Header: capture.header,
+			Body:   capture.body.Bytes(),
+		})
+		completed = true
+	}
+}
+
+// idempotencyScope keeps one caller's keys from colliding with another's.
+// Callers without a user, such as those signing up, are told apart by their
+// address.
+func idempotencyScope(r *http.Request, key string) string {
+	caller := "client " + r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		caller = "client " + host
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		caller = user.ID
+	}
+	orgID, _ := OrgFromContext(r.Context())
+	return strings.Join([]string{caller, orgID, r.Method, r.URL.Path, key}, "\x00")
+}
+
+// replay writes a recorded response, marking it as a replay
+func replay(w http.ResponseWriter, recorded *idempotency.Response) {
+	for name, values := range recorded.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(recorded.Status)
+	w.Write(recorded.Body)
+}
+
+// responseCapture passes a response through while keeping a copy of it,
+// up to limit bytes
+type responseCapture struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+	limit       int
+	// overflowed is set once the body outgrows limit, and the copy dropped
+	overflowed bool
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.overflowed {
+		if c.body.Len()+len(b) > c.limit {
+			c.overflowed = true
+			c.body = bytes.Buffer{}
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
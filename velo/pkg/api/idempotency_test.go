@@ -0,0 +1,160 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func makeIdempotentRequest(t testing.TB, handler http.Handler, url, key string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", api.ContentTypeJSON)
+	req.Header.Set(api.IdempotencyKeyHeader, key)
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	t.Run("replays the first response to POST /items", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store)
+		body := []byte(`{"name": "once"}`)
+
+		first := makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, first.Code, http.StatusCreated)
+
+		second := makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, second.Code, http.StatusCreated)
+
+		if len(store.Items) != 1 {
+			t.Errorf("expected one item to be created, got %d", len(store.Items))
+		}
+		if second.Body.String() != first.Body.String() {
+			t.Errorf("got replayed body %q, want %q", second.Body.String(), first.Body.String())
+		}
+		if second.Header().Get("Location") != first.Header().Get("Location") {
+			t.Errorf("got replayed Location %q, want %q", second.Header().Get("Location"), first.Header().Get("Location"))
+		}
+		if second.Header().Get("Idempotent-Replayed") != "true" {
+			t.Error("expected the replay to be marked")
+		}
+	})
+
+	t.Run("replays the first response to POST /users", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store)
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Per"})
+
+		makeIdempotentRequest(t, handler, "/users", "key-1", body)
+		second := makeIdempotentRequest(t, handler, "/users", "key-1", body)
+		testutils.AssertStatus(t, second.Code, http.StatusCreated)
+
+		var user models.User
+		json.NewDecoder(second.Body).Decode(&user)
+		if len(store.Users) != 1 || user.ID != store.Users[0].ID {
+			t.Errorf("expected the replay to return the one created user, got %+v", user)
+		}
+	})
+
+	t.Run("keeps anonymous callers' keys apart", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store)
+
+		for i, addr := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+			body, _ := json.Marshal(api.CreateUserRequest{Name: fmt.Sprint("user-", i)})
+			req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+			req.RemoteAddr = addr
+			req.Header.Set("Content-Type", api.ContentTypeJSON)
+			req.Header.Set(api.IdempotencyKeyHeader, "key-1")
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, req)
+			testutils.AssertStatus(t, response.Code, http.StatusCreated)
+		}
+
+		if len(store.Users) != 2 {
+			t.Errorf("got %d users, want one per caller", len(store.Users))
+		}
+	})
+
+	t.Run("rejects a reused key with a different body", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		makeIdempotentRequest(t, handler, "/items", "key-1", []byte(`{"name": "first"}`))
+		response := makeIdempotentRequest(t, handler, "/items", "key-1", []byte(`{"name": "second"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusUnprocessableEntity)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+	})
+
+	t.Run("different keys create different items", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store)
+		body := []byte(`{"name": "twice"}`)
+
+		makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		makeIdempotentRequest(t, handler, "/items", "key-2", body)
+		if len(store.Items) != 2 {
+			t.Errorf("expected two items, got %d", len(store.Items))
+		}
+	})
+
+	t.Run("does not record server errors", func(t *testing.T) {
+		store := &testutils.ErrorStore{AppStore: testutils.NewStubAppStore(), ShouldError: true}
+		handler := api.NewHandler(store)
+		body := []byte(`{"name": "retry me"}`)
+
+		response := makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, response.Code, http.StatusInternalServerError)
+
+		store.ShouldError = false
+		response = makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+	})
+
+	t.Run("releases the key when the handler panics", func(t *testing.T) {
+		store := &panicStore{AppStore: testutils.NewStubAppStore(), panics: true}
+		handler := api.NewHandler(store)
+		body := []byte(`{"name": "retry me"}`)
+
+		func() {
+			defer func() { recover() }()
+			makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		}()
+
+		store.panics = false
+		response := makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+	})
+
+	t.Run("rejects bodies too large to keep", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+		body := []byte(`{"name": "` + strings.Repeat("a", 5<<20) + `"}`)
+
+		response := makeIdempotentRequest(t, handler, "/items", "key-1", body)
+		testutils.AssertStatus(t, response.Code, http.StatusRequestEntityTooLarge)
+	})
+}
+
+// panicStore panics on CreateItem while panics is set
+type panicStore struct {
+	models.AppStore
+	panics bool
+}
+
+func (s *panicStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	if s.panics {
+		panic("store failed")
+	}
+	return s.AppStore.CreateItem(input)
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+)
+
+// maxImportLine is the longest NDJSON line an import accepts
+const maxImportLine = 1 << 20
+
+// maxImportSize is the largest upload an import accepts
+const maxImportSize = 32 << 20
+
+// importTimeout replaces the server's read and write timeouts for an
+// import, leaving time to upload and create maxImportSize of items
+const importTimeout = 15 * time.Minute
+
+// errMalformedRow is wrapped by errors for a single line of an import that
+// could not be parsed. The import carries on with the next line.
+var errMalformedRow = errors.New("line is malformed")
+
+// csvItemColumns are the columns of an exported CSV file, in order
+var csvItemColumns = []string{"id", "name", "external_id", "org_id", "is_active", "created_at", "updated_at", "created_by", "deleted_at", "version"}
+
+// exportParams are the filters of GET /items; an export is not paged
+var exportParams = append(
+	slices.DeleteFunc(slices.Clone(itemListParams), func(p Param) bool { return p.Name == "limit" || p.Name == "cursor" }),
+	Param{In: "header", Name: "Accept", Type: "string", Description: ContentTypeCSV + " (the default) or " + ContentTypeNDJSON},
+)
+
+var importParams = []Param{
+	{In: "header", Name: "Content-Type", Type: "string", Description: ContentTypeCSV + " with a header row naming the name and external_id columns, or " + ContentTypeNDJSON},
+}
+
+// exportItems streams every item matching the filters of GET /items, one
+// page at a time, as CSV or NDJSON depending on the Accept header
+func (h *Handler) exportItems(w http.ResponseWriter, r *http.Request) {
+	format, err := negotiateFormat(r.Header.Get("Accept"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	query, err := parseItemQuery(r.URL.Query())
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	query.Limit = models.MaxItemLimit
+
+	store, err := h.itemStore(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// Fetch the first page before answering, so that errors get a problem
+	page, err := store.QueryItems(query)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	extension := "csv"
+	if format == ContentTypeNDJSON {
+		extension = "ndjson"
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="items.%s"`, extension))
+	// A large export may take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	encoder := newItemEncoder(format, w)
+	flusher, _ := w.(http.Flusher)
+	for {
+		for _, item := range page.Items {
+			if err := encoder.Encode(item); err != nil {
+				return
+			}
+		}
+		if err := encoder.Flush(); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if !page.HasMore || len(page.Items) == 0 {
+			return
+		}
+		cursor := models.CursorFor(page.Items[len(page.Items)-1])
+		query.After = &cursor
+		if page, err = store.QueryItems(query); err != nil {
+			log.Printf("could not export items: %v", err)
+			return
+		}
+	}
+}
+
+// negotiateFormat picks the export format from an Accept header: the first
+// media range that CSV or NDJSON satisfies, or CSV when there is none
+func negotiateFormat(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return ContentTypeCSV, nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		switch mediaType {
+		case ContentTypeCSV, "text/*", "*/*":
+			return ContentTypeCSV, nil
+		case ContentTypeNDJSON, "application/*":
+			return ContentTypeNDJSON, nil
+		}
+	}
+	return "", errNotAcceptable
+}
+
+// itemEncoder writes items in an export format
+type itemEncoder interface {
+	Encode(item models.Item) error
+	// Flush writes anything buffered, returning any error so far
+	Flush() error
+}
+
+func newItemEncoder(format string, w io.Writer) itemEncoder {
+	if format == ContentTypeNDJSON {
+		return ndjsonItemEncoder{json.NewEncoder(w)}
+	}
+	encoder := csvItemEncoder{csv.NewWriter(w)}
+	encoder.w.Write(csvItemColumns)
+	return encoder
+}
+
+type csvItemEncoder struct {
+	w *csv.Writer
+}
+
+// formulaPrefixes start values that spreadsheets would run as formulas.
+// A leading quote is included so that escaping can be undone exactly.
+const formulaPrefixes = "=+-@\t\r'"
+
+// escapeFormula prefixes a value a spreadsheet would treat as a formula
+// with a quote, which makes it text
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeFormula undoes escapeFormula, so exports import unchanged
+func unescapeFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(formulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func (e csvItemEncoder) Encode(item models.Item) error {
+	deletedAt := ""
+	if item.DeletedAt != nil {
+		deletedAt = item.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{
+		item.ID,
+		escapeFormula(item.Name),
+		escapeFormula(item.ExternalID),
+		item.OrgID,
+		strconv.FormatBool(item.IsActive),
+		item.CreatedAt.Format(time.RFC3339Nano),
+		item.UpdatedAt.Format(time.RFC3339Nano),
+		item.CreatedBy,
+		deletedAt,
+		strconv.FormatInt(item.Version, 10),
+	})
+}
+
+func (e csvItemEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonItemEncoder writes each item as a line of JSON
+type ndjsonItemEncoder struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonItemEncoder) Encode(item models.Item) error { return e.enc.Encode(item) }
+func (e ndjsonItemEncoder) Flush() error                  { return nil }
+
+// importItems creates an item for each line of a CSV or NDJSON upload,
+// reading one line at a time. Every line is validated and created on its
+// own, as POST /items would, so a rejected line does not stop the rest.
+// The report lists the outcome of each line. Reading stops at
+// maxImportSize, and the lines before it are still created.
+func (h *Handler) importItems(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	}
+	rows, err := newItemRowReader(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	store, err := h.itemStore(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	// A large upload may take longer than the server's timeouts
+	controller := http.NewResponseController(w)
+	deadline := time.Now().Add(importTimeout)
+	controller.SetReadDeadline(deadline)
+	controller.SetWriteDeadline(deadline)
+
+	report := ImportReport{Accepted: []ImportedLine{}, Rejected: []RejectedLine{}}
+	for {
+		row, err := rows.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errMalformedRow) {
+			report.Rejected = append(report.Rejected, RejectedLine{Line: row.line, Error: NewProblem(http.StatusBadRequest, err.Error())})
+			continue
+		}
+		if err != nil {
+			// Nothing after this line can be read
+			detail := fmt.Sprintf("could not read the rest of the upload: %v", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				detail = fmt.Sprintf("the upload is larger than %d bytes; the rest was not read", tooLarge.Limit)
+			}
+			report.Rejected = append(report.Rejected, RejectedLine{Line: row.line, Error: NewProblem(http.StatusBadRequest, detail)})
+			break
+		}
+
+		if r.Context().Err() != nil {
+			// The client has gone, and with it anyone to read the report
+			return
+		}
+		item, err := h.createImportedItem(r, store, row)
+		if err != nil {
+			report.Rejected = append(report.Rejected, RejectedLine{Line: row.line, Error: problemFor(err)})
+			continue
+		}
+		report.Accepted = append(report.Accepted, ImportedLine{Line: row.line, ID: item.ID})
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// createImportedItem creates the item for one line of an import
+func (h *Handler) createImportedItem(r *http.Request, store models.AppStore, row importRow) (models.Item, error) {
+	req := CreateItemRequest{Name: row.Name}
+	if err := req.Validate(); err != nil {
+		return models.Item{}, err
+	}
+
+	input := models.CreateItemInput{Name: req.Name, ExternalID: row.ExternalID}
+	if user, ok := UserFromContext(r.Context()); ok {
+		input.CreatedBy = user.ID
+	}
+	item, err := store.CreateItem(input)
+	if err != nil {
+		return models.Item{}, err
+	}
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceItem, item.ID, item.OrgID, nil, item))
+	return item, nil
+}
+
+// importRow is one line of an import. Fields other than these, such as
+// those of an export, are ignored.
+type importRow struct {
+	line       int
+	Name       string `json:"name"`
+	ExternalID string `json:"external_id"`
+}
+
+// itemRowReader reads an import a line at a time. Read returns io.EOF at
+// the end, an error wrapping errMalformedRow for a line that cannot be
+// parsed, and any other error when the rest cannot be read. The row's line
+// is set in every case.
+type itemRowReader interface {
+	Read() (importRow, error)
+}
+
+// newItemRowReader returns a reader for the request body's format
+func newItemRowReader(r *http.Request) (itemRowReader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errUnsupportedMediaType
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, errEmptyBody
+	}
+
+	switch mediaType {
+	case ContentTypeCSV:
+		return newCSVRowReader(r.Body)
+	case ContentTypeNDJSON:
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+		return &ndjsonRowReader{scanner: scanner}, nil
+	default:
+		return nil, errUnsupportedMediaType
+	}
+}
+
+// csvRowReader reads rows by the columns named in the header row
+type csvRowReader struct {
+	r *csv.Reader
+	// name and externalID are column indexes; externalID is -1 if absent
+	name, externalID int
+	// line is where the last row read started
+	line int
+}
+
+func newCSVRowReader(body io.Reader) (*csvRowReader, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errEmptyBody
+	}
+	if err != nil {
+		return nil, &ValidationError{Fields: []RejectedField{{Field: "header", Reason: fmt.Sprintf("could not be read: %v", err)}}}
+	}
+
+	columns := make([]string, len(header))
+	for i, column := range header {
+		// Spreadsheets often start the file with a byte order mark
+		columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+	}
+	reader := &csvRowReader{
+		r:          r,
+		name:       slices.Index(columns, "name"),
+		externalID: slices.Index(columns, "external_id"),
+	}
+	if reader.name < 0 {
+		return nil, &ValidationError{Fields: []RejectedField{{Field: "header", Reason: "must have a name column"}}}
+	}
+	return reader, nil
+}
+
+func (c *csvRowReader) Read() (importRow, error) {
+	record, err := c.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importRow{line: parseErr.StartLine}, fmt.Errorf("%w: %v", errMalformedRow, parseErr.Err)
+	}
+	if err != nil {
+		return importRow{line: c.line + 1}, err
+	}
+
+	c.line, _ = c.r.FieldPos(0)
+	row := importRow{line: c.line}
+	if c.name < len(record) {
+		row.Name = unescapeFormula(record[c.name])
+	}
+	if c.externalID >= 0 && c.externalID < len(record) {
+		row.ExternalID = unescapeFormula(record[c.externalID])
+	}
+	return row, nil
+}
+
+// ndjsonRowReader reads a JSON object from each line, skipping blank lines
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonRowReader) Read() (importRow, error) {
+	for n.scanner.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		row := importRow{line: n.line}
+		if err := json.Unmarshal(text, &row); err != nil {
+			return row, fmt.Errorf("%w: %v", errMalformedRow, err)
+		}
+		return row, nil
+	}
+
+	err := n.scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		err = fmt.Errorf("line is longer than %d bytes", maxImportLine)
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return importRow{line: n.line + 1}, err
+}
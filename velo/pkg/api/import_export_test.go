@@ -0,0 +1,179 @@
+package api_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestExportItems(t *testing.T) {
+	newHandler := func() *api.Handler {
+		store := testutils.NewStubAppStore()
+		for i := range models.MaxItemLimit + 5 {
+			store.CreateItem(models.CreateItemInput{Name: strings.Repeat("x", i%3+1), OrgID: "org-1"})
+		}
+		store.CreateItem(models.CreateItemInput{Name: "other org", OrgID: "org-2"})
+		return api.NewHandler(store)
+	}
+
+	t.Run("streams every page as CSV by default", func(t *testing.T) {
+		response := testutils.MakeRequest(t, newHandler(), http.MethodGet, "/items/export?org_id=org-1", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		if got := response.Header().Get("Content-Type"); got != api.ContentTypeCSV {
+			t.Errorf("got Content-Type %q, want %q", got, api.ContentTypeCSV)
+		}
+
+		records, err := csv.NewReader(response.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("could not read CSV: %v", err)
+		}
+		if records[0][0] != "id" || records[0][1] != "name" {
+			t.Errorf("got header row %v", records[0])
+		}
+		if got, want := len(records)-1, models.MaxItemLimit+5; got != want {
+			t.Errorf("got %d rows, want %d", got, want)
+		}
+	})
+
+	t.Run("streams NDJSON when accepted", func(t *testing.T) {
+		response := testutils.MakeRequestWithHeader(t, newHandler(), http.MethodGet, "/items/export?org_id=org-2", "Accept", "application/x-ndjson, text/csv;q=0.5", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+		var item models.Item
+		if err := json.Unmarshal([]byte(lines[0]), &item); err != nil || len(lines) != 1 {
+			t.Fatalf("got %q, want one JSON line", lines)
+		}
+		if item.Name != "other org" {
+			t.Errorf("got item %+v, want the other org's", item)
+		}
+	})
+
+	t.Run("keeps spreadsheets from running values as formulas", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.CreateItem(models.CreateItemInput{Name: "=HYPERLINK(\"http://evil\")", ExternalID: "-1", OrgID: "org-1"})
+		store.CreateItem(models.CreateItemInput{Name: "Blue mug", ExternalID: "ext-1", OrgID: "org-1"})
+
+		response := testutils.MakeRequest(t, api.NewHandler(store), http.MethodGet, "/items/export", nil)
+		records, err := csv.NewReader(response.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("could not parse CSV: %v", err)
+		}
+		if got := records[1][1:3]; !slices.Equal(got, []string{"'=HYPERLINK(\"http://evil\")", "'-1"}) {
+			t.Errorf("got name and external_id %q, want them escaped", got)
+		}
+		if got := records[2][1:3]; !slices.Equal(got, []string{"Blue mug", "ext-1"}) {
+			t.Errorf("got name and external_id %q, want them unchanged", got)
+		}
+	})
+
+	t.Run("refuses formats it cannot produce", func(t *testing.T) {
+		response := testutils.MakeRequestWithHeader(t, newHandler(), http.MethodGet, "/items/export", "Accept", "application/pdf", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotAcceptable)
+	})
+}
+
+func TestImportItems(t *testing.T) {
+	importItems := func(t *testing.T, store models.AppStore, contentType, body string) api.ImportReport {
+		t.Helper()
+		response := testutils.MakeRequestWithHeader(t, api.NewHandler(store), http.MethodPost, "/items/import", "Content-Type", contentType, []byte(body))
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var report api.ImportReport
+		json.NewDecoder(response.Body).Decode(&report)
+		return report
+	}
+
+	lines := func(report api.ImportReport) (accepted, rejected []int) {
+		for _, line := range report.Accepted {
+			accepted = append(accepted, line.Line)
+		}
+		for _, line := range report.Rejected {
+			rejected = append(rejected, line.Line)
+		}
+		return accepted, rejected
+	}
+
+	t.Run("reports the outcome of each CSV line", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		body := "external_id,name\next-1,Blue mug\next-2,\next-3,Red \"mug\next-1,Duplicate\n,Plate\n"
+
+		report := importItems(t, store, "text/csv; charset=utf-8", body)
+
+		accepted, rejected := lines(report)
+		if want := []int{2, 6}; !slices.Equal(accepted, want) {
+			t.Errorf("got accepted lines %v, want %v", accepted, want)
+		}
+		if want := []int{3, 4, 5}; !slices.Equal(rejected, want) {
+			t.Errorf("got rejected lines %v, want %v", rejected, want)
+		}
+		if got := report.Rejected[0].Error.InvalidParams; len(got) != 1 || got[0].Field != "name" {
+			t.Errorf("got invalid params %v for the nameless line, want name", got)
+		}
+		if len(store.Items) != 2 || store.Items[0].ExternalID != "ext-1" {
+			t.Errorf("got items %+v, want the two accepted lines", store.Items)
+		}
+	})
+
+	t.Run("reports the outcome of each NDJSON line", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		body := `{"name": "Blue mug"}` + "\n\n" + `{"name": ` + "\n" + `{"name": ""}` + "\n" + `{"name": "Red mug", "id": "ignored"}`
+
+		report := importItems(t, store, api.ContentTypeNDJSON, body)
+
+		accepted, rejected := lines(report)
+		if want := []int{1, 5}; !slices.Equal(accepted, want) {
+			t.Errorf("got accepted lines %v, want %v", accepted, want)
+		}
+		if want := []int{3, 4}; !slices.Equal(rejected, want) {
+			t.Errorf("got rejected lines %v, want %v", rejected, want)
+		}
+	})
+
+	t.Run("imports its own export", func(t *testing.T) {
+		source := testutils.NewStubAppStore()
+		source.CreateItem(models.CreateItemInput{Name: "Blue mug", ExternalID: "ext-1"})
+		source.CreateItem(models.CreateItemInput{Name: "=1+1", ExternalID: "'quoted"})
+		export := testutils.MakeRequest(t, api.NewHandler(source), http.MethodGet, "/items/export", nil)
+
+		target := testutils.NewStubAppStore()
+		report := importItems(t, target, api.ContentTypeCSV, export.Body.String())
+		if len(report.Accepted) != 2 || len(report.Rejected) != 0 {
+			t.Errorf("got report %+v, want both items accepted", report)
+		}
+		if got := target.Items[1]; got.Name != "=1+1" || got.ExternalID != "'quoted" {
+			t.Errorf("got item %+v, want its name and external ID unchanged", got)
+		}
+	})
+
+	t.Run("stops reading uploads that are too large", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		body := "name\nBlue mug\n" + strings.Repeat("x", 32<<20)
+
+		report := importItems(t, store, api.ContentTypeCSV, body)
+		last := report.Rejected[len(report.Rejected)-1]
+		if !strings.Contains(last.Error.Detail, "larger than") {
+			t.Errorf("got last rejection %+v, want one for the size of the upload", last)
+		}
+		if len(report.Accepted) != 1 || len(store.Items) != 1 {
+			t.Errorf("got report %+v, want the line before the limit created", report.Accepted)
+		}
+	})
+
+	t.Run("rejects uploads it cannot read", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		response := testutils.MakeRequestWithHeader(t, handler, http.MethodPost, "/items/import", "Content-Type", api.ContentTypeJSON, []byte(`[]`))
+		testutils.AssertStatus(t, response.Code, http.StatusUnsupportedMediaType)
+
+		response = testutils.MakeRequestWithHeader(t, handler, http.MethodPost, "/items/import", "Content-Type", api.ContentTypeCSV, []byte("external_id\next-1\n"))
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
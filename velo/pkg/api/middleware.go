@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+	orgContextKey
+	roleContextKey
+)
+
+// WithUser returns a context carrying the authenticated user
+func WithUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user, if there is one
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(models.User)
+	return user, ok
+}
+
+// SessionFromContext returns the session the request authenticated with
+func SessionFromContext(ctx context.Context) (models.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(models.Session)
+	return session, ok
+}
+
+// WithOrg returns a context whose requests operate inside orgID
+func WithOrg(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgContextKey, orgID)
+}
+
+// OrgFromContext returns the org the request operates in, if one was selected
+func OrgFromContext(ctx context.Context) (string, bool) {
+	orgID, ok := ctx.Value(orgContextKey).(string)
+	return orgID, ok
+}
+
+// RoleFromContext returns the user's role in the selected org, if one was
+// selected
+func RoleFromContext(ctx context.Context) (models.Role, bool) {
+	role, ok := ctx.Value(roleContextKey).(models.Role)
+	return role, ok
+}
+
+// RequireSession resolves the bearer token on every request to its session
+// and user, storing both in the request context along with the org selected
+// by the OrgHeader. Requests without a valid, unexpired session get a 401,
+// except for the public routes; selecting an org the user is not a member of
+// gets a 403.
+func RequireSession(store models.AppStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicRoutes[r.Method+" "+r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, user, err := authenticate(store, r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="velo"`)
+			respondWithError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(WithUser(r.Context(), user), sessionContextKey, session)
+
+		membership, err := selectOrg(store, user, r.Header.Get(OrgHeader))
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		if membership.OrgID != "" {
+			ctx = context.WithValue(WithOrg(ctx, membership.OrgID), roleContextKey, membership.Role)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate looks up the session and user for the request's bearer token
+func authenticate(store models.AppStore, r *http.Request) (models.Session, models.User, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return models.Session{}, models.User{}, errUnauthenticated
+	}
+
+	session, err := store.GetSessionByToken(auth.HashToken(token))
+	if errors.Is(err, models.ErrNotFound) {
+		return models.Session{}, models.User{}, errUnauthenticated
+	}
+	if err != nil {
+		return models.Session{}, models.User{}, err
+	}
+
+	if session.Expired(time.Now()) {
+		store.DeleteSession(session.ID)
+		return models.Session{}, models.User{}, errUnauthenticated
+	}
+
+	user, err := store.GetUser(session.UserID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.Session{}, models.User{}, errUnauthenticated
+	}
+	if err != nil {
+		return models.Session{}, models.User{}, err
+	}
+	return session, user, nil
+}
+
+// selectOrg decides which org a user's request operates in, returning the
+// user's membership of it. An explicit choice must be one of the user's
+// orgs, or any existing org for an admin, who acts as an org admin there.
+// Without one, a user with a single membership works in that org, and
+// otherwise no org is selected.
+func selectOrg(store models.AppStore, user models.User, requested string) (models.Membership, error) {
+	memberships, err := store.GetMemberships(user.ID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	if requested == "" {
+		if len(memberships) == 1 {
+			return memberships[0], nil
+		}
+		return models.Membership{}, nil
+	}
+
+	for _, m := range memberships {
+		if m.OrgID == requested {
+			return m, nil
+		}
+	}
+
+	if user.IsAdmin {
+		if _, err := store.GetOrg(requested); err != nil {
+			return models.Membership{}, err
+		}
+		return models.Membership{OrgID: requested, UserID: user.ID, Role: models.RoleAdmin}, nil
+	}
+	return models.Membership{}, errNotMember
+}
+
+// Authorize checks every authenticated request against policies, answering
+// 403 when the user's role in the selected org is not enough. It must run
+// inside RequireSession; requests without a user are public routes and pass
+// straight through.
+func Authorize(policies rbac.Table, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy, ok := policies.Lookup(r.Method, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		role, _ := RoleFromContext(r.Context())
+		if policy.Allows(rbac.Principal{Role: role, IsAdmin: user.IsAdmin}) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, selected := OrgFromContext(r.Context()); !selected && policy.Role != rbac.RoleSystemAdmin {
+			respondWithError(w, errNoOrg)
+			return
+		}
+		respondWithError(w, roleRequired(policy))
+	})
+}
@@ -0,0 +1,202 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func makeRequestWithToken(t testing.TB, server http.Handler, method, url, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, url, nil)
+	if body != nil {
+		req = httptest.NewRequest(method, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", api.ContentTypeJSON)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res
+}
+
+func login(t testing.TB, server http.Handler, name, password string) api.LoginResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(api.LoginRequest{Name: name, Password: password})
+	response := testutils.MakeRequest(t, server, http.MethodPost, "/sessions", body)
+	testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+	var login api.LoginResponse
+	json.NewDecoder(response.Body).Decode(&login)
+	if login.Token == "" {
+		t.Fatal("expected login to return a token")
+	}
+	return login
+}
+
+func TestSessionAuthentication(t *testing.T) {
+	auth.PasswordIterations = 1000
+
+	newServer := func(t *testing.T) (http.Handler, *testutils.StubAppStore) {
+		store := testutils.NewStubAppStore()
+		server := api.RequireSession(store, api.NewHandler(store))
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Per", Password: "hunter2hunter2"})
+		response := testutils.MakeRequest(t, server, http.MethodPost, "/users", body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+		var user models.User
+		json.NewDecoder(response.Body).Decode(&user)
+		org, _ := store.CreateOrg(models.CreateOrgInput{Name: "Acme"})
+		store.AddMember(org.ID, user.ID, models.RoleEditor)
+		return server, store
+	}
+
+	t.Run("rejects requests without a token", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		response := testutils.MakeRequest(t, server, http.MethodGet, "/items", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+		if response.Header().Get("WWW-Authenticate") == "" {
+			t.Error("expected a WWW-Authenticate header")
+		}
+	})
+
+	t.Run("rejects an unknown token", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items", "made-up", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("rejects a wrong password", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		body, _ := json.Marshal(api.LoginRequest{Name: "Per", Password: "not-the-password"})
+		response := testutils.MakeRequest(t, server, http.MethodPost, "/sessions", body)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("rejects an unknown user", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		body, _ := json.Marshal(api.LoginRequest{Name: "Pål", Password: "hunter2hunter2"})
+		response := testutils.MakeRequest(t, server, http.MethodPost, "/sessions", body)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("stamps CreatedBy with the logged in user", func(t *testing.T) {
+		server, _ := newServer(t)
+		session := login(t, server, "Per", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "mine"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+		var item models.Item
+		json.NewDecoder(response.Body).Decode(&item)
+		if item.CreatedBy != session.Session.UserID {
+			t.Errorf("got CreatedBy %q, want %q", item.CreatedBy, session.Session.UserID)
+		}
+	})
+
+	t.Run("logout revokes the token", func(t *testing.T) {
+		server, _ := newServer(t)
+		session := login(t, server, "Per", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodDelete, "/sessions/"+session.Session.ID, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNoContent)
+
+		response = makeRequestWithToken(t, server, http.MethodGet, "/items", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("hides other users' sessions", func(t *testing.T) {
+		server, _ := newServer(t)
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Pål", Password: "hunter2hunter2"})
+		testutils.MakeRequest(t, server, http.MethodPost, "/users", body)
+
+		mine := login(t, server, "Per", "hunter2hunter2")
+		theirs := login(t, server, "Pål", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodDelete, "/sessions/"+theirs.Session.ID, mine.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("rejects an expired session", func(t *testing.T) {
+		server, store := newServer(t)
+		user, _ := store.GetUserByName("Per")
+
+		token, _ := auth.NewToken()
+		store.CreateSession(models.CreateSessionInput{
+			UserID:    user.ID,
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items", token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("sessions expire after the configured TTL", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		handler := api.NewHandler(store, api.WithSessionTTL(time.Minute))
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Per", Password: "hunter2hunter2"})
+		testutils.MakeRequest(t, handler, http.MethodPost, "/users", body)
+
+		session := login(t, handler, "Per", "hunter2hunter2")
+		ttl := session.Session.ExpiresAt.Sub(session.Session.CreatedAt)
+		if ttl < 59*time.Second || ttl > 61*time.Second {
+			t.Errorf("got a session lasting %v, want about a minute", ttl)
+		}
+	})
+}
+
+func TestCreateUserPassword(t *testing.T) {
+	auth.PasswordIterations = 1000
+
+	t.Run("rejects a short password", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Per", Password: "short"})
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/users", body)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("rejects a taken name", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStoreWithData())
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "First Test User"})
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/users", body)
+		testutils.AssertStatus(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("never returns the password hash", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "Per", Password: "hunter2hunter2"})
+		response := testutils.MakeRequest(t, handler, http.MethodPost, "/users", body)
+
+		var raw map[string]any
+		json.NewDecoder(response.Body).Decode(&raw)
+		for key := range raw {
+			if key != "id" && key != "name" && key != "is_admin" && key != "created_at" {
+				t.Errorf("unexpected field %q in user response", key)
+			}
+		}
+	})
+}
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the document
+// returned by OpenAPI follows
+const OpenAPIVersion = "3.0.3"
+
+// OpenAPIDocument is the subset of an OpenAPI 3 document that velo uses
+type OpenAPIDocument struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       OpenAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+// OpenAPIInfo describes the API as a whole
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Operation describes one method on one path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter describes a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one possible response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas referred to from operations
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme describes how requests authenticate
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Schema is a JSON schema as used by OpenAPI 3.0
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Param documents a query or header parameter a route accepts. In is
+// "query" or "header".
+type Param struct {
+	In          string
+	Name        string
+	Type        string
+	Description string
+}
+
+// conditionalResponses are the extra responses of routes that accept
+// conditional request headers
+var conditionalResponses = map[string]int{
+	"If-None-Match": http.StatusNotModified,
+	"If-Match":      http.StatusPreconditionFailed,
+}
+
+// schemaDescriber is implemented by request types whose JSON form is not
+// described by their struct fields, such as a merge patch
+type schemaDescriber interface {
+	OpenAPISchema() *Schema
+}
+
+const bearerAuth = "bearerAuth"
+
+// OpenAPI describes every route in Routes, generating schemas for their
+// request and response bodies from the Go types
+func OpenAPI() OpenAPIDocument {
+	g := &schemaGenerator{schemas: map[string]*Schema{}}
+	doc := OpenAPIDocument{
+		OpenAPI: OpenAPIVersion,
+		Info:    OpenAPIInfo{Title: "velo", Version: "1.0.0"},
+		Paths:   map[string]map[string]Operation{},
+		Components: Components{
+			Schemas:         g.schemas,
+			SecuritySchemes: map[string]SecurityScheme{bearerAuth: {Type: "http", Scheme: "bearer"}},
+		},
+	}
+
+	problem := g.schemaFor(reflect.TypeFor[Problem]())
+	for _, rt := range Routes {
+		if doc.Paths[rt.Pattern] == nil {
+			doc.Paths[rt.Pattern] = map[string]Operation{}
+		}
+		doc.Paths[rt.Pattern][strings.ToLower(rt.Method)] = g.operation(rt, problem)
+	}
+	return doc
+}
+
+// operation describes a single route
+func (g *schemaGenerator) operation(rt Route, problem *Schema) Operation {
+	op := Operation{
+		OperationID: operationID(rt),
+		Summary:     rt.Summary,
+		Responses: map[string]Response{
+			"default": {
+				Description: "An error, described as a problem",
+				Content:     map[string]MediaType{ContentTypeProblemJSON: {Schema: problem}},
+			},
+		},
+		Security: []map[string][]string{{bearerAuth: {}}},
+	}
+	if rt.Public {
+		op.Security = []map[string][]string{}
+	}
+
+	for _, segment := range strings.Split(rt.Pattern, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     strings.TrimSuffix(name, "}"),
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+	for _, p := range rt.Params {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Schema:      &Schema{Type: p.Type},
+		})
+		if status, ok := conditionalResponses[p.Name]; ok && p.In == "header" {
+			op.Responses[strconv.Itoa(status)] = Response{Description: http.StatusText(status)}
+		}
+	}
+	if rt.Idempotent {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        IdempotencyKeyHeader,
+			In:          "header",
+			Description: "Replay the first response to a request with this key instead of repeating it",
+			Schema:      &Schema{Type: "string"},
+		})
+		op.Responses[strconv.Itoa(http.StatusUnprocessableEntity)] = Response{Description: "The key was already used with a different body"}
+	}
+	if rt.Role.Valid() {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        OrgHeader,
+			In:          "header",
+			Description: fmt.Sprintf("The org to work in; requires the %s role. May be omitted by members of a single org.", rt.Role),
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	if rt.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{ContentTypeJSON: {Schema: g.schemaFor(reflect.TypeOf(rt.Request))}},
+		}
+	}
+
+	success := Response{Description: http.StatusText(rt.Status)}
+	if rt.Response != nil {
+		success.Content = map[string]MediaType{ContentTypeJSON: {Schema: g.schemaFor(reflect.TypeOf(rt.Response))}}
+	}
+	op.Responses[strconv.Itoa(rt.Status)] = success
+	return op
+}
+
+// operationID turns "GET /items/{id}/restore" into "getItemsIdRestore"
+func operationID(rt Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(rt.Method))
+	for _, word := range strings.FieldsFunc(rt.Pattern, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.' || r == ':'
+	}) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// schemaGenerator builds schemas from Go types, collecting named structs as
+// components so that they are described once and referenced elsewhere
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	roleType = reflect.TypeFor[models.Role]()
+)
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if d, ok := reflect.New(t).Interface().(schemaDescriber); ok {
+		return g.component(t, d.OpenAPISchema)
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == roleType:
+		enum := make([]string, len(models.Roles))
+		for i, role := range models.Roles {
+			enum[i] = string(role)
+		}
+		return &Schema{Type: "string", Enum: enum}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := *g.schemaFor(t.Elem())
+		if s.Ref != "" {
+			return &s
+		}
+		s.Nullable = true
+		return &s
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		return g.component(t, func() *Schema { return g.structSchema(t) })
+	default:
+		panic(fmt.Sprintf("openapi: no schema for %s", t))
+	}
+}
+
+// component registers the schema built by describe under the type's name
+// and returns a reference to it
+func (g *schemaGenerator) component(t reflect.Type, describe func() *Schema) *Schema {
+	ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+	if _, ok := g.schemas[t.Name()]; !ok {
+		// Register before describing so self-referencing types terminate
+		g.schemas[t.Name()] = &Schema{}
+		*g.schemas[t.Name()] = *describe()
+	}
+	return ref
+}
+
+// structSchema describes the exported fields of a struct by their JSON
+// names. Fields without omitempty are listed as required.
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// OpenAPISchema describes the merge patch accepted by PATCH /items/{id}
+func (u *UpdateItemRequest) OpenAPISchema() *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":        {Type: "string", Nullable: true},
+			"external_id": {Type: "string", Nullable: true},
+			"org_id":      {Type: "string", Nullable: true},
+			"is_active":   {Type: "boolean"},
+		},
+	}
+}
+
+// serveOpenAPI returns the OpenAPI document describing this API
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.openAPI)
+}
@@ -0,0 +1,179 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	handler := api.RequireSession(testutils.NewStubAppStore(), api.NewHandler(testutils.NewStubAppStore()))
+	response := testutils.MakeRequest(t, handler, http.MethodGet, "/openapi.json", nil)
+
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&doc); err != nil {
+		t.Fatalf("could not decode the document: %v", err)
+	}
+
+	var got []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			got = append(got, strings.ToUpper(method)+" "+path)
+		}
+	}
+	slices.Sort(got)
+	want := []string{
+		"DELETE /items/{id}",
+		"DELETE /orgs/{org}",
+		"DELETE /orgs/{org}/members/{user}",
+		"DELETE /sessions/{id}",
+		"DELETE /webhooks/{id}",
+		"GET /audit",
+		"GET /healthz",
+		"GET /items",
+		"GET /items/events",
+		"GET /items/export",
+		"GET /items/search",
+		"GET /items/{id}",
+		"GET /metrics",
+		"GET /openapi.json",
+		"GET /orgs",
+		"GET /orgs/{org}",
+		"GET /orgs/{org}/members",
+		"GET /readyz",
+		"GET /sessions/{id}",
+		"GET /users/{id}",
+		"GET /webhooks",
+		"GET /webhooks/dead-letters",
+		"PATCH /items/{id}",
+		"POST /items",
+		"POST /items/import",
+		"POST /items/{id}/restore",
+		"POST /items:batch",
+		"POST /orgs",
+		"POST /sessions",
+		"POST /users",
+		"POST /webhooks",
+		"PUT /orgs/{org}/items/external/{externalID}",
+		"PUT /orgs/{org}/members/{user}",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got operations\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	item := doc.Components.Schemas["Item"].Properties
+	wantItem := map[string]string{
+		"id": "string", "name": "string", "external_id": "string", "org_id": "string", "is_active": "boolean",
+		"created_at": "string", "updated_at": "string", "created_by": "string", "deleted_at": "string", "version": "integer",
+	}
+	if len(item) != len(wantItem) {
+		t.Errorf("got Item properties %v, want %v", item, wantItem)
+	}
+	for name, typ := range wantItem {
+		if item[name].Type != typ {
+			t.Errorf("got Item property %s of type %q, want %q", name, item[name].Type, typ)
+		}
+	}
+}
+
+func TestOpenAPIMatchesRouteDetails(t *testing.T) {
+	doc := api.OpenAPI()
+
+	for _, route := range api.Routes {
+		t.Run(route.String(), func(t *testing.T) {
+			if route.Summary == "" {
+				t.Error("route has no Summary")
+			}
+			if route.Status == 0 {
+				t.Fatal("route has no success Status")
+			}
+
+			op, ok := doc.Paths[route.Pattern][strings.ToLower(route.Method)]
+			if !ok {
+				t.Fatal("route is missing from the document")
+			}
+
+			success, ok := op.Responses[strconv.Itoa(route.Status)]
+			if !ok {
+				t.Fatalf("no %d response described", route.Status)
+			}
+			if (route.Response != nil) != (success.Content != nil) {
+				t.Errorf("success response content %v does not match the route's Response %T", success.Content, route.Response)
+			}
+			if (route.Request != nil) != (op.RequestBody != nil) {
+				t.Errorf("request body %v does not match the route's Request %T", op.RequestBody, route.Request)
+			}
+			if route.Status == http.StatusNoContent && route.Response != nil {
+				t.Error("a 204 route cannot have a response body")
+			}
+		})
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := api.OpenAPI()
+
+	raw, _ := json.Marshal(doc)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := doc.Components.Schemas[name]; !ok {
+					t.Errorf("unresolved reference %q", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var generic any
+	json.Unmarshal(raw, &generic)
+	walk(generic)
+
+	for _, name := range []string{"Item", "User", "Session", "CreateItemRequest", "CreateUserRequest", "Problem"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("expected a %s schema", name)
+		}
+	}
+	if props := doc.Components.Schemas["User"].Properties; props["password_hash"] != nil || props["PasswordHash"] != nil {
+		t.Error("the User schema must not describe the password hash")
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	handler := api.RequireSession(testutils.NewStubAppStore(), api.NewHandler(testutils.NewStubAppStore()))
+
+	response := testutils.MakeRequest(t, handler, http.MethodGet, "/openapi.json", nil)
+	testutils.AssertStatus(t, response.Code, http.StatusOK)
+	testutils.AssertContentType(t, response, api.ContentTypeJSON)
+
+	var doc api.OpenAPIDocument
+	if err := json.NewDecoder(response.Body).Decode(&doc); err != nil {
+		t.Fatalf("could not decode the document: %v", err)
+	}
+	if doc.OpenAPI != api.OpenAPIVersion || len(doc.Paths) == 0 {
+		t.Errorf("got a document with version %q and %d paths", doc.OpenAPI, len(doc.Paths))
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+)
+
+func (h *Handler) createOrg(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	org, err := h.store.CreateOrg(models.CreateOrgInput{Name: req.Name})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceOrg, org.ID, org.ID, nil, org))
+	w.Header().Set("Location", fmt.Sprintf("/orgs/%s", org.ID))
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (h *Handler) getOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.store.GetOrgs()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (h *Handler) getOrg(w http.ResponseWriter, r *http.Request) {
+	org, err := h.store.GetOrg(r.PathValue("org"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+func (h *Handler) deleteOrg(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("org")
+	org, err := h.store.GetOrg(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := h.store.DeleteOrg(id); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionDelete, ResourceOrg, id, id, org, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.store.GetMembers(r.PathValue("org"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// addMember puts a user in an org with the requested role, replacing any
+// role they already had. It is idempotent, as PUT should be.
+func (h *Handler) addMember(w http.ResponseWriter, r *http.Request) {
+	var req AddMemberRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	orgID, userID := r.PathValue("org"), r.PathValue("user")
+	previous, err := h.membership(orgID, userID)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		respondWithError(w, err)
+		return
+	}
+
+	membership, err := h.store.AddMember(orgID, userID, req.Role)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if previous == nil {
+		h.record(h.auditEvent(r, audit.ActionCreate, ResourceMembership, userID, orgID, nil, membership))
+	} else {
+		h.record(h.auditEvent(r, audit.ActionUpdate, ResourceMembership, userID, orgID, *previous, membership))
+	}
+	respondWithJSON(w, http.StatusOK, membership)
+}
+
+func (h *Handler) removeMember(w http.ResponseWriter, r *http.Request) {
+	orgID, userID := r.PathValue("org"), r.PathValue("user")
+	membership, err := h.membership(orgID, userID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := h.store.RemoveMember(orgID, userID); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionDelete, ResourceMembership, userID, orgID, *membership, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// membership finds a user's membership of an org
+func (h *Handler) membership(orgID, userID string) (*models.Membership, error) {
+	members, err := h.store.GetMembers(orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if m.UserID == userID {
+			return &m, nil
+		}
+	}
+	return nil, fmt.Errorf("user %s in org %s: %w", userID, orgID, models.ErrNotFound)
+}
@@ -0,0 +1,236 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+// newTenantServer returns a server with two orgs, each holding one item and
+// one member with the org admin role, plus a system admin who belongs to
+// neither. The options configure the handler.
+func newTenantServer(t *testing.T, opts ...api.Option) (http.Handler, *testutils.StubAppStore) {
+	t.Helper()
+	auth.PasswordIterations = 1000
+
+	store := testutils.NewStubAppStore()
+	hash, _ := auth.HashPassword("hunter2hunter2")
+	for _, name := range []string{"acme", "globex"} {
+		org, _ := store.CreateOrg(models.CreateOrgInput{Name: name})
+		user, _ := store.CreateUser(models.CreateUserInput{Name: name + "-user", PasswordHash: hash})
+		store.AddMember(org.ID, user.ID, models.RoleAdmin)
+		store.CreateItem(models.CreateItemInput{Name: name + "-item", OrgID: org.ID})
+	}
+	store.CreateUser(models.CreateUserInput{Name: "admin", PasswordHash: hash, IsAdmin: true})
+
+	return api.RequireSession(store, api.Authorize(api.Policies, api.NewHandler(store, opts...))), store
+}
+
+func makeRequestInOrg(t testing.TB, server http.Handler, method, url, token, orgID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, url, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(api.OrgHeader, orgID)
+
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Run("lists only the caller's items", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var items []models.Item
+		json.NewDecoder(response.Body).Decode(&items)
+		testutils.AssertContainsIDs(t, items, store.Items[0].ID)
+	})
+
+	t.Run("other orgs' items are not found", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+		theirs := "/items/" + store.Items[1].ID
+
+		for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+			var body []byte
+			if method == http.MethodPatch {
+				body = []byte(`{"name": "mine now"}`)
+			}
+			response := makeRequestWithToken(t, server, method, theirs, session.Token, body)
+			testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+		}
+
+		response := makeRequestWithToken(t, server, http.MethodPost, theirs+"/restore", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("creates items in the caller's org", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "new"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+		var item models.Item
+		json.NewDecoder(response.Body).Decode(&item)
+		if item.OrgID != store.Orgs[0].ID {
+			t.Errorf("got org %q, want %q", item.OrgID, store.Orgs[0].ID)
+		}
+	})
+
+	t.Run("cannot move an item to another org", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(map[string]string{"org_id": store.Orgs[1].ID})
+		response := makeRequestWithToken(t, server, http.MethodPatch, "/items/"+store.Items[0].ID, session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+	})
+
+	t.Run("requires an org to reach items", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "admin", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("rejects an org the user does not belong to", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestInOrg(t, server, http.MethodGet, "/items", session.Token, store.Orgs[1].ID)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("admins may select any org", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "admin", "hunter2hunter2")
+
+		response := makeRequestInOrg(t, server, http.MethodGet, "/items", session.Token, store.Orgs[1].ID)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var items []models.Item
+		json.NewDecoder(response.Body).Decode(&items)
+		testutils.AssertContainsIDs(t, items, store.Items[1].ID)
+	})
+}
+
+func TestUserVisibility(t *testing.T) {
+	server, store := newTenantServer(t)
+	acme := login(t, server, "acme-user", "hunter2hunter2")
+	admin := login(t, server, "admin", "hunter2hunter2")
+	acmeUser, globexUser := store.Users[0].ID, store.Users[1].ID
+
+	t.Run("users see themselves", func(t *testing.T) {
+		response := makeRequestWithToken(t, server, http.MethodGet, "/users/"+acmeUser, acme.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("users in other orgs are not found", func(t *testing.T) {
+		response := makeRequestWithToken(t, server, http.MethodGet, "/users/"+globexUser, acme.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("system admins see everyone", func(t *testing.T) {
+		response := makeRequestWithToken(t, server, http.MethodGet, "/users/"+globexUser, admin.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("users sharing an org see each other", func(t *testing.T) {
+		store.AddMember(store.Orgs[0].ID, globexUser, models.RoleViewer)
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/users/"+globexUser, acme.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+	})
+
+	t.Run("nobody is found without a user", func(t *testing.T) {
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/users/"+acmeUser, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+}
+
+func TestOrgAdministration(t *testing.T) {
+	t.Run("admins manage orgs and members", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "admin", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateOrgRequest{Name: "initech"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/orgs", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+		var org models.Org
+		json.NewDecoder(response.Body).Decode(&org)
+		if response.Header().Get("Location") != "/orgs/"+org.ID {
+			t.Errorf("got Location %q, want /orgs/%s", response.Header().Get("Location"), org.ID)
+		}
+
+		user := store.Users[0]
+		body, _ = json.Marshal(api.AddMemberRequest{Role: models.RoleViewer})
+		response = makeRequestWithToken(t, server, http.MethodPut, "/orgs/"+org.ID+"/members/"+user.ID, session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		response = makeRequestWithToken(t, server, http.MethodGet, "/orgs/"+org.ID+"/members", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+		var members []models.Membership
+		json.NewDecoder(response.Body).Decode(&members)
+		if len(members) != 1 || members[0].UserID != user.ID || members[0].Role != models.RoleViewer {
+			t.Errorf("got members %+v, want only %s", members, user.ID)
+		}
+
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/orgs/"+org.ID+"/members/"+user.ID, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNoContent)
+
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/orgs/"+org.ID, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNoContent)
+
+		response = makeRequestWithToken(t, server, http.MethodGet, "/orgs/"+org.ID, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("refuses to delete an org that still has items", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "admin", "hunter2hunter2")
+		acme, item := store.Orgs[0].ID, store.Items[0].ID
+
+		response := makeRequestWithToken(t, server, http.MethodDelete, "/orgs/"+acme, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusConflict)
+		if _, err := store.GetOrg(acme); err != nil {
+			t.Errorf("want the org kept, got %v", err)
+		}
+
+		store.SoftDeleteItem(item, time.Now())
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/orgs/"+acme, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNoContent)
+	})
+
+	t.Run("members cannot manage orgs", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		for _, route := range []struct{ method, path string }{
+			{http.MethodGet, "/orgs"},
+			{http.MethodGet, "/orgs/" + store.Orgs[0].ID},
+			{http.MethodPut, "/orgs/" + store.Orgs[0].ID + "/members/" + store.Users[1].ID},
+		} {
+			response := makeRequestWithToken(t, server, route.method, route.path, session.Token, nil)
+			testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+		}
+	})
+}
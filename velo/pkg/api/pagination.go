@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// cursorToken is the position encoded, as base64url JSON, in an opaque
+// cursor. It records the sort it was issued for so it cannot be replayed
+// against a different ordering.
+type cursorToken struct {
+	Sort       models.ItemSort `json:"s"`
+	Descending bool            `json:"d,omitempty"`
+	ID         string          `json:"id"`
+	Name       string          `json:"n,omitempty"`
+	CreatedAt  time.Time       `json:"c"`
+}
+
+// encodeCursor returns the cursor for the page following item
+func encodeCursor(query models.ItemQuery, item models.Item) string {
+	token := cursorToken{
+		Sort:       query.Sort(),
+		Descending: query.Descending,
+		ID:         item.ID,
+		CreatedAt:  item.CreatedAt,
+	}
+	if token.Sort == models.SortByName {
+		token.Name = item.Name
+	}
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor reverses encodeCursor, checking it matches the query's sort
+func decodeCursor(query models.ItemQuery, cursor string) (*models.ItemCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var token cursorToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	if token.ID == "" {
+		return nil, fmt.Errorf("cursor has no position")
+	}
+	if token.Sort != query.Sort() || token.Descending != query.Descending {
+		return nil, fmt.Errorf("cursor was issued for a different sort")
+	}
+	return &models.ItemCursor{ID: token.ID, Name: token.Name, CreatedAt: token.CreatedAt}, nil
+}
+
+// parseItemQuery reads the filtering, sorting and paging parameters of
+// GET /items. Every invalid parameter is reported in the returned error.
+func parseItemQuery(values url.Values) (models.ItemQuery, error) {
+	query := models.ItemQuery{
+		OrgID:      values.Get("org_id"),
+		CreatedBy:  values.Get("created_by"),
+		ExternalID: values.Get("external_id"),
+	}
+	var rejected []RejectedField
+
+	if include := boolParam(values, "include_deleted", &rejected); include != nil {
+		query.IncludeDeleted = *include
+	}
+
+	query.IsActive = boolParam(values, "is_active", &rejected)
+
+	if raw := values.Get("sort"); raw != "" {
+		field, descending := strings.CutPrefix(raw, "-")
+		switch models.ItemSort(field) {
+		case models.SortByCreatedAt, models.SortByName:
+			query.SortBy = models.ItemSort(field)
+			query.Descending = descending
+		default:
+			rejected = append(rejected, RejectedField{Field: "sort", Reason: "must be created_at or name, optionally prefixed with -"})
+		}
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > models.MaxItemLimit {
+			rejected = append(rejected, RejectedField{Field: "limit", Reason: fmt.Sprintf("must be a number between 1 and %d", models.MaxItemLimit)})
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	if raw := values.Get("cursor"); raw != "" && len(rejected) == 0 {
+		after, err := decodeCursor(query, raw)
+		if err != nil {
+			rejected = append(rejected, RejectedField{Field: "cursor", Reason: "is not a valid cursor for this query"})
+		} else {
+			query.After = after
+		}
+	}
+
+	if len(rejected) > 0 {
+		return models.ItemQuery{}, &ValidationError{Fields: rejected}
+	}
+	return query, nil
+}
+
+// boolParam reads an optional true/false query parameter. It returns nil
+// when the parameter is absent or invalid, recording the latter in rejected.
+func boolParam(values url.Values, name string, rejected *[]RejectedField) *bool {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		*rejected = append(*rejected, RejectedField{Field: name, Reason: "must be true or false"})
+		return nil
+	}
+	return &value
+}
+
+// nextPageLink builds the RFC 8288 Link header value pointing at the next page
+func nextPageLink(u *url.URL, cursor string) string {
+	values := u.Query()
+	values.Set("cursor", cursor)
+	next := url.URL{Path: u.Path, RawQuery: values.Encode()}
+	return fmt.Sprintf(`<%s>; rel="next"`, next.String())
+}
@@ -0,0 +1,80 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestPolicies(t *testing.T) {
+	viewer := rbac.Principal{Role: models.RoleViewer}
+	editor := rbac.Principal{Role: models.RoleEditor}
+	orgAdmin := rbac.Principal{Role: models.RoleAdmin}
+	nobody := rbac.Principal{}
+
+	cases := []struct {
+		method, path string
+		principal    rbac.Principal
+		want         bool
+	}{
+		{http.MethodGet, "/items", viewer, true},
+		{http.MethodGet, "/items", nobody, false},
+		{http.MethodGet, "/items/item-1", viewer, true},
+		{http.MethodPost, "/items", viewer, false},
+		{http.MethodPost, "/items", editor, true},
+		{http.MethodPatch, "/items/item-1", editor, true},
+		{http.MethodDelete, "/items/item-1", editor, false},
+		{http.MethodDelete, "/items/item-1", orgAdmin, true},
+		{http.MethodPost, "/items/item-1/restore", orgAdmin, true},
+		{http.MethodGet, "/users/user-1", nobody, true},
+		{http.MethodDelete, "/sessions/session-1", nobody, true},
+		{http.MethodGet, "/orgs", orgAdmin, false},
+		{http.MethodPut, "/orgs/org-1/members/user-1", rbac.Principal{IsAdmin: true}, true},
+	}
+
+	for _, tc := range cases {
+		if got := api.Policies.Allows(tc.method, tc.path, tc.principal); got != tc.want {
+			t.Errorf("%s %s as %+v: got %v, want %v", tc.method, tc.path, tc.principal, got, tc.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Run("viewers cannot change items", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		acme := store.Orgs[0]
+		store.AddMember(acme.ID, store.Users[0].ID, models.RoleViewer)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "new"})
+		response = makeRequestWithToken(t, server, http.MethodPost, "/items", session.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+		if problem.Status != http.StatusForbidden || problem.Detail == "" {
+			t.Errorf("got problem %+v, want a 403 explaining the required role", problem)
+		}
+	})
+
+	t.Run("editors cannot delete items", func(t *testing.T) {
+		server, store := newTenantServer(t)
+		store.AddMember(store.Orgs[0].ID, store.Users[0].ID, models.RoleEditor)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodPatch, "/items/"+store.Items[0].ID, session.Token, []byte(`{"name": "renamed"}`))
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/items/"+store.Items[0].ID, session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusForbidden)
+	})
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+)
+
+// Route is one endpoint of the API. Pattern uses the net/http ServeMux
+// syntax, and its wildcards are read with Request.PathValue. Summary,
+// Request, Response, Status and Params describe the route in the OpenAPI
+// document; Request and Response are zero values of the body types.
+type Route struct {
+	Method  string
+	Pattern string
+	// Role is required in the caller's org; see Policies
+	Role models.Role
+	// Public routes can be called without a session
+	Public bool
+	// Idempotent routes replay their response for a repeated Idempotency-Key
+	Idempotent bool
+
+	Summary  string
+	Request  any
+	Response any
+	Status   int
+	Params   []Param
+
+	handle func(*Handler, http.ResponseWriter, *http.Request)
+}
+
+// itemListParams documents the query parameters parsed by parseItemQuery
+var itemListParams = []Param{
+	{In: "query", Name: "org_id", Type: "string", Description: "Only items in this org"},
+	{In: "query", Name: "created_by", Type: "string", Description: "Only items created by this user"},
+	{In: "query", Name: "external_id", Type: "string", Description: "Only the item synced from a partner system with this ID"},
+	{In: "query", Name: "is_active", Type: "boolean", Description: "Only active or inactive items"},
+	{In: "query", Name: "include_deleted", Type: "boolean", Description: "Include soft-deleted items"},
+	{In: "query", Name: "sort", Type: "string", Description: "created_at or name, prefixed with - for descending order"},
+	{In: "query", Name: "limit", Type: "integer", Description: "Page size, at most 200"},
+	{In: "query", Name: "cursor", Type: "string", Description: "Opaque cursor from the previous page's Link header"},
+}
+
+var getItemParams = []Param{
+	{In: "query", Name: "include_deleted", Type: "boolean", Description: "Return the item even if it was soft-deleted"},
+	{In: "header", Name: "If-None-Match", Type: "string", Description: "Answer 304 if the item still has one of these ETags"},
+}
+
+var updateItemParams = []Param{
+	{In: "header", Name: "If-Match", Type: "string", Description: "Only apply the patch if the item still has one of these ETags"},
+}
+
+// Routes is every endpoint the Handler serves. Reading items needs a
+// viewer, changing them an editor, and deleting or restoring them, reading
+// the audit log or managing webhooks an admin. Sessions and users are not
+// owned by an org, and orgs are managed by system admins.
+var Routes = []Route{
+	{
+		Method: http.MethodGet, Pattern: "/items", Role: models.RoleViewer,
+		Summary: "List a page of items", Response: []models.Item{}, Status: http.StatusOK, Params: itemListParams,
+		handle: (*Handler).getItems,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/items", Role: models.RoleEditor, Idempotent: true,
+		Summary: "Create an item", Request: CreateItemRequest{}, Response: models.Item{}, Status: http.StatusCreated,
+		handle: (*Handler).createItem,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/items:batch", Role: models.RoleEditor, Idempotent: true,
+		Summary: "Create, update and delete items in one request", Request: BatchRequest{}, Response: BatchResponse{}, Status: http.StatusOK,
+		handle: (*Handler).batchItems,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/items/export", Role: models.RoleViewer,
+		Summary: "Download every matching item as CSV or NDJSON", Status: http.StatusOK, Params: exportParams,
+		handle: (*Handler).exportItems,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/items/import", Role: models.RoleEditor,
+		Summary: "Create an item from each line of a CSV or NDJSON upload", Response: ImportReport{}, Status: http.StatusOK, Params: importParams,
+		handle: (*Handler).importItems,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/items/events", Role: models.RoleViewer,
+		Summary: "Stream item changes as Server-Sent Events", Status: http.StatusOK, Params: itemEventsParams,
+		handle: (*Handler).streamItemEvents,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/items/search", Role: models.RoleViewer,
+		Summary: "Search items by name, most relevant first", Response: []models.ItemSearchResult{}, Status: http.StatusOK, Params: searchParams,
+		handle: (*Handler).searchItems,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/items/{id}", Role: models.RoleViewer,
+		Summary: "Get an item", Response: models.Item{}, Status: http.StatusOK, Params: getItemParams,
+		handle: (*Handler).getItem,
+	},
+	{
+		Method: http.MethodPatch, Pattern: "/items/{id}", Role: models.RoleEditor,
+		Summary: "Update an item with a JSON merge patch", Request: UpdateItemRequest{}, Response: models.Item{}, Status: http.StatusOK, Params: updateItemParams,
+		handle: (*Handler).updateItem,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/items/{id}", Role: models.RoleAdmin,
+		Summary: "Soft-delete an item", Status: http.StatusNoContent,
+		handle: (*Handler).deleteItem,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/items/{id}/restore", Role: models.RoleAdmin,
+		Summary: "Restore a soft-deleted item", Response: models.Item{}, Status: http.StatusOK,
+		handle: (*Handler).restoreItem,
+	},
+	{
+		Method: http.MethodPut, Pattern: "/orgs/{org}/items/external/{externalID}", Role: models.RoleEditor,
+		Summary: "Create or replace the item a partner system knows by an external ID", Request: PutItemRequest{}, Response: models.Item{}, Status: http.StatusOK,
+		handle: (*Handler).putExternalItem,
+	},
+
+	{
+		Method: http.MethodGet, Pattern: "/audit", Role: models.RoleAdmin,
+		Summary: "List audit events for changes in your org", Response: []audit.Event{}, Status: http.StatusOK, Params: auditParams,
+		handle: (*Handler).getAudit,
+	},
+
+	{
+		Method: http.MethodPost, Pattern: "/webhooks", Role: models.RoleAdmin,
+		Summary: "Subscribe a URL to item and user events", Request: CreateWebhookRequest{}, Response: CreateWebhookResponse{}, Status: http.StatusCreated,
+		handle: (*Handler).createWebhook,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/webhooks", Role: models.RoleAdmin,
+		Summary: "List webhooks", Response: []webhook.Subscription{}, Status: http.StatusOK,
+		handle: (*Handler).getWebhooks,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/webhooks/{id}", Role: models.RoleAdmin,
+		Summary: "Delete a webhook", Status: http.StatusNoContent,
+		handle: (*Handler).deleteWebhook,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/webhooks/dead-letters", Role: models.RoleAdmin,
+		Summary: "List webhook deliveries that failed every attempt", Response: []webhook.DeadLetter{}, Status: http.StatusOK,
+		handle: (*Handler).getDeadLetters,
+	},
+
+	{
+		Method: http.MethodPost, Pattern: "/users", Public: true, Idempotent: true,
+		Summary: "Sign up a user", Request: CreateUserRequest{}, Response: models.User{}, Status: http.StatusCreated,
+		handle: (*Handler).createUser,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/users/{id}",
+		Summary: "Get yourself, or a user sharing an org with you", Response: models.User{}, Status: http.StatusOK,
+		handle: (*Handler).getUser,
+	},
+
+	{
+		Method: http.MethodPost, Pattern: "/sessions", Public: true,
+		Summary: "Log in", Request: LoginRequest{}, Response: LoginResponse{}, Status: http.StatusCreated,
+		handle: (*Handler).login,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/sessions/{id}",
+		Summary: "Get one of your sessions", Response: models.Session{}, Status: http.StatusOK,
+		handle: (*Handler).getSession,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/sessions/{id}",
+		Summary: "Log out", Status: http.StatusNoContent,
+		handle: (*Handler).logout,
+	},
+
+	{
+		Method: http.MethodGet, Pattern: "/orgs", Role: rbac.RoleSystemAdmin,
+		Summary: "List orgs", Response: []models.Org{}, Status: http.StatusOK,
+		handle: (*Handler).getOrgs,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/orgs", Role: rbac.RoleSystemAdmin,
+		Summary: "Create an org", Request: CreateOrgRequest{}, Response: models.Org{}, Status: http.StatusCreated,
+		handle: (*Handler).createOrg,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/orgs/{org}", Role: rbac.RoleSystemAdmin,
+		Summary: "Get an org", Response: models.Org{}, Status: http.StatusOK,
+		handle: (*Handler).getOrg,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/orgs/{org}", Role: rbac.RoleSystemAdmin,
+		Summary: "Delete an org that has no items, with its memberships and deleted items", Status: http.StatusNoContent,
+		handle: (*Handler).deleteOrg,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/orgs/{org}/members", Role: rbac.RoleSystemAdmin,
+		Summary: "List the members of an org", Response: []models.Membership{}, Status: http.StatusOK,
+		handle: (*Handler).getMembers,
+	},
+	{
+		Method: http.MethodPut, Pattern: "/orgs/{org}/members/{user}", Role: rbac.RoleSystemAdmin,
+		Summary: "Add a user to an org or change their role", Request: AddMemberRequest{}, Response: models.Membership{}, Status: http.StatusOK,
+		handle: (*Handler).addMember,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/orgs/{org}/members/{user}", Role: rbac.RoleSystemAdmin,
+		Summary: "Remove a user from an org", Status: http.StatusNoContent,
+		handle: (*Handler).removeMember,
+	},
+
+	{
+		Method: http.MethodGet, Pattern: "/openapi.json", Public: true,
+		Summary: "This OpenAPI document", Response: map[string]any{}, Status: http.StatusOK,
+		handle: (*Handler).serveOpenAPI,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/healthz", Public: true,
+		Summary: "Report that the server is running", Response: HealthStatus{}, Status: http.StatusOK,
+		handle: (*Handler).healthz,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/readyz", Public: true,
+		Summary: "Report whether the server can reach its store", Response: HealthStatus{}, Status: http.StatusOK,
+		handle: (*Handler).readyz,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/metrics", Public: true,
+		Summary: "Request and store metrics in the Prometheus text format", Status: http.StatusOK,
+		handle: (*Handler).serveMetrics,
+	},
+}
+
+// String returns the route as a ServeMux pattern, such as "GET /items/{id}"
+func (rt Route) String() string {
+	return rt.Method + " " + rt.Pattern
+}
+
+// Policies lists the role each route requires in the caller's org
+var Policies = policiesFor(Routes)
+
+func policiesFor(routes []Route) rbac.Table {
+	table := make(rbac.Table, len(routes))
+	for i, rt := range routes {
+		table[i] = rbac.Policy{Method: rt.Method, Pattern: rt.Pattern, Role: rt.Role}
+	}
+	return table
+}
+
+// publicRoutes can be called without a session: logging in, signing up,
+// the API description and the health checks.
+// Public patterns have no wildcards, so they are looked up by request path.
+var publicRoutes = publicRoutesIn(Routes)
+
+func publicRoutesIn(routes []Route) map[string]bool {
+	public := map[string]bool{}
+	for _, rt := range routes {
+		if rt.Public {
+			public[rt.String()] = true
+		}
+	}
+	return public
+}
+
+// newRouter registers every route with a ServeMux
+func (h *Handler) newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, rt := range Routes {
+		handle := rt.handle
+		var handler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+			handle(h, w, r)
+		}
+		if rt.Idempotent {
+			handler = h.idempotent(handler)
+		}
+		mux.HandleFunc(rt.String(), handler)
+	}
+	return mux
+}
+
+// unrouted answers a request that no route matched. The mux's own handler
+// for it is run against a recorder to learn whether the path exists for
+// other methods, in which case it also reports them in the Allow header.
+func (h *Handler) unrouted(w http.ResponseWriter, r *http.Request) {
+	handler, _ := h.mux.Handler(r)
+	rec := &statusRecorder{header: http.Header{}}
+	handler.ServeHTTP(rec, r)
+
+	if rec.status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", rec.header.Get("Allow"))
+		methodNotAllowed(w, r)
+		return
+	}
+	respondWithProblem(w, NewProblem(http.StatusNotFound, fmt.Sprintf("no route for %s", r.URL.Path)))
+}
+
+// statusRecorder is a ResponseWriter that keeps the status and headers
+// written to it and discards the body
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (s *statusRecorder) Header() http.Header         { return s.header }
+func (s *statusRecorder) WriteHeader(status int)      { s.status = status }
+func (s *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// searchParams documents the query parameters parsed by parseItemSearch
+var searchParams = []Param{
+	{In: "query", Name: "q", Type: "string", Description: "Words to find in item names, each matching whole words or their prefixes"},
+	{In: "query", Name: "org_id", Type: "string", Description: "Only items in this org"},
+	{In: "query", Name: "limit", Type: "integer", Description: fmt.Sprintf("Number of results, at most %d", models.MaxSearchLimit)},
+}
+
+// searchItems finds live items by the words in their names, most relevant
+// first
+func (h *Handler) searchItems(w http.ResponseWriter, r *http.Request) {
+	search, err := parseItemSearch(r.URL.Query())
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	store, err := h.itemStore(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	results, err := store.SearchItems(search)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// parseItemSearch reads the parameters of GET /items/search. Every invalid
+// parameter is reported in the returned error.
+func parseItemSearch(values url.Values) (models.ItemSearch, error) {
+	search := models.ItemSearch{
+		Text:  values.Get("q"),
+		OrgID: values.Get("org_id"),
+	}
+	var rejected []RejectedField
+
+	if strings.TrimSpace(search.Text) == "" {
+		rejected = append(rejected, RejectedField{Field: "q", Reason: "is required"})
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > models.MaxSearchLimit {
+			rejected = append(rejected, RejectedField{Field: "limit", Reason: fmt.Sprintf("must be a number between 1 and %d", models.MaxSearchLimit)})
+		} else {
+			search.Limit = limit
+		}
+	}
+
+	if len(rejected) > 0 {
+		return models.ItemSearch{}, &ValidationError{Fields: rejected}
+	}
+	return search, nil
+}
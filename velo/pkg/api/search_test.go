@@ -0,0 +1,77 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestSearchItems(t *testing.T) {
+	names := func(t testing.TB, response *http.Response) []string {
+		t.Helper()
+		var results []models.ItemSearchResult
+		if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+			t.Fatalf("could not decode results: %v", err)
+		}
+		out := []string{}
+		for _, result := range results {
+			out = append(out, result.Item.Name)
+		}
+		return out
+	}
+
+	t.Run("returns the best matches first", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		for _, name := range []string{"Bluetooth speaker", "Blue mug", "Red mug"} {
+			store.CreateItem(models.CreateItemInput{Name: name})
+		}
+		deleted, _ := store.CreateItem(models.CreateItemInput{Name: "Blue plate"})
+		store.SoftDeleteItem(deleted.ID, time.Now())
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items/search?q=blue", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		want := []string{"Blue mug", "Bluetooth speaker"}
+		if got := names(t, response.Result()); !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("only searches the caller's org", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		session := login(t, server, "acme-user", "hunter2hunter2")
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/items/search?q=item", session.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		want := []string{"acme-item"}
+		if got := names(t, response.Result()); !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStore())
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items/search?q=+&limit=500", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+
+		var fields []string
+		for _, f := range problem.InvalidParams {
+			fields = append(fields, f.Field)
+		}
+		if want := []string{"q", "limit"}; !slices.Equal(fields, want) {
+			t.Errorf("got invalid params %v, want %v", fields, want)
+		}
+	})
+}
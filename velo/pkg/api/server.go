@@ -2,261 +2,562 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/feed"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/idempotency"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/tenant"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
 )
 
+// DefaultSessionTTL is how long a login stays valid unless configured
+const DefaultSessionTTL = 24 * time.Hour
+
+// DefaultIdempotencyTTL is how long responses are kept for replay unless
+// configured
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyKeys is how many idempotency keys are kept unless
+// configured
+const DefaultIdempotencyKeys = 10000
+
 // Handler manages the API endpoints
 type Handler struct {
-	store models.AppStore
+	store       models.AppStore
+	sessionTTL  time.Duration
+	idempotency *idempotency.Cache
+	mux         *http.ServeMux
+	openAPI     OpenAPIDocument
+	// policies authorizes the operations inside a batch
+	policies rbac.Table
+	audit    audit.Sink
+	webhooks *webhook.Dispatcher
+	feed     *feed.Broker
+	metrics  *metrics.Registry
+	// pinger checks the store for GET /readyz, if the store can be checked
+	pinger models.Pinger
+	// streamsDone is closed when event streams should end
+	streamsDone <-chan struct{}
 }
 
-func NewHandler(store models.AppStore) *Handler {
-	return &Handler{store: store}
-}
+// Option configures a Handler
+type Option func(*Handler)
 
-// ServeHTTP handles all API requests
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Route to the appropriate handler based on the path
-	path := r.URL.Path
+// WithSessionTTL sets how long sessions issued by login stay valid
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(h *Handler) {
+		h.sessionTTL = ttl
+	}
+}
 
-	if path == "/items" {
-		h.handleItems(w, r)
-		return
+// WithIdempotencyCache sets where responses to requests with an
+// Idempotency-Key are kept
+func WithIdempotencyCache(cache *idempotency.Cache) Option {
+	return func(h *Handler) {
+		h.idempotency = cache
 	}
+}
 
-	if strings.HasPrefix(path, "/items/") {
-		h.handleItem(w, r)
-		return
+// WithAuditSink sets where the audit events of every change are recorded
+func WithAuditSink(sink audit.Sink) Option {
+	return func(h *Handler) {
+		h.audit = sink
 	}
+}
 
-	if strings.HasPrefix(path, "/sessions/"){
-		h.handleSessions(w, r)
-		return
+// WithWebhooks sets the dispatcher that delivers item and user events to
+// subscribed URLs
+func WithWebhooks(dispatcher *webhook.Dispatcher) Option {
+	return func(h *Handler) {
+		h.webhooks = dispatcher
 	}
+}
 
-	if strings.HasPrefix(path, "/users/"){
-		h.handleUser(w, r)
-		return
+// WithMetrics sets the registry that GET /metrics exposes
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(h *Handler) {
+		h.metrics = registry
 	}
+}
 
-	if strings.HasPrefix(path, "/users"){ 
-		h.handleUsers(w, r)
-		return
+// WithChangeFeed sets the broker that item changes are published to and
+// GET /items/events streams from
+func WithChangeFeed(broker *feed.Broker) Option {
+	return func(h *Handler) {
+		h.feed = broker
 	}
+}
 
-	// Handle unknown paths
-	w.WriteHeader(http.StatusNotFound)
+// WithStreamsDone sets a channel whose closing ends every open GET
+// /items/events stream, so that a server shutting down need not wait for
+// clients that would otherwise stay connected forever. Other requests are
+// left to finish.
+func WithStreamsDone(done <-chan struct{}) Option {
+	return func(h *Handler) {
+		h.streamsDone = done
+	}
 }
 
-func (h *Handler) handleUsers(w http.ResponseWriter, r *http.Request){
-	switch r.Method{
-	case http.MethodPost:
-		 h.createUser(w, r)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func NewHandler(store models.AppStore, opts ...Option) *Handler {
+	h := &Handler{
+		store:       store,
+		sessionTTL:  DefaultSessionTTL,
+		idempotency: idempotency.NewCache(DefaultIdempotencyTTL, DefaultIdempotencyKeys),
+		policies:    Policies,
+		audit:       audit.NewMemorySink(DefaultAuditBuffer),
+		webhooks:    webhook.NewDispatcher(),
+		feed:        feed.NewBroker(DefaultReplayBuffer),
+		metrics:     metrics.NewRegistry(),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	// The change feed's wrapper hides whether the store can be pinged
+	h.pinger, _ = h.store.(models.Pinger)
+	h.store = feed.Publishing(h.store, h.feed)
+	h.mux = h.newRouter()
+	h.openAPI = OpenAPI()
+	return h
 }
 
-func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
+// ServeHTTP routes the request through the Routes table. Requests no route
+// matches get a 404 problem, or a 405 problem with an Allow header when the
+// path exists but not for the request's method.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := h.mux.Handler(r); pattern != "" {
+		h.mux.ServeHTTP(w, r)
 		return
 	}
+	h.unrouted(w, r)
+}
 
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 	var req CreateUserRequest
-	// bad json
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
 		return
 	}
 
 	// validate the data
 	if err := req.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		respondWithError(w, err)
 		return
 	}
 
-	// valid data
-	createdUser, err := h.store.CreateUser(models.CreateUserInput{
-		Name: req.Name,
-	})
+	input := models.CreateUserInput{Name: req.Name}
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		input.PasswordHash = hash
+	}
 
+	createdUser, err := h.store.CreateUser(input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		respondWithError(w, err)
 		return
 	}
 
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceUser, createdUser.ID, "", nil, createdUser))
 	w.Header().Set("Location", fmt.Sprintf("/users/%s", createdUser.ID))
 	respondWithJSON(w, http.StatusCreated, createdUser)
-
 }
 
-func (h *Handler) handleUser(w http.ResponseWriter, r *http.Request){
-	id := strings.TrimPrefix(r.URL.Path, "/users/")
-
-	switch r.Method{
-	case http.MethodGet:
-		h.getUser(w, id)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// getUser returns a user the caller may see: themselves, anyone sharing an
+// org with them, or anyone at all for a system admin. Other users are not
+// found, so they cannot be told apart from users that do not exist.
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := h.canSeeUser(r, id); err != nil {
+		respondWithError(w, err)
+		return
 	}
-}
 
-func (h *Handler) getUser(w http.ResponseWriter, id string){
 	user, err := h.store.GetUser(id)
-
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		respondWithError(w, err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, user)
 }
 
-// handleItem processes requests for individual items
-func (h *Handler) handleItem(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/items/")
+// canSeeUser returns an error wrapping models.ErrNotFound unless the
+// request's user may see user id. Without a user nobody may be seen.
+func (h *Handler) canSeeUser(r *http.Request, id string) error {
+	notFound := fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+	caller, ok := UserFromContext(r.Context())
+	if !ok {
+		return notFound
+	}
+	if caller.IsAdmin || caller.ID == id {
+		return nil
+	}
 
-	switch r.Method {
-	case http.MethodGet:
-		h.getItem(w, id)
-	case http.MethodPatch:
-		h.updateItem(w, r, id)
-	case http.MethodDelete:
-		h.deleteItem(w, id)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	mine, err := h.store.GetMemberships(caller.ID)
+	if err != nil {
+		return err
+	}
+	theirs, err := h.store.GetMemberships(id)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return err
 	}
+	for _, m := range theirs {
+		if slices.ContainsFunc(mine, func(own models.Membership) bool { return own.OrgID == m.OrgID }) {
+			return nil
+		}
+	}
+	return notFound
 }
 
-// getItem retrieves a single item
-func (h *Handler) getItem(w http.ResponseWriter, id string) {
-	item, err := h.store.GetItem(id)
+// getItem retrieves a single item. Soft-deleted items are only returned
+// when include_deleted=true. A request whose If-None-Match lists the item's
+// current ETag gets a 304 without a body.
+func (h *Handler) getItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var rejected []RejectedField
+	includeDeleted := boolParam(r.URL.Query(), "include_deleted", &rejected)
+	if len(rejected) > 0 {
+		respondWithError(w, &ValidationError{Fields: rejected})
+		return
+	}
+
+	store, err := h.itemStore(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		respondWithError(w, err)
+		return
+	}
+
+	item, err := liveItem(store, id, includeDeleted != nil && *includeDeleted)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	etag := itemETag(item)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, item)
+	respondWithItem(w, http.StatusOK, item)
 }
 
-// updateItem updates an existing item
-func (h *Handler) updateItem(w http.ResponseWriter, r *http.Request, id string) {
-	var updates map[string]any
-	err := json.NewDecoder(r.Body).Decode(&updates)
+// updateItem applies a JSON Merge Patch to an existing item. With If-Match
+// the patch is only applied if the item still has one of the listed ETags,
+// otherwise the response is 412 Precondition Failed.
+func (h *Handler) updateItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req UpdateItemRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	store, err := h.itemStore(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		respondWithError(w, err)
 		return
 	}
 
-	updatedItem, err := h.store.UpdateItem(id, updates)
+	current, err := liveItem(store, id, false)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		respondWithError(w, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, updatedItem)
+	update := req.ItemUpdate()
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, itemETag(current), false) {
+			respondWithError(w, fmt.Errorf("item %s has changed: %w", id, models.ErrVersionMismatch))
+			return
+		}
+		// The store repeats the check atomically, in case of a concurrent update
+		update.IfVersion = current.Version
+	}
+
+	updatedItem, err := store.UpdateItem(id, update)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionUpdate, ResourceItem, id, updatedItem.OrgID, current, updatedItem))
+
+	respondWithItem(w, http.StatusOK, updatedItem)
 }
 
-// deleteItem removes an item
-func (h *Handler) deleteItem(w http.ResponseWriter, id string) {
-	err := h.store.DeleteItem(id)
+// deleteItem soft-deletes an item by stamping its DeletedAt
+func (h *Handler) deleteItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	store, err := h.itemStore(r)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		respondWithError(w, err)
 		return
 	}
+
+	current, err := liveItem(store, id, false)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	deleted, err := store.SoftDeleteItem(id, time.Now())
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionDelete, ResourceItem, id, deleted.OrgID, current, deleted))
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleItems processes requests for collections of items
-func (h *Handler) handleItems(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		h.createItem(w, r)
-	case http.MethodGet:
-		h.getItems(w)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+// restoreItem undoes a soft delete. Restoring a live item is a conflict.
+func (h *Handler) restoreItem(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	store, err := h.itemStore(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	current, err := store.GetItem(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
 	}
-}
 
-// getItems retrieves all items
-func (h *Handler) getItems(w http.ResponseWriter) {
-	items, err := h.store.GetItems()
+	item, err := store.RestoreItem(id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		respondWithError(w, err)
 		return
 	}
+	h.record(h.auditEvent(r, audit.ActionUpdate, ResourceItem, id, item.OrgID, current, item))
+	respondWithItem(w, http.StatusOK, item)
+}
 
-	respondWithJSON(w, http.StatusOK, items)
+// itemStore returns the store item routes should use: confined to the org
+// selected for the request, or the whole store when no user is
+// authenticated (the handler is being used without RequireSession). A user
+// who has not selected an org cannot reach any items.
+func (h *Handler) itemStore(r *http.Request) (models.AppStore, error) {
+	return scopeStore(r, h.store)
 }
 
-// createItem creates a item
-func (h *Handler) createItem(w http.ResponseWriter, r *http.Request){
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
+// scopeStore confines store to the request's org, as itemStore does for the
+// handler's own store
+func scopeStore(r *http.Request, store models.AppStore) (models.AppStore, error) {
+	if orgID, ok := OrgFromContext(r.Context()); ok {
+		return tenant.Scope(store, orgID), nil
+	}
+	if _, ok := UserFromContext(r.Context()); ok {
+		return nil, errNoOrg
+	}
+	return store, nil
+}
+
+// liveItem gets an item, treating soft-deleted items as not found
+// unless includeDeleted is set
+func liveItem(store models.ItemStore, id string, includeDeleted bool) (models.Item, error) {
+	item, err := store.GetItem(id)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if item.IsDeleted() && !includeDeleted {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	return item, nil
+}
+
+// getItems retrieves a page of items matching the query parameters.
+// When more items follow, a Link header points at the next page.
+func (h *Handler) getItems(w http.ResponseWriter, r *http.Request) {
+	query, err := parseItemQuery(r.URL.Query())
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	store, err := h.itemStore(r)
+	if err != nil {
+		respondWithError(w, err)
 		return
 	}
 
+	page, err := store.QueryItems(query)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if page.HasMore && len(page.Items) > 0 {
+		cursor := encodeCursor(query, page.Items[len(page.Items)-1])
+		w.Header().Set("Link", nextPageLink(r.URL, cursor))
+	}
+
+	respondWithJSON(w, http.StatusOK, page.Items)
+}
+
+// createItem creates a item
+func (h *Handler) createItem(w http.ResponseWriter, r *http.Request) {
 	var req CreateItemRequest
-	// bad json
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
 		return
 	}
 
 	// validate the data
 	if err := req.Validate(); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		respondWithError(w, err)
 		return
 	}
 
-	// valid data
-	createdItem, err := h.store.CreateItem(models.CreateItemInput{
-		Name: req.Name,
-	})
+	store, err := h.itemStore(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		respondWithError(w, err)
 		return
 	}
 
-	w.Header().Set("Location", fmt.Sprintf("/items/%s", createdItem.ID))
-	respondWithJSON(w, http.StatusCreated, createdItem)
+	input := models.CreateItemInput{Name: req.Name}
+	if user, ok := UserFromContext(r.Context()); ok {
+		input.CreatedBy = user.ID
+	}
 
+	createdItem, err := store.CreateItem(input)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceItem, createdItem.ID, createdItem.OrgID, nil, createdItem))
+	w.Header().Set("Location", fmt.Sprintf("/items/%s", createdItem.ID))
+	respondWithItem(w, http.StatusCreated, createdItem)
 }
 
+// dummyPasswordHash is checked in place of a hash the login does not have.
+// It is made on first use, at the work factor in effect then.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("velo-dummy-password")
+	return hash
+})
+
+// login exchanges a user's name and password for a new session token
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	user, err := h.store.GetUserByName(req.Name)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		respondWithError(w, err)
+		return
+	}
+
+	// Unknown names and users without a password are checked against a
+	// dummy hash, so that how long a failed login takes does not tell
+	// which names exist
+	hash := user.PasswordHash
+	if err != nil || hash == "" {
+		hash = dummyPasswordHash()
+	}
+	if ok, checkErr := auth.CheckPassword(hash, req.Password); err != nil || user.PasswordHash == "" || checkErr != nil || !ok {
+		respondWithError(w, errInvalidCredentials)
+		return
+	}
 
-// handleSessions processes requests for sessions
-func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request){
-	id := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	token, err := auth.NewToken()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
 
-	switch r.Method{
-	case http.MethodGet:
-		h.getSession(w, id)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+	session, err := h.store.CreateSession(models.CreateSessionInput{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(h.sessionTTL).UTC(),
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
 	}
+
+	event := h.auditEvent(r, audit.ActionCreate, ResourceSession, session.ID, "", nil, session)
+	event.ActorID = user.ID
+	h.record(event)
+	w.Header().Set("Location", fmt.Sprintf("/sessions/%s", session.ID))
+	respondWithJSON(w, http.StatusCreated, LoginResponse{Token: token, Session: session})
 }
 
-func (h *Handler) getSession(w http.ResponseWriter, id string){
-	
-	session, err := h.store.GetSession(id)
+func (h *Handler) getSession(w http.ResponseWriter, r *http.Request) {
+	session, err := h.ownSession(r, r.PathValue("id"))
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
+		respondWithError(w, err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, session)
 }
 
+// logout ends a session so its token can no longer be used
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	session, err := h.ownSession(r, id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
 
+	if err := h.store.DeleteSession(id); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionDelete, ResourceSession, id, "", session, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownSession gets a session, hiding sessions that belong to a user other
+// than the authenticated one
+func (h *Handler) ownSession(r *http.Request, id string) (models.Session, error) {
+	session, err := h.store.GetSession(id)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if user, ok := UserFromContext(r.Context()); ok && user.ID != session.UserID {
+		return models.Session{}, fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+	}
+	return session, nil
+}
+
+// methodNotAllowed writes a 405 problem for the request's method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondWithProblem(w, NewProblem(http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path)))
+}
 
 // respondWithJSON sends a JSON response with the given status code
 func respondWithJSON(w http.ResponseWriter, status int, data any) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", ContentTypeJSON)
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/espennoreng/learn-go-with-tests/velo/models"
@@ -16,16 +19,19 @@ func TestInvalidRouting(t *testing.T) {
 		name           string
 		method         string
 		path           string
-		body 			[]byte
+		body           []byte
 		expectedStatus int
 	}{
 		{"create item with empty body", http.MethodPost, "/items", nil, http.StatusBadRequest},
-		{"invalid method on /items", http.MethodPatch, "/items", nil, http.StatusMethodNotAllowed},		
+		{"invalid method on /items", http.MethodPatch, "/items", nil, http.StatusMethodNotAllowed},
 		{"invalid method on /users", http.MethodPatch, "/users", nil, http.StatusMethodNotAllowed},
 		{"invalid method on /items/{}", http.MethodPost, "/items/item-001", nil, http.StatusMethodNotAllowed},
 		{"invalid method on /users/{}", http.MethodDelete, "/users/random-id", nil, http.StatusMethodNotAllowed},
 		{"invalid method on sessions", http.MethodPatch, "/sessions/session-001", nil, http.StatusMethodNotAllowed},
 		{"invalid path", http.MethodGet, "/unknown", nil, http.StatusNotFound},
+		{"path extending a resource name", http.MethodPost, "/usersfoo", nil, http.StatusNotFound},
+		{"nested path under an item", http.MethodGet, "/items/a/b", nil, http.StatusNotFound},
+		{"trailing slash on a collection", http.MethodGet, "/items/", nil, http.StatusNotFound},
 	}
 
 	for _, tc := range tests {
@@ -42,13 +48,41 @@ func TestInvalidRouting(t *testing.T) {
 	}
 }
 
+func TestMethodNotAllowed(t *testing.T) {
+	tests := []struct {
+		method, path string
+		allow        []string
+	}{
+		{http.MethodPut, "/items", []string{"GET", "HEAD", "POST"}},
+		{http.MethodPost, "/items/item-001", []string{"DELETE", "GET", "HEAD", "PATCH"}},
+		{http.MethodGet, "/items/item-001/restore", []string{"POST"}},
+		{http.MethodGet, "/users", []string{"POST"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			handler := api.NewHandler(testutils.NewStubAppStore())
+
+			response := testutils.MakeRequest(t, handler, tc.method, tc.path, nil)
+			testutils.AssertStatus(t, response.Code, http.StatusMethodNotAllowed)
+			testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+
+			allow := strings.Split(response.Header().Get("Allow"), ", ")
+			slices.Sort(allow)
+			if !slices.Equal(allow, tc.allow) {
+				t.Errorf("got Allow %q, want %q", allow, tc.allow)
+			}
+		})
+	}
+}
+
 func TestInternalServerErrors(t *testing.T) {
 	t.Run("returns 500 when GetItems fails", func(t *testing.T) {
 		// Create base store and error wrapper
 		baseStore := testutils.NewStubAppStore()
 
 		errorStore := &testutils.ErrorStore{
-			AppStore:              baseStore,
+			AppStore:    baseStore,
 			ShouldError: true,
 		}
 
@@ -64,7 +98,7 @@ func TestInternalServerErrors(t *testing.T) {
 		baseStore := testutils.NewStubAppStore()
 
 		errorStore := &testutils.ErrorStore{
-			AppStore:              baseStore,
+			AppStore:    baseStore,
 			ShouldError: true,
 		}
 
@@ -81,12 +115,12 @@ func TestInternalServerErrors(t *testing.T) {
 		testutils.AssertStatus(t, response.Code, http.StatusInternalServerError)
 	})
 
-		t.Run("returns 500 when CreateUser fails", func(t *testing.T) {
+	t.Run("returns 500 when CreateUser fails", func(t *testing.T) {
 		// Create base store and error wrapper
 		baseStore := testutils.NewStubAppStore()
 
 		errorStore := &testutils.ErrorStore{
-			AppStore:              baseStore,
+			AppStore:    baseStore,
 			ShouldError: true,
 		}
 
@@ -104,6 +138,66 @@ func TestInternalServerErrors(t *testing.T) {
 	})
 }
 
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           []byte
+		expectedStatus int
+		invalidParams  []string
+	}{
+		{"bad JSON", http.MethodPost, "/items", []byte(`{"name": `), http.StatusBadRequest, nil},
+		{"missing body", http.MethodPost, "/users", nil, http.StatusBadRequest, nil},
+		{"missing item name", http.MethodPost, "/items", []byte(`{"name": ""}`), http.StatusBadRequest, []string{"name"}},
+		{"missing user name", http.MethodPost, "/users", []byte(`{}`), http.StatusBadRequest, []string{"name"}},
+		{"unknown item", http.MethodGet, "/items/does-not-exist", nil, http.StatusNotFound, nil},
+		{"unknown path", http.MethodGet, "/unknown", nil, http.StatusNotFound, nil},
+		{"wrong method", http.MethodPut, "/items", nil, http.StatusMethodNotAllowed, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := api.NewHandler(testutils.NewStubAppStoreWithData())
+
+			response := testutils.MakeRequest(t, handler, tc.method, tc.path, tc.body)
+			testutils.AssertStatus(t, response.Code, tc.expectedStatus)
+			testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+
+			var problem api.Problem
+			err := json.NewDecoder(response.Body).Decode(&problem)
+			testutils.AssertValidJSON(t, response.Body, err)
+
+			if problem.Status != tc.expectedStatus {
+				t.Errorf("problem status is %d, want %d", problem.Status, tc.expectedStatus)
+			}
+			if problem.Title != http.StatusText(tc.expectedStatus) {
+				t.Errorf("problem title is %q, want %q", problem.Title, http.StatusText(tc.expectedStatus))
+			}
+
+			var fields []string
+			for _, f := range problem.InvalidParams {
+				fields = append(fields, f.Field)
+			}
+			if !slices.Equal(fields, tc.invalidParams) {
+				t.Errorf("got invalid params %v, want %v", fields, tc.invalidParams)
+			}
+		})
+	}
+
+	t.Run("store failures other than not found are 500s", func(t *testing.T) {
+		errorStore := &testutils.ErrorStore{
+			AppStore:    testutils.NewStubAppStoreWithData(),
+			ShouldError: true,
+		}
+		handler := api.NewHandler(errorStore)
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusInternalServerError)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+	})
+}
+
 func TestHandlerContentType(t *testing.T) {
 	t.Run("returns JSON content type", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
@@ -119,7 +213,7 @@ func TestHandlerContentType(t *testing.T) {
 	})
 }
 
-func TestCreateItem(t *testing.T){
+func TestCreateItem(t *testing.T) {
 	t.Run("create item", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
 		handler := api.NewHandler(store)
@@ -137,7 +231,7 @@ func TestCreateItem(t *testing.T){
 		testutils.AssertStatus(t, createResponse.Code, http.StatusCreated)
 
 		location := createResponse.Header().Get("Location")
-		if location == ""{
+		if location == "" {
 			t.Fatalf("expected Location header to be set, but it was empty")
 		}
 
@@ -222,6 +316,88 @@ func TestGetItems(t *testing.T) {
 	})
 }
 
+func TestListItemsQuery(t *testing.T) {
+	newHandler := func() *api.Handler {
+		store := testutils.NewStubAppStore()
+		for _, name := range []string{"d", "b", "e", "a", "c"} {
+			store.CreateItem(models.CreateItemInput{Name: name, OrgID: "org-123"})
+		}
+		store.CreateItem(models.CreateItemInput{Name: "other org", OrgID: "org-999"})
+		return api.NewHandler(store)
+	}
+
+	t.Run("follows Link headers through every page", func(t *testing.T) {
+		handler := newHandler()
+
+		var names []string
+		url := "/items?org_id=org-123&sort=name&limit=2"
+		for pages := 0; url != ""; pages++ {
+			if pages > 3 {
+				t.Fatal("pagination did not terminate")
+			}
+			response := testutils.MakeRequest(t, handler, http.MethodGet, url, nil)
+			testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+			var items []models.Item
+			json.NewDecoder(response.Body).Decode(&items)
+			for _, item := range items {
+				names = append(names, item.Name)
+			}
+
+			url = ""
+			if link := response.Header().Get("Link"); link != "" {
+				target, _, _ := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+				url = target
+			}
+		}
+
+		want := []string{"a", "b", "c", "d", "e"}
+		if !slices.Equal(names, want) {
+			t.Errorf("got %v, want %v", names, want)
+		}
+	})
+
+	t.Run("last page has no Link header", func(t *testing.T) {
+		handler := newHandler()
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items?limit=10", nil)
+		if link := response.Header().Get("Link"); link != "" {
+			t.Errorf("expected no Link header, got %q", link)
+		}
+	})
+
+	t.Run("rejects invalid parameters", func(t *testing.T) {
+		handler := newHandler()
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items?limit=0&sort=colour&is_active=maybe", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+
+		var fields []string
+		for _, f := range problem.InvalidParams {
+			fields = append(fields, f.Field)
+		}
+		want := []string{"is_active", "sort", "limit"}
+		if !slices.Equal(fields, want) {
+			t.Errorf("got invalid params %v, want %v", fields, want)
+		}
+	})
+
+	t.Run("rejects a cursor issued for another sort", func(t *testing.T) {
+		handler := newHandler()
+
+		first := testutils.MakeRequest(t, handler, http.MethodGet, "/items?sort=name&limit=1", nil)
+		link := first.Header().Get("Link")
+		target, _, _ := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+		reused := strings.Replace(target, "sort=name", "sort=-name", 1)
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, reused, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+}
+
 func TestUpdateItem(t *testing.T) {
 	t.Run("updates item fields", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
@@ -236,7 +412,7 @@ func TestUpdateItem(t *testing.T) {
 
 		// Update the item
 		updateData := map[string]string{
-			"Name": "Updated Name",
+			"name": "Updated Name",
 		}
 		updateJSON, _ := json.Marshal(updateData)
 
@@ -257,6 +433,95 @@ func TestUpdateItem(t *testing.T) {
 		}
 	})
 
+	t.Run("null clears a field", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		patch := []byte(`{"external_id": null}`)
+		response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", patch)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var updatedItem models.Item
+		json.NewDecoder(response.Body).Decode(&updatedItem)
+
+		if updatedItem.ExternalID != "" {
+			t.Errorf("ExternalID not cleared, got %q", updatedItem.ExternalID)
+		}
+		if updatedItem.Name != "First Test Item" {
+			t.Errorf("Name changed unexpectedly, got %q", updatedItem.Name)
+		}
+	})
+
+	t.Run("returns 400 listing rejected fields", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		patch := []byte(`{"Name": "Go casing", "id": "new-id", "created_at": null, "org_id": 7, "name": "ok"}`)
+		response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", patch)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+
+		var got []string
+		for _, f := range problem.InvalidParams {
+			got = append(got, f.Field)
+		}
+		want := []string{"Name", "created_at", "id", "org_id"}
+		if !slices.Equal(got, want) {
+			t.Errorf("got rejected fields %v, want %v", got, want)
+		}
+
+		item, _ := store.GetItem("item-001")
+		if item.Name != "First Test Item" {
+			t.Errorf("item was changed by a rejected patch, name is %q", item.Name)
+		}
+	})
+
+	t.Run("accepts is_active as a boolean or a legacy string", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		for _, patch := range []string{`{"is_active": false}`, `{"is_active": "false"}`} {
+			store.Items[0].IsActive = true
+
+			response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(patch))
+			testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+			var updatedItem models.Item
+			json.NewDecoder(response.Body).Decode(&updatedItem)
+			if updatedItem.IsActive {
+				t.Errorf("%s did not deactivate the item", patch)
+			}
+		}
+	})
+
+	t.Run("returns 400 for an empty name", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(`{"name": null}`))
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 400 for a patch that is not an object", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		response := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(`["name"]`))
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
 	t.Run("returns 400 for invalid JSON", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
 		store.Items = testutils.CreateTestItems()
@@ -276,7 +541,7 @@ func TestUpdateItem(t *testing.T) {
 		handler := api.NewHandler(store)
 
 		updateData := map[string]string{
-			"Name": "Updated Name",
+			"name": "Updated Name",
 		}
 		updateJSON, _ := json.Marshal(updateData)
 
@@ -310,10 +575,60 @@ func TestDeleteItem(t *testing.T) {
 		response := testutils.MakeRequest(t, handler, http.MethodDelete, "/items/does-not-exist", nil)
 		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
 	})
+
+	t.Run("soft-deleted items can still be read and restored", func(t *testing.T) {
+		store := testutils.NewStubAppStore()
+		store.Items = testutils.CreateTestItems()
+
+		handler := api.NewHandler(store)
+
+		deleteResponse := testutils.MakeRequest(t, handler, http.MethodDelete, "/items/item-001", nil)
+		testutils.AssertStatus(t, deleteResponse.Code, http.StatusNoContent)
+
+		listResponse := testutils.MakeRequest(t, handler, http.MethodGet, "/items", nil)
+		var listed []models.Item
+		json.NewDecoder(listResponse.Body).Decode(&listed)
+		testutils.AssertContainsIDs(t, listed, "item-002")
+
+		listResponse = testutils.MakeRequest(t, handler, http.MethodGet, "/items?include_deleted=true", nil)
+		listed = nil
+		json.NewDecoder(listResponse.Body).Decode(&listed)
+		testutils.AssertContainsIDs(t, listed, "item-001", "item-002")
+
+		getResponse := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001?include_deleted=true", nil)
+		testutils.AssertStatus(t, getResponse.Code, http.StatusOK)
+		var deleted models.Item
+		json.NewDecoder(getResponse.Body).Decode(&deleted)
+		if deleted.DeletedAt == nil {
+			t.Error("expected deleted_at to be set")
+		}
+
+		patchResponse := testutils.MakeRequest(t, handler, http.MethodPatch, "/items/item-001", []byte(`{"name": "x"}`))
+		testutils.AssertStatus(t, patchResponse.Code, http.StatusNotFound)
+
+		secondDelete := testutils.MakeRequest(t, handler, http.MethodDelete, "/items/item-001", nil)
+		testutils.AssertStatus(t, secondDelete.Code, http.StatusNotFound)
+
+		restoreResponse := testutils.MakeRequest(t, handler, http.MethodPost, "/items/item-001/restore", nil)
+		testutils.AssertStatus(t, restoreResponse.Code, http.StatusOK)
+
+		secondRestore := testutils.MakeRequest(t, handler, http.MethodPost, "/items/item-001/restore", nil)
+		testutils.AssertStatus(t, secondRestore.Code, http.StatusConflict)
+
+		getResponse = testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001", nil)
+		testutils.AssertStatus(t, getResponse.Code, http.StatusOK)
+	})
+
+	t.Run("restore only accepts POST", func(t *testing.T) {
+		handler := api.NewHandler(testutils.NewStubAppStoreWithData())
+
+		response := testutils.MakeRequest(t, handler, http.MethodGet, "/items/item-001/restore", nil)
+		testutils.AssertStatus(t, response.Code, http.StatusMethodNotAllowed)
+	})
 }
 
-func TestGetSession(t *testing.T){
-	t.Run("returns item by id", func (t *testing.T)  {
+func TestGetSession(t *testing.T) {
+	t.Run("returns item by id", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
 		store.Sessions = testutils.CreateTestSessions()
 
@@ -339,19 +654,30 @@ func TestGetSession(t *testing.T){
 	})
 }
 
+// makeRequestAs makes a request with user in its context, as RequireSession
+// would have put it there
+func makeRequestAs(t testing.TB, handler http.Handler, user models.User, method, url string) *httptest.ResponseRecorder {
+	t.Helper()
 
-func TestUsersHandler(t *testing.T){
+	req := httptest.NewRequest(method, url, nil)
+	req = req.WithContext(api.WithUser(req.Context(), user))
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func TestUsersHandler(t *testing.T) {
 	t.Run("GET /users/{id}", func(t *testing.T) {
 		store := testutils.NewStubAppStoreWithData()
 		handler := api.NewHandler(store)
 
-		response := testutils.MakeRequest(t, handler, http.MethodGet, "/users/user-001", nil)
+		response := makeRequestAs(t, handler, store.Users[0], http.MethodGet, "/users/user-001")
 
 		testutils.AssertStatus(t, response.Code, http.StatusOK)
 
 		var receivedUser models.User
 		json.NewDecoder(response.Body).Decode(&receivedUser)
-	
+
 		testutils.AssertContainsID(t, receivedUser, "user-001")
 	})
 
@@ -359,11 +685,11 @@ func TestUsersHandler(t *testing.T){
 		store := testutils.NewStubAppStoreWithData()
 		handler := api.NewHandler(store)
 
-		response := testutils.MakeRequest(t, handler, http.MethodGet, "/users/does-not-exist", nil)
+		response := makeRequestAs(t, handler, store.Users[0], http.MethodGet, "/users/does-not-exist")
 		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
 	})
 
-	t.Run("POST /users", func (t *testing.T)  {
+	t.Run("POST /users", func(t *testing.T) {
 		store := testutils.NewStubAppStore()
 		handler := api.NewHandler(store)
 
@@ -375,20 +701,20 @@ func TestUsersHandler(t *testing.T){
 		}
 
 		createResponse := testutils.MakeRequest(t, handler, http.MethodPost, "/users", body)
-		
+
 		var createdUser models.User
 		json.NewDecoder(createResponse.Body).Decode(&createdUser)
 
-		if createUserData.Name != newUserName{
+		if createUserData.Name != newUserName {
 			t.Errorf("got %q, want %q", createUserData.Name, newUserName)
 		}
 
 		location := createResponse.Header().Get("Location")
-		if location == ""{
+		if location == "" {
 			t.Fatalf("expected Location header to be set, but it was empty")
 		}
 
-		getResponse := testutils.MakeRequest(t, handler, http.MethodGet, fmt.Sprintf("/users/%s", createdUser.ID), nil)
+		getResponse := makeRequestAs(t, handler, createdUser, http.MethodGet, fmt.Sprintf("/users/%s", createdUser.ID))
 
 		testutils.AssertStatus(t, getResponse.Code, http.StatusOK)
 	})
@@ -421,4 +747,4 @@ func TestUsersHandler(t *testing.T){
 
 		testutils.AssertStatus(t, createResponse.Code, http.StatusBadRequest)
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+)
+
+// WebhookEvents are the event types webhooks can subscribe to. Users can
+// only be created through the API, so they have no update or delete events.
+var WebhookEvents = []string{
+	"item.created", "item.updated", "item.deleted",
+	"user.created",
+}
+
+// pastTense names the event type for each audited action
+var pastTense = map[audit.Action]string{
+	audit.ActionCreate: "created",
+	audit.ActionUpdate: "updated",
+	audit.ActionDelete: "deleted",
+}
+
+// publish sends an audit event of an item or user to the webhooks
+// subscribed to it. The delivery carries the audit event as its data.
+func (h *Handler) publish(event audit.Event) {
+	if event.ResourceType != ResourceItem && event.ResourceType != ResourceUser {
+		return
+	}
+
+	err := h.webhooks.Publish(webhook.Event{
+		Type:  event.ResourceType + "." + pastTense[event.Action],
+		OrgID: event.OrgID,
+		Time:  event.Time,
+		Data:  event,
+	})
+	if err != nil {
+		log.Printf("could not publish %s %s to webhooks: %v", event.ResourceType, event.ResourceID, err)
+	}
+}
+
+// orgScope returns the org whose webhooks, audit events or item changes
+// the request is about. System admins who have not selected an org get the
+// global scope, "", which covers every org; anyone else, including a caller
+// with no user at all, gets errNoOrg.
+func orgScope(r *http.Request) (string, error) {
+	if orgID, ok := OrgFromContext(r.Context()); ok {
+		return orgID, nil
+	}
+	if user, ok := UserFromContext(r.Context()); !ok || !user.IsAdmin {
+		return "", errNoOrg
+	}
+	return "", nil
+}
+
+// createWebhook subscribes a URL to the events of the caller's org
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	orgID, err := orgScope(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	subscription, err := h.webhooks.Subscribe(orgID, req.URL, req.Events)
+	if errors.Is(err, webhook.ErrPrivateAddress) {
+		respondWithError(w, &ValidationError{Fields: []RejectedField{{Field: "url", Reason: "must not point at a private or loopback address"}}})
+		return
+	}
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	h.record(h.auditEvent(r, audit.ActionCreate, ResourceWebhook, subscription.ID, orgID, nil, subscription))
+	w.Header().Set("Location", fmt.Sprintf("/webhooks/%s", subscription.ID))
+	respondWithJSON(w, http.StatusCreated, CreateWebhookResponse{Secret: subscription.Secret, Subscription: subscription})
+}
+
+func (h *Handler) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	orgID, err := orgScope(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h.webhooks.Subscriptions(orgID))
+}
+
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	orgID, err := orgScope(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	subscription, err := h.webhooks.Unsubscribe(orgID, r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	h.record(h.auditEvent(r, audit.ActionDelete, ResourceWebhook, subscription.ID, orgID, subscription, nil))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeadLetters lists the deliveries to the org's webhooks that failed
+// every attempt
+func (h *Handler) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	orgID, err := orgScope(r)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, h.webhooks.DeadLetters(orgID))
+}
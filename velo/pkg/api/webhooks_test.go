@@ -0,0 +1,208 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+// webhookReceiver collects the deliveries it is sent, answering each with
+// status
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	r := &webhookReceiver{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newTestDispatcher(t *testing.T) *webhook.Dispatcher {
+	d := webhook.NewDispatcher()
+	// The receivers listen on loopback
+	d.AllowPrivateAddresses = true
+	d.BaseDelay = time.Millisecond
+	d.MaxAttempts = 2
+	t.Cleanup(d.Close)
+	return d
+}
+
+func createWebhook(t testing.TB, server http.Handler, token string, req api.CreateWebhookRequest) api.CreateWebhookResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	response := makeRequestWithToken(t, server, http.MethodPost, "/webhooks", token, body)
+	testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+	var created api.CreateWebhookResponse
+	json.NewDecoder(response.Body).Decode(&created)
+	return created
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Run("delivers signed item events to the org's webhooks", func(t *testing.T) {
+		dispatcher := newTestDispatcher(t)
+		server, _ := newTenantServer(t, api.WithWebhooks(dispatcher))
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+		globex := login(t, server, "globex-user", "hunter2hunter2")
+
+		acmeReceiver, globexReceiver := newWebhookReceiver(t, http.StatusOK), newWebhookReceiver(t, http.StatusOK)
+		created := createWebhook(t, server, acme.Token, api.CreateWebhookRequest{URL: acmeReceiver.URL})
+		createWebhook(t, server, globex.Token, api.CreateWebhookRequest{URL: globexReceiver.URL})
+		if created.Secret == "" {
+			t.Fatal("expected the webhook's secret in the response")
+		}
+
+		body, _ := json.Marshal(api.CreateItemRequest{Name: "bike"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/items", acme.Token, body)
+		var item models.Item
+		json.NewDecoder(response.Body).Decode(&item)
+		dispatcher.Wait()
+
+		if len(globexReceiver.deliveries) != 0 {
+			t.Errorf("got %d deliveries to another org, want none", len(globexReceiver.deliveries))
+		}
+		if len(acmeReceiver.deliveries) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(acmeReceiver.deliveries))
+		}
+		if !webhook.Verify(created.Secret, acmeReceiver.deliveries[0].Header, acmeReceiver.bodies[0]) {
+			t.Error("delivery is not signed with the webhook's secret")
+		}
+
+		var event struct {
+			webhook.Event
+			Data audit.Event `json:"data"`
+		}
+		json.Unmarshal(acmeReceiver.bodies[0], &event)
+		if event.Type != "item.created" || event.OrgID != item.OrgID || event.Data.ResourceID != item.ID {
+			t.Errorf("got event %+v, want item.created for %s", event, item.ID)
+		}
+	})
+
+	t.Run("sends user events to global webhooks", func(t *testing.T) {
+		dispatcher := newTestDispatcher(t)
+		server, _ := newTenantServer(t, api.WithWebhooks(dispatcher))
+		admin := login(t, server, "admin", "hunter2hunter2")
+		receiver := newWebhookReceiver(t, http.StatusOK)
+		createWebhook(t, server, admin.Token, api.CreateWebhookRequest{URL: receiver.URL, Events: []string{"user.created"}})
+
+		body, _ := json.Marshal(api.CreateUserRequest{Name: "new", Password: "hunter2hunter2"})
+		testutils.MakeRequest(t, server, http.MethodPost, "/users", body)
+		dispatcher.Wait()
+
+		if len(receiver.deliveries) != 1 || receiver.deliveries[0].Header.Get(webhook.EventHeader) != "user.created" {
+			t.Errorf("got %d deliveries, want one user.created", len(receiver.deliveries))
+		}
+	})
+
+	t.Run("lists failed deliveries as dead letters", func(t *testing.T) {
+		dispatcher := newTestDispatcher(t)
+		server, store := newTenantServer(t, api.WithWebhooks(dispatcher))
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+		receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+		createWebhook(t, server, acme.Token, api.CreateWebhookRequest{URL: receiver.URL})
+
+		makeRequestWithToken(t, server, http.MethodDelete, "/items/"+store.Items[0].ID, acme.Token, nil)
+		dispatcher.Wait()
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/webhooks/dead-letters", acme.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		var letters []webhook.DeadLetter
+		json.NewDecoder(response.Body).Decode(&letters)
+		if len(letters) != 1 || letters[0].Attempts != 2 || letters[0].Event.Type != "item.deleted" {
+			t.Errorf("got dead letters %+v, want one item.deleted after 2 attempts", letters)
+		}
+	})
+
+	t.Run("keeps each org's webhooks to itself", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+		globex := login(t, server, "globex-user", "hunter2hunter2")
+		created := createWebhook(t, server, acme.Token, api.CreateWebhookRequest{URL: "https://example.com/hook"})
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/webhooks", globex.Token, nil)
+		var subscriptions []webhook.Subscription
+		json.NewDecoder(response.Body).Decode(&subscriptions)
+		if len(subscriptions) != 0 {
+			t.Errorf("got %d webhooks, want none from another org", len(subscriptions))
+		}
+
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/webhooks/"+created.Subscription.ID, globex.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+
+		response = makeRequestWithToken(t, server, http.MethodDelete, "/webhooks/"+created.Subscription.ID, acme.Token, nil)
+		testutils.AssertStatus(t, response.Code, http.StatusNoContent)
+	})
+
+	t.Run("never lists the secret", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+		createWebhook(t, server, acme.Token, api.CreateWebhookRequest{URL: "https://example.com/hook"})
+
+		response := makeRequestWithToken(t, server, http.MethodGet, "/webhooks", acme.Token, nil)
+		var raw []map[string]any
+		json.NewDecoder(response.Body).Decode(&raw)
+		if _, ok := raw[0]["secret"]; ok {
+			t.Error("unexpected secret in webhook list")
+		}
+	})
+
+	t.Run("rejects webhooks to private addresses", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateWebhookRequest{URL: "http://169.254.169.254/latest/meta-data"})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/webhooks", acme.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("rejects invalid webhooks", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		acme := login(t, server, "acme-user", "hunter2hunter2")
+
+		body, _ := json.Marshal(api.CreateWebhookRequest{URL: "ftp://example.com", Events: []string{"item.exploded"}})
+		response := makeRequestWithToken(t, server, http.MethodPost, "/webhooks", acme.Token, body)
+		testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+
+		var problem api.Problem
+		json.NewDecoder(response.Body).Decode(&problem)
+		if len(problem.InvalidParams) != 2 {
+			t.Errorf("got rejected fields %+v, want url and events[0]", problem.InvalidParams)
+		}
+	})
+
+	t.Run("rejects user events that are never sent", func(t *testing.T) {
+		server, _ := newTenantServer(t)
+		admin := login(t, server, "admin", "hunter2hunter2")
+
+		for _, event := range []string{"user.updated", "user.deleted"} {
+			body, _ := json.Marshal(api.CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{event}})
+			response := makeRequestWithToken(t, server, http.MethodPost, "/webhooks", admin.Token, body)
+			testutils.AssertStatus(t, response.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+// Package audit records who changed what and when. Events are written to a
+// Sink, which keeps them for later queries.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Action is the kind of change an event records
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Event is one change to one resource. ActorID is empty for changes made
+// without a session, such as signing up.
+type Event struct {
+	Time         time.Time         `json:"time"`
+	ActorID      string            `json:"actor_id,omitempty"`
+	OrgID        string            `json:"org_id,omitempty"`
+	Action       Action            `json:"action"`
+	ResourceType string            `json:"resource_type"`
+	ResourceID   string            `json:"resource_id"`
+	Changes      map[string]Change `json:"changes,omitempty"`
+}
+
+// Change is the value of one field before and after an event. A field that
+// did not exist on one side is null there.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Diff compares the JSON encodings of two versions of a resource, returning
+// the fields whose values differ. before is nil for a create and after is
+// nil for a hard delete, so every field is reported.
+func Diff(before, after any) (map[string]Change, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for name, value := range old {
+		if !bytes.Equal(value, updated[name]) {
+			changes[name] = Change{Before: decode(value), After: decode(updated[name])}
+		}
+	}
+	for name, value := range updated {
+		if _, ok := old[name]; !ok {
+			changes[name] = Change{After: decode(value)}
+		}
+	}
+	return changes, nil
+}
+
+// fields encodes v as JSON and splits the object into its fields
+func fields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func decode(raw json.RawMessage) any {
+	if raw == nil {
+		return nil
+	}
+	var value any
+	json.Unmarshal(raw, &value)
+	return value
+}
+
+// Filter selects events. Empty fields match every event; Since is inclusive
+// and Until exclusive.
+type Filter struct {
+	ResourceType string
+	ResourceID   string
+	OrgID        string
+	ActorID      string
+	Since        time.Time
+	Until        time.Time
+
+	// After skips the events up to this position in the log, such as the
+	// Next of a previous page. Positions count every event from 1.
+	After int
+	// Limit is the most events a page holds; 0 means no limit
+	Limit int
+}
+
+// Matches reports whether the event passes the filter
+func (f Filter) Matches(e Event) bool {
+	switch {
+	case f.ResourceType != "" && e.ResourceType != f.ResourceType,
+		f.ResourceID != "" && e.ResourceID != f.ResourceID,
+		f.OrgID != "" && e.OrgID != f.OrgID,
+		f.ActorID != "" && e.ActorID != f.ActorID,
+		!f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && !e.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// Page is the result of a query
+type Page struct {
+	Events []Event
+	// Next is the position of the last event, to pass as Filter.After for
+	// the following page. It is 0 on the last page.
+	Next int
+}
+
+// pager collects the events matching a filter into a page
+type pager struct {
+	filter Filter
+	page   Page
+	last   int
+}
+
+// add offers the event at position to the page, reporting whether the
+// page has room for more
+func (p *pager) add(position int, e Event) bool {
+	if position <= p.filter.After || !p.filter.Matches(e) {
+		return true
+	}
+	if p.filter.Limit > 0 && len(p.page.Events) == p.filter.Limit {
+		p.page.Next = p.last
+		return false
+	}
+	p.page.Events = append(p.page.Events, e)
+	p.last = position
+	return true
+}
+
+// Sink stores events. Query returns a page of the events matching a
+// filter, in the order they were recorded.
+type Sink interface {
+	Record(event Event) error
+	Query(filter Filter) (Page, error)
+}
@@ -0,0 +1,239 @@
+package audit_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/audit"
+)
+
+func TestDiff(t *testing.T) {
+	type item struct {
+		Name     string `json:"name"`
+		IsActive bool   `json:"is_active"`
+	}
+
+	t.Run("reports only the fields that changed", func(t *testing.T) {
+		changes, err := audit.Diff(item{Name: "bike", IsActive: true}, item{Name: "bike", IsActive: false})
+		if err != nil {
+			t.Fatalf("Diff returned error: %v", err)
+		}
+		if len(changes) != 1 {
+			t.Fatalf("got changes %v, want only is_active", changes)
+		}
+		if got := changes["is_active"]; got.Before != true || got.After != false {
+			t.Errorf("got is_active change %+v, want true to false", got)
+		}
+	})
+
+	t.Run("reports every field of a created resource", func(t *testing.T) {
+		changes, _ := audit.Diff(nil, item{Name: "bike"})
+		if got := changes["name"]; got.Before != nil || got.After != "bike" {
+			t.Errorf("got name change %+v, want null to bike", got)
+		}
+		if _, ok := changes["is_active"]; !ok {
+			t.Error("want is_active to be reported")
+		}
+	})
+
+	t.Run("reports every field of a deleted resource", func(t *testing.T) {
+		changes, _ := audit.Diff(item{Name: "bike"}, nil)
+		if got := changes["name"]; got.Before != "bike" || got.After != nil {
+			t.Errorf("got name change %+v, want bike to null", got)
+		}
+	})
+}
+
+func TestSinks(t *testing.T) {
+	sinks := map[string]func(t *testing.T) audit.Sink{
+		"memory": func(t *testing.T) audit.Sink {
+			return audit.NewMemorySink(100)
+		},
+		"file": func(t *testing.T) audit.Sink {
+			sink, err := audit.OpenFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+			if err != nil {
+				t.Fatalf("could not open file sink: %v", err)
+			}
+			t.Cleanup(func() { sink.Close() })
+			return sink
+		},
+	}
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []audit.Event{
+		{Time: start, ActorID: "u1", OrgID: "o1", Action: audit.ActionCreate, ResourceType: "item", ResourceID: "i1",
+			Changes: map[string]audit.Change{"name": {After: "bike"}}},
+		{Time: start.Add(time.Hour), ActorID: "u2", OrgID: "o1", Action: audit.ActionUpdate, ResourceType: "item", ResourceID: "i1",
+			Changes: map[string]audit.Change{"is_active": {Before: true, After: false}}},
+		{Time: start.Add(2 * time.Hour), ActorID: "u1", OrgID: "o2", Action: audit.ActionCreate, ResourceType: "item", ResourceID: "i2"},
+		{Time: start.Add(3 * time.Hour), Action: audit.ActionCreate, ResourceType: "user", ResourceID: "u3"},
+	}
+
+	for name, newSink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			sink := newSink(t)
+			for _, e := range events {
+				if err := sink.Record(e); err != nil {
+					t.Fatalf("Record returned error: %v", err)
+				}
+			}
+
+			cases := []struct {
+				name   string
+				filter audit.Filter
+				want   []int
+			}{
+				{"everything", audit.Filter{}, []int{0, 1, 2, 3}},
+				{"by resource", audit.Filter{ResourceType: "item", ResourceID: "i1"}, []int{0, 1}},
+				{"by resource type", audit.Filter{ResourceType: "user"}, []int{3}},
+				{"by org", audit.Filter{OrgID: "o1"}, []int{0, 1}},
+				{"by actor", audit.Filter{ActorID: "u1"}, []int{0, 2}},
+				{"by time range", audit.Filter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []int{1, 2}},
+			}
+			for _, c := range cases {
+				page, err := sink.Query(c.filter)
+				got := page.Events
+				if err != nil {
+					t.Fatalf("%s: Query returned error: %v", c.name, err)
+				}
+				if len(got) != len(c.want) {
+					t.Errorf("%s: got %d events, want %d", c.name, len(got), len(c.want))
+					continue
+				}
+				for i, index := range c.want {
+					want := events[index]
+					if got[i].ResourceID != want.ResourceID || got[i].Action != want.Action || !got[i].Time.Equal(want.Time) {
+						t.Errorf("%s: got event %+v, want %+v", c.name, got[i], want)
+					}
+				}
+			}
+
+			got, _ := sink.Query(audit.Filter{ResourceType: "item", ResourceID: "i1", ActorID: "u2"})
+			if change := got.Events[0].Changes["is_active"]; change.Before != true || change.After != false {
+				t.Errorf("got change %+v, want is_active true to false", change)
+			}
+
+			var pages [][]string
+			for filter := (audit.Filter{ResourceType: "item", Limit: 2}); ; {
+				page, err := sink.Query(filter)
+				if err != nil {
+					t.Fatalf("Query returned error: %v", err)
+				}
+				var ids []string
+				for _, e := range page.Events {
+					ids = append(ids, e.ResourceID)
+				}
+				pages = append(pages, ids)
+				if page.Next == 0 {
+					break
+				}
+				filter.After = page.Next
+			}
+			if want := [][]string{{"i1", "i1"}, {"i2"}}; !reflect.DeepEqual(pages, want) {
+				t.Errorf("got pages %v, want %v", pages, want)
+			}
+		})
+	}
+}
+
+func TestFileSinkSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	first, _ := audit.OpenFileSink(path)
+	first.Record(audit.Event{Time: time.Now(), Action: audit.ActionCreate, ResourceType: "item", ResourceID: "i1"})
+	first.Close()
+
+	reopened, err := audit.OpenFileSink(path)
+	if err != nil {
+		t.Fatalf("could not reopen file sink: %v", err)
+	}
+	defer reopened.Close()
+	reopened.Record(audit.Event{Time: time.Now(), Action: audit.ActionDelete, ResourceType: "item", ResourceID: "i1"})
+
+	page, err := reopened.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+	if len(page.Events) != 2 {
+		t.Errorf("got %d events, want both the old and the new one", len(page.Events))
+	}
+}
+
+func TestFileSinkSkipsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	first, _ := audit.OpenFileSink(path)
+	first.Record(audit.Event{Time: time.Now(), Action: audit.ActionCreate, ResourceType: "item", ResourceID: "i1"})
+	first.Close()
+
+	// A crash tears the last line in half
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	file.WriteString(`{"time": "2025-01-01T12:00:00Z", "acti`)
+	file.Close()
+
+	reopened, err := audit.OpenFileSink(path)
+	if err != nil {
+		t.Fatalf("could not reopen file sink: %v", err)
+	}
+	defer reopened.Close()
+	reopened.Record(audit.Event{Time: time.Now(), Action: audit.ActionDelete, ResourceType: "item", ResourceID: "i1"})
+
+	page, err := reopened.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[1].Action != audit.ActionDelete {
+		t.Errorf("got events %+v, want the two whole ones", page.Events)
+	}
+}
+
+func TestMemorySinkDropsOldest(t *testing.T) {
+	sink := audit.NewMemorySink(2)
+	for _, id := range []string{"i1", "i2", "i3"} {
+		sink.Record(audit.Event{Time: time.Now(), Action: audit.ActionCreate, ResourceType: "item", ResourceID: id})
+	}
+
+	page, _ := sink.Query(audit.Filter{Limit: 1})
+	if len(page.Events) != 1 || page.Events[0].ResourceID != "i2" || page.Next != 2 {
+		t.Fatalf("got page %+v, want i2 at position 2", page)
+	}
+	page, _ = sink.Query(audit.Filter{After: page.Next})
+	if len(page.Events) != 1 || page.Events[0].ResourceID != "i3" {
+		t.Errorf("got page %+v, want i3", page)
+	}
+}
+
+func TestFileSinkSeeksToCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	record := func(sink *audit.FileSink, from, to int) {
+		for i := from; i <= to; i++ {
+			sink.Record(audit.Event{Time: time.Now(), Action: audit.ActionCreate, ResourceType: "item", ResourceID: fmt.Sprint("i", i)})
+		}
+	}
+
+	first, _ := audit.OpenFileSink(path)
+	record(first, 1, 1500)
+	first.Close()
+
+	// Reopening finds where the lines already written start
+	reopened, err := audit.OpenFileSink(path)
+	if err != nil {
+		t.Fatalf("could not reopen file sink: %v", err)
+	}
+	defer reopened.Close()
+	record(reopened, 1501, 2500)
+
+	for _, after := range []int{0, 999, 1000, 1001, 2100} {
+		page, err := reopened.Query(audit.Filter{After: after, Limit: 1})
+		if err != nil {
+			t.Fatalf("Query returned error: %v", err)
+		}
+		if want := fmt.Sprint("i", after+1); len(page.Events) != 1 || page.Events[0].ResourceID != want || page.Next != after+1 {
+			t.Errorf("after %d: got page %+v, want %s at position %d", after, page, want, after+1)
+		}
+	}
+}
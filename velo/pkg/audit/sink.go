@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+// MemorySink keeps the most recent events in memory, dropping the oldest
+// once it is full. It is safe for concurrent use.
+type MemorySink struct {
+	mu     sync.RWMutex
+	size   int
+	events []Event
+	// dropped counts the events that no longer fit, so that positions stay
+	// the same as events are dropped
+	dropped int
+}
+
+// NewMemorySink returns an empty sink that keeps the last size events
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{size: size}
+}
+
+func (s *MemorySink) Record(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	if excess := len(s.events) - s.size; excess > 0 {
+		s.events = slices.Delete(s.events, 0, excess)
+		s.dropped += excess
+	}
+	return nil
+}
+
+func (s *MemorySink) Query(filter Filter) (Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p := pager{filter: filter, page: Page{Events: []Event{}}}
+	for i := max(filter.After-s.dropped, 0); i < len(s.events); i++ {
+		if !p.add(s.dropped+i+1, s.events[i]) {
+			break
+		}
+	}
+	return p.page, nil
+}
+
+// FileSink appends events to a file as JSON lines, one event per line, so
+// that the log survives restarts and can be read by other tools. It is safe
+// for concurrent use within one process.
+type FileSink struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	size  int64
+	lines int
+	// marks holds the offset of every markEvery-th line, starting with the
+	// first, so that a query can start reading near its cursor
+	marks []int64
+}
+
+// markEvery is how many lines apart the offsets a FileSink keeps are
+const markEvery = 1000
+
+// OpenFileSink opens the log at path, creating it if needed. A last line
+// torn by a crash is ended, so that the next event starts a line of its own.
+func OpenFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := endLastLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &FileSink{path: path, file: file, marks: []int64{0}}
+	if err := s.index(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// index counts the lines already in the log, marking where they start
+func (s *FileSink) index() error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.file.ReadAt(buf, s.size)
+		for i, b := range buf[:n] {
+			if b == '\n' {
+				s.ended(s.size + int64(i) + 1)
+			}
+		}
+		s.size += int64(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ended counts a line that ends just before offset
+func (s *FileSink) ended(offset int64) {
+	s.lines++
+	if s.lines%markEvery == 0 {
+		s.marks = append(s.marks, offset)
+	}
+}
+
+// endLastLine appends a newline to a file that does not end with one
+func endLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil && err != io.EOF {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
+
+func (s *FileSink) Record(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	s.size += int64(n)
+	s.ended(s.size)
+	return nil
+}
+
+// Query reads the log from the marked line nearest before the cursor,
+// keeping only the matching events in memory. An event's position is its
+// line. Lines that are not events, such as one torn by a crash, are logged
+// and skipped. Events recorded while the query runs are left for the next.
+func (s *FileSink) Query(filter Filter) (Page, error) {
+	s.mu.Lock()
+	mark := min(max(filter.After, 0)/markEvery, len(s.marks)-1)
+	start, size := s.marks[mark], s.size
+	s.mu.Unlock()
+
+	p := pager{filter: filter, page: Page{Events: []Event{}}}
+	scanner := bufio.NewScanner(io.NewSectionReader(s.file, start, size-start))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := mark*markEvery + 1; scanner.Scan(); line++ {
+		if line <= filter.After {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("skipping malformed audit event at %s line %d: %v", s.path, line, err)
+			continue
+		}
+		if !p.add(line, e) {
+			break
+		}
+	}
+	return p.page, scanner.Err()
+}
+
+// Close closes the log file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
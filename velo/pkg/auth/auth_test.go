@@ -0,0 +1,58 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+)
+
+func TestPasswords(t *testing.T) {
+	auth.PasswordIterations = 1000
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	t.Run("accepts the right password", func(t *testing.T) {
+		ok, err := auth.CheckPassword(hash, "correct horse")
+		if err != nil || !ok {
+			t.Errorf("got %v, %v, want true", ok, err)
+		}
+	})
+
+	t.Run("rejects a wrong password", func(t *testing.T) {
+		ok, err := auth.CheckPassword(hash, "battery staple")
+		if err != nil || ok {
+			t.Errorf("got %v, %v, want false", ok, err)
+		}
+	})
+
+	t.Run("salts every hash", func(t *testing.T) {
+		other, _ := auth.HashPassword("correct horse")
+		if other == hash {
+			t.Error("expected two hashes of the same password to differ")
+		}
+	})
+
+	t.Run("rejects a malformed hash", func(t *testing.T) {
+		if _, err := auth.CheckPassword("plaintext", "plaintext"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestTokens(t *testing.T) {
+	first, _ := auth.NewToken()
+	second, _ := auth.NewToken()
+
+	if first == second {
+		t.Error("expected tokens to be unique")
+	}
+	if auth.HashToken(first) != auth.HashToken(first) {
+		t.Error("expected hashing to be deterministic")
+	}
+	if auth.HashToken(first) == first {
+		t.Error("expected the hash to differ from the token")
+	}
+}
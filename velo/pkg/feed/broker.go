@@ -0,0 +1,137 @@
+// Package feed publishes item changes to subscribers in the same process.
+// Recent changes are kept in a bounded buffer so that a subscriber that
+// reconnects can resume where it left off.
+package feed
+
+import (
+	"sync"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Change types
+const (
+	ItemCreated = "item.created"
+	ItemUpdated = "item.updated"
+	ItemDeleted = "item.deleted"
+)
+
+// Change is one item change. IDs increase by one with each change published
+// by a broker, starting from 1.
+type Change struct {
+	ID    uint64      `json:"id"`
+	Type  string      `json:"type"`
+	OrgID string      `json:"org_id,omitempty"`
+	Time  time.Time   `json:"time"`
+	Item  models.Item `json:"item"`
+}
+
+// subscriberBuffer is how many changes a subscriber may fall behind before
+// it is disconnected
+const subscriberBuffer = 64
+
+// Broker fans published changes out to subscribers. It is safe for
+// concurrent use.
+type Broker struct {
+	mu          sync.Mutex
+	size        int
+	recent      []Change
+	lastID      uint64
+	subscribers map[*Subscription]bool
+}
+
+// NewBroker returns a broker that keeps the last size changes for replay
+func NewBroker(size int) *Broker {
+	return &Broker{size: size, subscribers: map[*Subscription]bool{}}
+}
+
+// Subscription receives the changes of one org, or of every org if its org
+// is "". C is closed when the subscriber falls too far behind or closes the
+// subscription.
+type Subscription struct {
+	C <-chan Change
+
+	c      chan Change
+	orgID  string
+	broker *Broker
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+}
+
+func (s *Subscription) wants(c Change) bool {
+	return s.orgID == "" || s.orgID == c.OrgID
+}
+
+// Publish gives the change the next ID and sends it to every subscriber
+// that wants it. Subscribers that are too far behind are disconnected
+// rather than blocking the publisher.
+func (b *Broker) Publish(c Change) Change {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	c.ID = b.lastID
+	if c.Time.IsZero() {
+		c.Time = time.Now().UTC()
+	}
+
+	b.recent = append(b.recent, c)
+	if len(b.recent) > b.size {
+		b.recent = b.recent[len(b.recent)-b.size:]
+	}
+
+	for s := range b.subscribers {
+		if !s.wants(c) {
+			continue
+		}
+		select {
+		case s.c <- c:
+		default:
+			b.drop(s)
+		}
+	}
+	return c
+}
+
+// Subscribe starts receiving the changes of an org. With a lastID, the
+// buffered changes of the org published after it are returned for replay;
+// missed reports that some changes after lastID are no longer buffered, or
+// that lastID was never issued, so the subscriber must catch up some other
+// way.
+func (b *Broker) Subscribe(orgID string, lastID uint64) (sub *Subscription, replay []Change, missed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan Change, subscriberBuffer)
+	sub = &Subscription{C: c, c: c, orgID: orgID, broker: b}
+	b.subscribers[sub] = true
+
+	if lastID == 0 {
+		return sub, nil, false
+	}
+	if lastID > b.lastID {
+		return sub, nil, true
+	}
+	oldest := b.lastID - uint64(len(b.recent)) + 1
+	missed = lastID+1 < oldest
+	for _, change := range b.recent {
+		if change.ID > lastID && sub.wants(change) {
+			replay = append(replay, change)
+		}
+	}
+	return sub, replay, missed
+}
+
+// drop removes a subscriber and closes its channel. b.mu must be held.
+func (b *Broker) drop(s *Subscription) {
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
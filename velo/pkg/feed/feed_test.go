@@ -0,0 +1,199 @@
+package feed_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/feed"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func publishItems(b *feed.Broker, orgIDs ...string) {
+	for _, orgID := range orgIDs {
+		b.Publish(feed.Change{Type: feed.ItemCreated, OrgID: orgID, Item: models.Item{OrgID: orgID}})
+	}
+}
+
+func ids(changes []feed.Change) []uint64 {
+	var ids []uint64
+	for _, c := range changes {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func assertIDs(t testing.TB, got []feed.Change, want ...uint64) {
+	t.Helper()
+
+	gotIDs := ids(got)
+	if len(gotIDs) != len(want) {
+		t.Fatalf("got change IDs %v, want %v", gotIDs, want)
+	}
+	for i := range want {
+		if gotIDs[i] != want[i] {
+			t.Fatalf("got change IDs %v, want %v", gotIDs, want)
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("sends new changes to the org's subscribers", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		acme, _, _ := b.Subscribe("acme", 0)
+		all, _, _ := b.Subscribe("", 0)
+
+		publishItems(b, "acme", "globex")
+
+		if c := <-acme.C; c.ID != 1 || c.OrgID != "acme" {
+			t.Errorf("got change %+v, want acme's change 1", c)
+		}
+		if len(acme.C) != 0 {
+			t.Error("want globex's change kept from acme")
+		}
+		if len(all.C) != 2 {
+			t.Errorf("got %d changes for an unfiltered subscriber, want 2", len(all.C))
+		}
+	})
+
+	t.Run("replays the org's changes after the last ID", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		publishItems(b, "acme", "globex", "acme", "acme")
+
+		_, replay, missed := b.Subscribe("acme", 1)
+		if missed {
+			t.Error("want nothing missed")
+		}
+		assertIDs(t, replay, 3, 4)
+	})
+
+	t.Run("reports changes that fell out of the buffer", func(t *testing.T) {
+		b := feed.NewBroker(2)
+		publishItems(b, "acme", "acme", "acme", "acme")
+
+		_, replay, missed := b.Subscribe("acme", 1)
+		if !missed {
+			t.Error("want changes 2 to be reported missed")
+		}
+		assertIDs(t, replay, 3, 4)
+
+		if _, _, missed := b.Subscribe("acme", 2); missed {
+			t.Error("want nothing missed when resuming at the buffer's edge")
+		}
+		if _, _, missed := b.Subscribe("acme", 99); !missed {
+			t.Error("want an ID that was never issued to be reported missed")
+		}
+	})
+
+	t.Run("disconnects subscribers that fall behind", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		slow, _, _ := b.Subscribe("", 0)
+
+		for range 100 {
+			publishItems(b, "acme")
+		}
+
+		received := 0
+		for range slow.C {
+			received++
+		}
+		if received == 0 || received == 100 {
+			t.Errorf("got %d changes before being disconnected, want some but not all", received)
+		}
+	})
+
+	t.Run("closing a subscription closes its channel", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		sub, _, _ := b.Subscribe("", 0)
+		sub.Close()
+		sub.Close()
+
+		publishItems(b, "acme")
+		if _, ok := <-sub.C; ok {
+			t.Error("want no changes after closing")
+		}
+	})
+}
+
+// txStub runs transactions against the stub, failing them on request. It
+// cannot undo changes, but lets the publishing of them be checked.
+type txStub struct {
+	*testutils.StubAppStore
+	fail bool
+}
+
+func (s *txStub) InTx(fn func(models.AppStore) error) error {
+	if err := fn(s.StubAppStore); err != nil {
+		return err
+	}
+	if s.fail {
+		return errors.New("commit failed")
+	}
+	return nil
+}
+
+func TestPublishing(t *testing.T) {
+	t.Run("publishes item changes", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		store := feed.Publishing(testutils.NewStubAppStore(), b)
+		sub, _, _ := b.Subscribe("", 0)
+
+		item, _ := store.CreateItem(models.CreateItemInput{Name: "bike", OrgID: "acme"})
+		name := "renamed"
+		store.UpdateItem(item.ID, models.ItemUpdate{Name: &name})
+		store.SoftDeleteItem(item.ID, item.CreatedAt)
+		store.RestoreItem(item.ID)
+		store.DeleteItem(item.ID)
+		store.UpdateItem("missing", models.ItemUpdate{Name: &name})
+
+		want := []string{feed.ItemCreated, feed.ItemUpdated, feed.ItemDeleted, feed.ItemUpdated, feed.ItemDeleted}
+		if len(sub.C) != len(want) {
+			t.Fatalf("got %d changes, want %d", len(sub.C), len(want))
+		}
+		for _, changeType := range want {
+			c := <-sub.C
+			if c.Type != changeType || c.OrgID != "acme" || c.Item.ID != item.ID {
+				t.Errorf("got change %+v, want %s of %s", c, changeType, item.ID)
+			}
+		}
+	})
+
+	t.Run("publishes a transaction's changes once it commits", func(t *testing.T) {
+		b := feed.NewBroker(10)
+		base := &txStub{StubAppStore: testutils.NewStubAppStore()}
+		store := feed.Publishing(base, b)
+		sub, _, _ := b.Subscribe("", 0)
+
+		tx, ok := store.(models.Transactor)
+		if !ok {
+			t.Fatal("want the wrapper of a Transactor to be a Transactor")
+		}
+
+		tx.InTx(func(tx models.AppStore) error {
+			tx.CreateItem(models.CreateItemInput{Name: "first"})
+			if len(sub.C) != 0 {
+				t.Error("want no changes published before the commit")
+			}
+			return nil
+		})
+		if len(sub.C) != 1 {
+			t.Errorf("got %d changes after the commit, want 1", len(sub.C))
+		}
+
+		base.fail = true
+		tx.InTx(func(tx models.AppStore) error {
+			tx.CreateItem(models.CreateItemInput{Name: "second"})
+			return nil
+		})
+		if len(sub.C) != 1 {
+			t.Error("want no changes published from a failed transaction")
+		}
+	})
+
+	t.Run("only wraps Transactors as Transactors", func(t *testing.T) {
+		store := feed.Publishing(testutils.NewStubAppStore(), feed.NewBroker(10))
+		if _, ok := store.(models.Transactor); ok {
+			t.Error("want the stub's wrapper not to be a Transactor")
+		}
+	})
+}
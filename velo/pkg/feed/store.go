@@ -0,0 +1,94 @@
+package feed
+
+import (
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Publishing wraps store so that every item it creates, updates, deletes
+// or restores is published to broker. If store is a models.Transactor, so
+// is the wrapper, and changes made in a transaction are only published once
+// it commits. Purged items are not published.
+func Publishing(store models.AppStore, broker *Broker) models.AppStore {
+	s := &publishingStore{AppStore: store, publish: func(c Change) { broker.Publish(c) }}
+	if tx, ok := store.(models.Transactor); ok {
+		return &transactingStore{publishingStore: s, tx: tx, broker: broker}
+	}
+	return s
+}
+
+type publishingStore struct {
+	models.AppStore
+	publish func(Change)
+}
+
+func (s *publishingStore) changed(changeType string, item models.Item) {
+	s.publish(Change{Type: changeType, OrgID: item.OrgID, Item: item})
+}
+
+func (s *publishingStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	item, err := s.AppStore.CreateItem(input)
+	if err == nil {
+		s.changed(ItemCreated, item)
+	}
+	return item, err
+}
+
+func (s *publishingStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	item, err := s.AppStore.UpdateItem(id, update)
+	if err == nil {
+		s.changed(ItemUpdated, item)
+	}
+	return item, err
+}
+
+func (s *publishingStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
+	item, err := s.AppStore.SoftDeleteItem(id, at)
+	if err == nil {
+		s.changed(ItemDeleted, item)
+	}
+	return item, err
+}
+
+func (s *publishingStore) RestoreItem(id string) (models.Item, error) {
+	item, err := s.AppStore.RestoreItem(id)
+	if err == nil {
+		s.changed(ItemUpdated, item)
+	}
+	return item, err
+}
+
+func (s *publishingStore) DeleteItem(id string) error {
+	item, err := s.AppStore.GetItem(id)
+	if err != nil {
+		return err
+	}
+	if err := s.AppStore.DeleteItem(id); err != nil {
+		return err
+	}
+	s.changed(ItemDeleted, item)
+	return nil
+}
+
+// transactingStore holds back the changes made in a transaction until it
+// commits
+type transactingStore struct {
+	*publishingStore
+	tx     models.Transactor
+	broker *Broker
+}
+
+func (s *transactingStore) InTx(fn func(tx models.AppStore) error) error {
+	var pending []Change
+	err := s.tx.InTx(func(tx models.AppStore) error {
+		return fn(&publishingStore{AppStore: tx, publish: func(c Change) { pending = append(pending, c) }})
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range pending {
+		s.broker.Publish(c)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package idempotency remembers the responses to requests carrying an
+// idempotency key, so that a client retrying a request gets the original
+// response instead of repeating its effects.
+package idempotency
+
+import (
+	"container/list"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMismatch is returned when a key is reused for a different request.
+	ErrMismatch = errors.New("idempotency key was already used for a different request")
+	// ErrInProgress is returned when the first request with a key has not
+	// finished yet.
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// Response is a recorded response
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+type entry struct {
+	key         string
+	fingerprint string
+	// response is nil until the first request completes
+	response *Response
+	expires  time.Time
+}
+
+// Cache holds recorded responses in memory for TTL. It is safe for
+// concurrent use.
+type Cache struct {
+	TTL time.Duration
+	// MaxEntries bounds how many keys are kept. Once it is reached, each
+	// new key evicts the one closest to expiring.
+	MaxEntries int
+	// Now returns the current time; tests may replace it
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// byExpiry holds the entries soonest to expire first. Every entry
+	// expires TTL after it was last touched, so touching one moves it to
+	// the back.
+	byExpiry *list.List
+}
+
+// NewCache returns an empty cache keeping responses for ttl, for at most
+// maxEntries keys
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{TTL: ttl, MaxEntries: maxEntries, Now: time.Now, entries: map[string]*list.Element{}, byExpiry: list.New()}
+}
+
+// Begin claims key for a request identified by fingerprint. If the key has
+// already completed, Begin returns its recorded response, which the caller
+// should replay. Otherwise the caller must finish with Complete or Abandon.
+func (c *Cache) Begin(key, fingerprint string) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Now()
+	c.expire(now)
+
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		switch {
+		case e.fingerprint != fingerprint:
+			return nil, ErrMismatch
+		case e.response == nil:
+			return nil, ErrInProgress
+		default:
+			return e.response, nil
+		}
+	}
+
+	for len(c.entries) >= max(c.MaxEntries, 1) {
+		c.evict(c.byExpiry.Front())
+	}
+	c.entries[key] = c.byExpiry.PushBack(&entry{key: key, fingerprint: fingerprint, expires: now.Add(c.TTL)})
+	return nil, nil
+}
+
+// Complete records the response to the request that claimed key
+func (c *Cache) Complete(key string, response Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		e.response = &response
+		e.expires = c.Now().Add(c.TTL)
+		c.byExpiry.MoveToBack(elem)
+	}
+}
+
+// Abandon releases a key without recording a response, so the request can
+// be retried
+func (c *Cache) Abandon(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.evict(elem)
+	}
+}
+
+// expire drops entries older than the TTL, stopping at the first that is not
+func (c *Cache) expire(now time.Time) {
+	for elem := c.byExpiry.Front(); elem != nil; elem = c.byExpiry.Front() {
+		if now.Before(elem.Value.(*entry).expires) {
+			return
+		}
+		c.evict(elem)
+	}
+}
+
+// evict drops an entry
+func (c *Cache) evict(elem *list.Element) {
+	c.byExpiry.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
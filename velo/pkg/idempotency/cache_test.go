@@ -0,0 +1,108 @@
+package idempotency_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/idempotency"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newCache := func() *idempotency.Cache {
+		c := idempotency.NewCache(time.Hour, 100)
+		c.Now = func() time.Time { return now }
+		return c
+	}
+	response := idempotency.Response{Status: http.StatusCreated, Header: http.Header{"Location": {"/items/1"}}, Body: []byte(`{}`)}
+
+	t.Run("replays a completed request", func(t *testing.T) {
+		c := newCache()
+
+		if recorded, err := c.Begin("key", "body"); recorded != nil || err != nil {
+			t.Fatalf("Begin on a new key returned %v, %v", recorded, err)
+		}
+		c.Complete("key", response)
+
+		recorded, err := c.Begin("key", "body")
+		if err != nil {
+			t.Fatalf("Begin returned error: %v", err)
+		}
+		if recorded == nil || recorded.Status != http.StatusCreated || recorded.Header.Get("Location") != "/items/1" {
+			t.Errorf("got recorded response %+v", recorded)
+		}
+	})
+
+	t.Run("rejects a key reused for a different request", func(t *testing.T) {
+		c := newCache()
+		c.Begin("key", "body")
+		c.Complete("key", response)
+
+		if _, err := c.Begin("key", "other body"); !errors.Is(err, idempotency.ErrMismatch) {
+			t.Errorf("expected ErrMismatch, got %v", err)
+		}
+	})
+
+	t.Run("rejects a repeat while the first request is in progress", func(t *testing.T) {
+		c := newCache()
+		c.Begin("key", "body")
+
+		if _, err := c.Begin("key", "body"); !errors.Is(err, idempotency.ErrInProgress) {
+			t.Errorf("expected ErrInProgress, got %v", err)
+		}
+	})
+
+	t.Run("abandoned keys can be retried", func(t *testing.T) {
+		c := newCache()
+		c.Begin("key", "body")
+		c.Abandon("key")
+
+		if recorded, err := c.Begin("key", "body"); recorded != nil || err != nil {
+			t.Errorf("Begin after Abandon returned %v, %v", recorded, err)
+		}
+	})
+
+	t.Run("keeps completed responses for the TTL after completing", func(t *testing.T) {
+		c := newCache()
+		c.Begin("first", "body")
+		now = now.Add(30 * time.Minute)
+		c.Begin("second", "body")
+		c.Complete("first", response)
+		c.Complete("second", response)
+
+		now = now.Add(45 * time.Minute)
+		if recorded, _ := c.Begin("first", "body"); recorded == nil {
+			t.Error("forgot a response completed less than the TTL ago")
+		}
+	})
+
+	t.Run("forgets responses after the TTL", func(t *testing.T) {
+		c := newCache()
+		c.Begin("key", "body")
+		c.Complete("key", response)
+
+		now = now.Add(time.Hour)
+		if recorded, err := c.Begin("key", "other body"); recorded != nil || err != nil {
+			t.Errorf("Begin after the TTL returned %v, %v", recorded, err)
+		}
+	})
+
+	t.Run("evicts the key closest to expiring once full", func(t *testing.T) {
+		c := newCache()
+		c.MaxEntries = 2
+		for _, key := range []string{"first", "second"} {
+			c.Begin(key, "body")
+			c.Complete(key, response)
+		}
+		c.Begin("third", "body")
+
+		if recorded, _ := c.Begin("second", "body"); recorded == nil {
+			t.Error("want the newer key kept")
+		}
+		if recorded, _ := c.Begin("first", "other body"); recorded != nil {
+			t.Errorf("got %+v for the evicted key, want it forgotten", recorded)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := metrics.NewHTTPMetrics(r)
+	handler := m.Instrument("GET /items/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+		http.NewResponseController(w).Flush()
+	}))
+
+	for _, path := range []string{"/items/1", "/items/2", "/items/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	got := writeText(t, r)
+	for _, want := range []string{
+		`velo_http_requests_total{route="GET /items/{id}",status="200"} 2`,
+		`velo_http_requests_total{route="GET /items/{id}",status="404"} 1`,
+		`velo_http_request_duration_seconds_count{route="GET /items/{id}",status="200"} 2`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got\n%s\nwant it to contain %s", got, want)
+		}
+	}
+}
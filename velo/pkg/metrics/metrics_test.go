@@ -0,0 +1,90 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+)
+
+func writeText(t testing.TB, r *metrics.Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	return out.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounterVec("jobs_total", "Jobs run.", "queue")
+	c.Inc("b")
+	c.Add(2, "a")
+	c.Inc("a")
+
+	want := `# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{queue="a"} 3
+jobs_total{queue="b"} 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogramVec("wait_seconds", "Time spent waiting.", []float64{0.1, 1}, "queue")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "a")
+	}
+
+	want := `# HELP wait_seconds Time spent waiting.
+# TYPE wait_seconds histogram
+wait_seconds_bucket{queue="a",le="0.1"} 2
+wait_seconds_bucket{queue="a",le="1"} 3
+wait_seconds_bucket{queue="a",le="+Inf"} 4
+wait_seconds_sum{queue="a"} 3.65
+wait_seconds_count{queue="a"} 4
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("escapes label values and help text", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		r.NewCounterVec("odd_total", "A \\ and a\nnewline.", "value").Inc("say \"hi\"\\\n")
+
+		got := writeText(t, r)
+		for _, want := range []string{`# HELP odd_total A \\ and a\nnewline.`, `odd_total{value="say \"hi\"\\\n"} 1`} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got\n%s\nwant it to contain %s", got, want)
+			}
+		}
+	})
+
+	t.Run("writes metrics in the order they were registered", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		r.NewCounterVec("b_total", "B.")
+		r.NewCounterVec("a_total", "A.")
+
+		got := writeText(t, r)
+		if strings.Index(got, "b_total") > strings.Index(got, "a_total") {
+			t.Errorf("got\n%s\nwant b_total first", got)
+		}
+	})
+
+	t.Run("panics on a name registered twice", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		r.NewCounterVec("jobs_total", "Jobs run.")
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		r.NewCounterVec("jobs_total", "Jobs run.")
+	})
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// StoreMetrics times the operations of a models.AppStore
+type StoreMetrics struct {
+	duration *HistogramVec
+}
+
+// NewStoreMetrics registers the store metrics with r
+func NewStoreMetrics(r *Registry) *StoreMetrics {
+	return &StoreMetrics{
+		duration: r.NewHistogramVec("velo_store_operation_duration_seconds", "Time taken by store operations, by operation.", DefaultBuckets, "operation"),
+	}
+}
+
+// Instrument wraps store so that every call is timed. The wrapper is a
+// models.Pinger, and is a models.Transactor if store is one; the stores it
+// passes to transactions are timed as well.
+func (m *StoreMetrics) Instrument(store models.AppStore) models.AppStore {
+	s := &timedStore{AppStore: store, duration: m.duration}
+	if tx, ok := store.(models.Transactor); ok {
+		return &timedTransactor{timedStore: s, tx: tx}
+	}
+	return s
+}
+
+type timedStore struct {
+	models.AppStore
+	duration *HistogramVec
+}
+
+// timed starts timing an operation, returning the function that stops it
+func (s *timedStore) timed(operation string) func() {
+	start := time.Now()
+	return func() {
+		s.duration.Observe(time.Since(start).Seconds(), operation)
+	}
+}
+
+// Ping pings the store if it is a models.Pinger, and otherwise reports
+// that it is ready
+func (s *timedStore) Ping(ctx context.Context) error {
+	pinger, ok := s.AppStore.(models.Pinger)
+	if !ok {
+		return nil
+	}
+	defer s.timed("Ping")()
+	return pinger.Ping(ctx)
+}
+
+func (s *timedStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	defer s.timed("CreateItem")()
+	return s.AppStore.CreateItem(input)
+}
+
+func (s *timedStore) GetItem(id string) (models.Item, error) {
+	defer s.timed("GetItem")()
+	return s.AppStore.GetItem(id)
+}
+
+func (s *timedStore) GetItems() ([]models.Item, error) {
+	defer s.timed("GetItems")()
+	return s.AppStore.GetItems()
+}
+
+func (s *timedStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	defer s.timed("QueryItems")()
+	return s.AppStore.QueryItems(query)
+}
+
+func (s *timedStore) SearchItems(search models.ItemSearch) ([]models.ItemSearchResult, error) {
+	defer s.timed("SearchItems")()
+	return s.AppStore.SearchItems(search)
+}
+
+func (s *timedStore) DeleteItem(id string) error {
+	defer s.timed("DeleteItem")()
+	return s.AppStore.DeleteItem(id)
+}
+
+func (s *timedStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
+	defer s.timed("SoftDeleteItem")()
+	return s.AppStore.SoftDeleteItem(id, at)
+}
+
+func (s *timedStore) RestoreItem(id string) (models.Item, error) {
+	defer s.timed("RestoreItem")()
+	return s.AppStore.RestoreItem(id)
+}
+
+func (s *timedStore) PurgeItems(deletedBefore time.Time) (int, error) {
+	defer s.timed("PurgeItems")()
+	return s.AppStore.PurgeItems(deletedBefore)
+}
+
+func (s *timedStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	defer s.timed("UpdateItem")()
+	return s.AppStore.UpdateItem(id, update)
+}
+
+func (s *timedStore) CreateUser(input models.CreateUserInput) (models.User, error) {
+	defer s.timed("CreateUser")()
+	return s.AppStore.CreateUser(input)
+}
+
+func (s *timedStore) GetUser(id string) (models.User, error) {
+	defer s.timed("GetUser")()
+	return s.AppStore.GetUser(id)
+}
+
+func (s *timedStore) GetUserByName(name string) (models.User, error) {
+	defer s.timed("GetUserByName")()
+	return s.AppStore.GetUserByName(name)
+}
+
+func (s *timedStore) UpdateUser(id string, update map[string]any) (models.User, error) {
+	defer s.timed("UpdateUser")()
+	return s.AppStore.UpdateUser(id, update)
+}
+
+func (s *timedStore) DeleteUser(id string) error {
+	defer s.timed("DeleteUser")()
+	return s.AppStore.DeleteUser(id)
+}
+
+func (s *timedStore) CreateSession(input models.CreateSessionInput) (models.Session, error) {
+	defer s.timed("CreateSession")()
+	return s.AppStore.CreateSession(input)
+}
+
+func (s *timedStore) GetSession(id string) (models.Session, error) {
+	defer s.timed("GetSession")()
+	return s.AppStore.GetSession(id)
+}
+
+func (s *timedStore) GetSessionByToken(tokenHash string) (models.Session, error) {
+	defer s.timed("GetSessionByToken")()
+	return s.AppStore.GetSessionByToken(tokenHash)
+}
+
+func (s *timedStore) DeleteSession(id string) error {
+	defer s.timed("DeleteSession")()
+	return s.AppStore.DeleteSession(id)
+}
+
+func (s *timedStore) CreateOrg(input models.CreateOrgInput) (models.Org, error) {
+	defer s.timed("CreateOrg")()
+	return s.AppStore.CreateOrg(input)
+}
+
+func (s *timedStore) GetOrg(id string) (models.Org, error) {
+	defer s.timed("GetOrg")()
+	return s.AppStore.GetOrg(id)
+}
+
+func (s *timedStore) GetOrgs() ([]models.Org, error) {
+	defer s.timed("GetOrgs")()
+	return s.AppStore.GetOrgs()
+}
+
+func (s *timedStore) DeleteOrg(id string) error {
+	defer s.timed("DeleteOrg")()
+	return s.AppStore.DeleteOrg(id)
+}
+
+func (s *timedStore) AddMember(orgID, userID string, role models.Role) (models.Membership, error) {
+	defer s.timed("AddMember")()
+	return s.AppStore.AddMember(orgID, userID, role)
+}
+
+func (s *timedStore) RemoveMember(orgID, userID string) error {
+	defer s.timed("RemoveMember")()
+	return s.AppStore.RemoveMember(orgID, userID)
+}
+
+func (s *timedStore) GetMembers(orgID string) ([]models.Membership, error) {
+	defer s.timed("GetMembers")()
+	return s.AppStore.GetMembers(orgID)
+}
+
+func (s *timedStore) GetMemberships(userID string) ([]models.Membership, error) {
+	defer s.timed("GetMemberships")()
+	return s.AppStore.GetMemberships(userID)
+}
+
+// timedTransactor also times whole transactions
+type timedTransactor struct {
+	*timedStore
+	tx models.Transactor
+}
+
+func (s *timedTransactor) InTx(fn func(models.AppStore) error) error {
+	defer s.timed("InTx")()
+	return s.tx.InTx(func(tx models.AppStore) error {
+		return fn(&timedStore{AppStore: tx, duration: s.duration})
+	})
+}
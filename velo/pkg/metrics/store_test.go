@@ -0,0 +1,101 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/internal/store"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
+	"github.com/espennoreng/learn-go-with-tests/velo/storetest"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	t.Run("the instrumented store still passes the conformance suite", func(t *testing.T) {
+		storetest.Run(t, func() models.AppStore {
+			return metrics.NewStoreMetrics(metrics.NewRegistry()).Instrument(openSQLite(t))
+		})
+	})
+
+	t.Run("times each operation", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		s := metrics.NewStoreMetrics(r).Instrument(testutils.NewStubAppStore())
+		s.CreateItem(models.CreateItemInput{Name: "mug"})
+		s.GetItems()
+		s.GetItems()
+
+		got := writeText(t, r)
+		for _, want := range []string{
+			`velo_store_operation_duration_seconds_count{operation="CreateItem"} 1`,
+			`velo_store_operation_duration_seconds_count{operation="GetItems"} 2`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got\n%s\nwant it to contain %s", got, want)
+			}
+		}
+	})
+
+	t.Run("keeps transactions and times the stores inside them", func(t *testing.T) {
+		r := metrics.NewRegistry()
+		s := metrics.NewStoreMetrics(r).Instrument(openSQLite(t))
+		transactor, ok := s.(models.Transactor)
+		if !ok {
+			t.Fatal("instrumented store is not a models.Transactor")
+		}
+		transactor.InTx(func(tx models.AppStore) error {
+			_, err := tx.CreateItem(models.CreateItemInput{Name: "mug"})
+			return err
+		})
+
+		got := writeText(t, r)
+		for _, want := range []string{
+			`velo_store_operation_duration_seconds_count{operation="InTx"} 1`,
+			`velo_store_operation_duration_seconds_count{operation="CreateItem"} 1`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got\n%s\nwant it to contain %s", got, want)
+			}
+		}
+	})
+
+	t.Run("pings stores that can be pinged", func(t *testing.T) {
+		s := metrics.NewStoreMetrics(metrics.NewRegistry()).Instrument(openSQLite(t))
+		if err := s.(models.Pinger).Ping(context.Background()); err != nil {
+			t.Errorf("got %v, want an open database to answer", err)
+		}
+
+		failing := pingStore{AppStore: testutils.NewStubAppStore(), err: errors.New("connection refused")}
+		s = metrics.NewStoreMetrics(metrics.NewRegistry()).Instrument(failing)
+		if err := s.(models.Pinger).Ping(context.Background()); err != failing.err {
+			t.Errorf("got %v, want %v", err, failing.err)
+		}
+
+		s = metrics.NewStoreMetrics(metrics.NewRegistry()).Instrument(testutils.NewStubAppStore())
+		if err := s.(models.Pinger).Ping(context.Background()); err != nil {
+			t.Errorf("got %v, want stores without Ping to be ready", err)
+		}
+	})
+}
+
+func openSQLite(t testing.TB) models.AppStore {
+	t.Helper()
+	s, err := store.OpenSQLite(filepath.Join(t.TempDir(), "velo.db"))
+	if err != nil {
+		t.Fatalf("could not open sqlite store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+type pingStore struct {
+	models.AppStore
+	err error
+}
+
+func (s pingStore) Ping(context.Context) error {
+	return s.err
+}
@@ -0,0 +1,86 @@
+// Package rbac decides which callers may use which API routes. Policies are
+// plain data, so they can be checked without going through HTTP.
+package rbac
+
+import (
+	"strings"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// RoleSystemAdmin is required by routes that manage orgs themselves. No
+// membership can grant it; only users with IsAdmin hold it.
+const RoleSystemAdmin models.Role = "system_admin"
+
+// Policy requires Role of anyone calling Method on a path matching Pattern.
+// Patterns use the net/http ServeMux syntax: "/items/{id}" matches one
+// segment for {id}. An empty Role lets any caller through.
+type Policy struct {
+	Method  string
+	Pattern string
+	Role    models.Role
+}
+
+// Principal is the caller a policy is checked against: their role in the
+// selected org, if any, and whether they are a system admin.
+type Principal struct {
+	Role    models.Role
+	IsAdmin bool
+}
+
+// Allows reports whether the principal satisfies the policy. System admins
+// satisfy every policy.
+func (p Policy) Allows(principal Principal) bool {
+	switch {
+	case p.Role == "" || principal.IsAdmin:
+		return true
+	case p.Role == RoleSystemAdmin:
+		return false
+	default:
+		return principal.Role.Includes(p.Role)
+	}
+}
+
+// Table is the full set of policies for an API
+type Table []Policy
+
+// Lookup returns the policy for a request, if the table has one
+func (t Table) Lookup(method, path string) (Policy, bool) {
+	for _, p := range t {
+		if p.Method == method && matches(p.Pattern, path) {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+// Allows reports whether the principal may call method on path. Requests
+// that no policy covers are allowed, leaving the handler to reject routes
+// that do not exist.
+func (t Table) Allows(method, path string, principal Principal) bool {
+	p, ok := t.Lookup(method, path)
+	return !ok || p.Allows(principal)
+}
+
+// matches compares a pattern with a path segment by segment, letting a
+// {wildcard} segment stand for any non-empty segment
+func matches(pattern, path string) bool {
+	want := strings.Split(strings.Trim(pattern, "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+
+	for i := range want {
+		if strings.HasPrefix(want[i], "{") && strings.HasSuffix(want[i], "}") {
+			if got[i] == "" {
+				return false
+			}
+			continue
+		}
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
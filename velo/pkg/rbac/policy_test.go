@@ -0,0 +1,70 @@
+package rbac_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/rbac"
+)
+
+func TestTableLookup(t *testing.T) {
+	table := rbac.Table{
+		{Method: http.MethodGet, Pattern: "/items", Role: models.RoleViewer},
+		{Method: http.MethodGet, Pattern: "/items/{id}", Role: models.RoleViewer},
+		{Method: http.MethodPost, Pattern: "/items/{id}/restore", Role: models.RoleAdmin},
+	}
+
+	cases := []struct {
+		method, path string
+		want         string
+	}{
+		{http.MethodGet, "/items", "/items"},
+		{http.MethodGet, "/items/item-1", "/items/{id}"},
+		{http.MethodPost, "/items/item-1/restore", "/items/{id}/restore"},
+		{http.MethodPost, "/items/item-1", ""},
+		{http.MethodGet, "/items/item-1/other", ""},
+		{http.MethodGet, "/users", ""},
+	}
+
+	for _, tc := range cases {
+		policy, ok := table.Lookup(tc.method, tc.path)
+		if tc.want == "" {
+			if ok {
+				t.Errorf("%s %s: expected no policy, got %+v", tc.method, tc.path, policy)
+			}
+			continue
+		}
+		if !ok || policy.Pattern != tc.want {
+			t.Errorf("%s %s: got policy %+v, want pattern %q", tc.method, tc.path, policy, tc.want)
+		}
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	cases := []struct {
+		name      string
+		required  models.Role
+		principal rbac.Principal
+		want      bool
+	}{
+		{"no role required", "", rbac.Principal{}, true},
+		{"exact role", models.RoleEditor, rbac.Principal{Role: models.RoleEditor}, true},
+		{"higher role", models.RoleViewer, rbac.Principal{Role: models.RoleAdmin}, true},
+		{"lower role", models.RoleAdmin, rbac.Principal{Role: models.RoleEditor}, false},
+		{"no membership", models.RoleViewer, rbac.Principal{}, false},
+		{"unknown role", models.RoleViewer, rbac.Principal{Role: "owner"}, false},
+		{"system admin", models.RoleAdmin, rbac.Principal{IsAdmin: true}, true},
+		{"org admin on a system route", rbac.RoleSystemAdmin, rbac.Principal{Role: models.RoleAdmin}, false},
+		{"system admin on a system route", rbac.RoleSystemAdmin, rbac.Principal{IsAdmin: true}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := rbac.Policy{Method: http.MethodGet, Pattern: "/items", Role: tc.required}
+			if got := policy.Allows(tc.principal); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
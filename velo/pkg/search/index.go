@@ -0,0 +1,186 @@
+// Package search is an in-memory inverted index of item names. Stores keep
+// an index in step with their items and use it to answer ItemSearch.
+package search
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Hit is an item matching a search, with its relevance score
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// document is what the index knows about one item
+type document struct {
+	orgID string
+	// terms counts how often each term occurs in the name
+	terms  map[string]int
+	length int
+}
+
+// Index maps the terms of item names to the items containing them. Only
+// live items are indexed. It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]document
+	postings map[string]map[string]int
+	// terms is every indexed term in order, for finding terms by prefix
+	terms []string
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{docs: map[string]document{}, postings: map[string]map[string]int{}}
+}
+
+// Tokenize splits text into lower-case words of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Put indexes the current state of an item, replacing what was indexed for
+// it before. Soft-deleted items are removed from the index.
+func (x *Index) Put(item models.Item) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(item.ID)
+	if item.IsDeleted() {
+		return
+	}
+
+	doc := document{orgID: item.OrgID, terms: map[string]int{}}
+	for _, term := range Tokenize(item.Name) {
+		doc.terms[term]++
+		doc.length++
+	}
+	for term, count := range doc.terms {
+		postings, ok := x.postings[term]
+		if !ok {
+			postings = map[string]int{}
+			x.postings[term] = postings
+			i, _ := slices.BinarySearch(x.terms, term)
+			x.terms = slices.Insert(x.terms, i, term)
+		}
+		postings[item.ID] = count
+	}
+	x.docs[item.ID] = doc
+}
+
+// Remove drops an item from the index
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *Index) remove(id string) {
+	doc, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(x.postings[term], id)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+			i, _ := slices.BinarySearch(x.terms, term)
+			x.terms = slices.Delete(x.terms, i, i+1)
+		}
+	}
+	delete(x.docs, id)
+}
+
+// Search returns the items in the search's org that match every word of
+// its text, either exactly or as a prefix of a word in the name. Items
+// score higher for rarer words, for exact rather than prefix matches, for
+// repeated words and for shorter names. Ties are broken by ID.
+func (x *Index) Search(search models.ItemSearch) []Hit {
+	words := Tokenize(search.Text)
+	if len(words) == 0 {
+		return []Hit{}
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var scores map[string]float64
+	for _, word := range words {
+		wordScores := x.scoreWord(word, search.OrgID)
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		// Every word must match, so keep only the items matching all so far
+		for id, score := range scores {
+			if wordScore, ok := wordScores[id]; ok {
+				scores[id] = score + wordScore
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score / math.Sqrt(float64(x.docs[id].length))})
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	if limit := search.ResultLimit(); len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// scoreWord scores the items in an org with a term starting with word. An
+// item matching through several terms keeps its best match. The word's
+// rarity is judged by how many items it matches, so that a rare term does
+// not lift a prefix match above an exact one. x.mu must be held.
+func (x *Index) scoreWord(word, orgID string) map[string]float64 {
+	scores := map[string]float64{}
+	start, _ := slices.BinarySearch(x.terms, word)
+	for _, term := range x.terms[start:] {
+		if !strings.HasPrefix(term, word) {
+			break
+		}
+
+		// A prefix counts for the share of the term it covers, and less
+		// than an exact match of the same length would
+		quality := 1.0
+		if term != word {
+			quality = 0.8 * float64(len(word)) / float64(len(term))
+		}
+
+		for id, count := range x.postings[term] {
+			if orgID != "" && x.docs[id].orgID != orgID {
+				continue
+			}
+			score := quality * (1 + math.Log(float64(count)))
+			if score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+
+	idf := math.Log(1 + float64(len(x.docs))/float64(max(len(scores), 1)))
+	for id := range scores {
+		scores[id] *= idf
+	}
+	return scores
+}
@@ -0,0 +1,115 @@
+package search_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/search"
+)
+
+func hitIDs(hits []search.Hit) []string {
+	ids := []string{}
+	for _, h := range hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func assertHits(t testing.TB, got []search.Hit, want ...string) {
+	t.Helper()
+
+	ids := hitIDs(got)
+	if len(ids) != len(want) {
+		t.Fatalf("got hits %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("got hits %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := search.Tokenize("Mountain-Bike, 29er (Blå)")
+	want := []string{"mountain", "bike", "29er", "blå"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	newIndex := func(items ...models.Item) *search.Index {
+		x := search.NewIndex()
+		for _, item := range items {
+			x.Put(item)
+		}
+		return x
+	}
+
+	t.Run("ranks exact, short and rare matches first", func(t *testing.T) {
+		x := newIndex(
+			models.Item{ID: "jacket", Name: "Biker jacket"},
+			models.Item{ID: "bike", Name: "Bike"},
+			models.Item{ID: "mountain", Name: "Mountain bike with extras"},
+			models.Item{ID: "helmet", Name: "Helmet"},
+		)
+
+		assertHits(t, x.Search(models.ItemSearch{Text: "bike"}), "bike", "mountain", "jacket")
+		assertHits(t, x.Search(models.ItemSearch{Text: "BIK"}), "bike", "jacket", "mountain")
+	})
+
+	t.Run("needs every word to match", func(t *testing.T) {
+		x := newIndex(
+			models.Item{ID: "1", Name: "red bike"},
+			models.Item{ID: "2", Name: "red helmet"},
+			models.Item{ID: "3", Name: "blue bike"},
+		)
+
+		assertHits(t, x.Search(models.ItemSearch{Text: "red bi"}), "1")
+		assertHits(t, x.Search(models.ItemSearch{Text: "green"}))
+		assertHits(t, x.Search(models.ItemSearch{Text: "  ,. "}))
+	})
+
+	t.Run("searches one org", func(t *testing.T) {
+		x := newIndex(
+			models.Item{ID: "1", Name: "bike", OrgID: "acme"},
+			models.Item{ID: "2", Name: "bike", OrgID: "globex"},
+		)
+
+		assertHits(t, x.Search(models.ItemSearch{Text: "bike", OrgID: "globex"}), "2")
+		assertHits(t, x.Search(models.ItemSearch{Text: "bike"}), "1", "2")
+	})
+
+	t.Run("follows updates and deletes", func(t *testing.T) {
+		deletedAt := time.Now()
+		x := newIndex(
+			models.Item{ID: "1", Name: "bike"},
+			models.Item{ID: "2", Name: "bike"},
+			models.Item{ID: "3", Name: "bike"},
+		)
+
+		x.Put(models.Item{ID: "1", Name: "helmet"})
+		x.Put(models.Item{ID: "2", Name: "bike", DeletedAt: &deletedAt})
+		x.Remove("3")
+		x.Remove("missing")
+
+		assertHits(t, x.Search(models.ItemSearch{Text: "bike"}))
+		assertHits(t, x.Search(models.ItemSearch{Text: "hel"}), "1")
+	})
+
+	t.Run("limits the results", func(t *testing.T) {
+		x := newIndex(
+			models.Item{ID: "1", Name: "bike"},
+			models.Item{ID: "2", Name: "bike"},
+			models.Item{ID: "3", Name: "bike"},
+		)
+
+		assertHits(t, x.Search(models.ItemSearch{Text: "bike", Limit: 2}), "1", "2")
+	})
+}
@@ -0,0 +1,107 @@
+// Package tenant confines an AppStore to the items of a single organisation.
+package tenant
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// scopedStore is an AppStore that can only see and change the items of one
+// org. Items in other orgs behave as if they did not exist. Users, sessions
+// and orgs are not owned by an org and pass straight through.
+type scopedStore struct {
+	models.AppStore
+	orgID string
+}
+
+// Scope returns a view of store restricted to the items of orgID
+func Scope(store models.AppStore, orgID string) models.AppStore {
+	return &scopedStore{AppStore: store, orgID: orgID}
+}
+
+// owned gets an item, reporting items in other orgs as not found
+func (s *scopedStore) owned(id string) (models.Item, error) {
+	item, err := s.AppStore.GetItem(id)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if item.OrgID != s.orgID {
+		return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+	}
+	return item, nil
+}
+
+func (s *scopedStore) GetItem(id string) (models.Item, error) {
+	return s.owned(id)
+}
+
+func (s *scopedStore) GetItems() ([]models.Item, error) {
+	items, err := s.AppStore.GetItems()
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := []models.Item{}
+	for _, item := range items {
+		if item.OrgID == s.orgID {
+			scoped = append(scoped, item)
+		}
+	}
+	return scoped, nil
+}
+
+func (s *scopedStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	query.OrgID = s.orgID
+	return s.AppStore.QueryItems(query)
+}
+
+func (s *scopedStore) SearchItems(search models.ItemSearch) ([]models.ItemSearchResult, error) {
+	search.OrgID = s.orgID
+	return s.AppStore.SearchItems(search)
+}
+
+// CreateItem always creates the item in the scoped org
+func (s *scopedStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	input.OrgID = s.orgID
+	return s.AppStore.CreateItem(input)
+}
+
+// UpdateItem refuses to move an item into another org
+func (s *scopedStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	if _, err := s.owned(id); err != nil {
+		return models.Item{}, err
+	}
+	if update.OrgID != nil && *update.OrgID != s.orgID {
+		return models.Item{}, fmt.Errorf("moving item %s out of org %s: %w", id, s.orgID, models.ErrForbidden)
+	}
+	return s.AppStore.UpdateItem(id, update)
+}
+
+func (s *scopedStore) DeleteItem(id string) error {
+	if _, err := s.owned(id); err != nil {
+		return err
+	}
+	return s.AppStore.DeleteItem(id)
+}
+
+func (s *scopedStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
+	if _, err := s.owned(id); err != nil {
+		return models.Item{}, err
+	}
+	return s.AppStore.SoftDeleteItem(id, at)
+}
+
+func (s *scopedStore) RestoreItem(id string) (models.Item, error) {
+	if _, err := s.owned(id); err != nil {
+		return models.Item{}, err
+	}
+	return s.AppStore.RestoreItem(id)
+}
+
+// PurgeItems is a maintenance task across every org, so it is not
+// available to a single tenant
+func (s *scopedStore) PurgeItems(deletedBefore time.Time) (int, error) {
+	return 0, fmt.Errorf("purging items from org %s: %w", s.orgID, models.ErrForbidden)
+}
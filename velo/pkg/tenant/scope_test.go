@@ -0,0 +1,79 @@
+package tenant_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/tenant"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestScope(t *testing.T) {
+	newStores := func() (models.AppStore, models.Item, models.Item) {
+		base := testutils.NewStubAppStore()
+		mine, _ := base.CreateItem(models.CreateItemInput{Name: "mine", OrgID: "org-a"})
+		theirs, _ := base.CreateItem(models.CreateItemInput{Name: "theirs", OrgID: "org-b"})
+		return tenant.Scope(base, "org-a"), mine, theirs
+	}
+
+	t.Run("hides items in other orgs", func(t *testing.T) {
+		scoped, mine, theirs := newStores()
+
+		if _, err := scoped.GetItem(mine.ID); err != nil {
+			t.Errorf("GetItem returned error for an item in the org: %v", err)
+		}
+		if _, err := scoped.GetItem(theirs.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected ErrNotFound for an item in another org, got %v", err)
+		}
+
+		items, _ := scoped.GetItems()
+		testutils.AssertContainsIDs(t, items, mine.ID)
+
+		page, _ := scoped.QueryItems(models.ItemQuery{OrgID: "org-b"})
+		testutils.AssertContainsIDs(t, page.Items, mine.ID)
+
+		if results, _ := scoped.SearchItems(models.ItemSearch{Text: "theirs", OrgID: "org-b"}); len(results) != 0 {
+			t.Errorf("search found %d items in another org, want none", len(results))
+		}
+	})
+
+	t.Run("refuses to change items in other orgs", func(t *testing.T) {
+		scoped, _, theirs := newStores()
+
+		if _, err := scoped.UpdateItem(theirs.ID, models.ItemUpdate{}); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected UpdateItem to return ErrNotFound, got %v", err)
+		}
+		if _, err := scoped.SoftDeleteItem(theirs.ID, time.Now()); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected SoftDeleteItem to return ErrNotFound, got %v", err)
+		}
+		if _, err := scoped.RestoreItem(theirs.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected RestoreItem to return ErrNotFound, got %v", err)
+		}
+		if err := scoped.DeleteItem(theirs.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected DeleteItem to return ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("creates items in the org", func(t *testing.T) {
+		scoped, _, _ := newStores()
+
+		item, err := scoped.CreateItem(models.CreateItemInput{Name: "new", OrgID: "org-b"})
+		if err != nil {
+			t.Fatalf("CreateItem returned error: %v", err)
+		}
+		if item.OrgID != "org-a" {
+			t.Errorf("got org %q, want %q", item.OrgID, "org-a")
+		}
+	})
+
+	t.Run("refuses to move items to another org", func(t *testing.T) {
+		scoped, mine, _ := newStores()
+		other := "org-b"
+
+		if _, err := scoped.UpdateItem(mine.ID, models.ItemUpdate{OrgID: &other}); !errors.Is(err, models.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,451 @@
+// Package webhook delivers events to the URLs subscribed to them. Each
+// delivery is signed with the subscription's secret, retried with
+// exponential backoff, and kept as a dead letter if it never succeeds.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Velo-Event"
+	DeliveryHeader  = "X-Velo-Delivery"
+	TimestampHeader = "X-Velo-Timestamp"
+	SignatureHeader = "X-Velo-Signature"
+)
+
+// ErrQueueFull is recorded as the error of a delivery dropped because the
+// queue had no room for it
+var ErrQueueFull = errors.New("the delivery queue is full")
+
+// Event is the body of a delivery. Data is the change the event describes.
+type Event struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	OrgID string    `json:"org_id,omitempty"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// Subscription sends the events of an org to URL. A subscription without
+// an org receives the events of every org, and those belonging to none. An
+// empty Events list subscribes to every event type. The secret is only
+// shown when the subscription is created.
+type Subscription struct {
+	ID        string    `json:"id"`
+	OrgID     string    `json:"org_id,omitempty"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events,omitempty"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wants reports whether the subscription should receive the event
+func (s Subscription) wants(e Event) bool {
+	if s.OrgID != "" && s.OrgID != e.OrgID {
+		return false
+	}
+	return len(s.Events) == 0 || slices.Contains(s.Events, e.Type)
+}
+
+// SubscriptionStore persists subscriptions, secrets included, so that they
+// survive a restart
+type SubscriptionStore interface {
+	CreateWebhook(s Subscription) error
+	GetWebhooks() ([]Subscription, error)
+	DeleteWebhook(id string) error
+}
+
+// DeadLetter is an event that could not be delivered to a subscription
+type DeadLetter struct {
+	SubscriptionID string    `json:"subscription_id"`
+	OrgID          string    `json:"org_id,omitempty"`
+	URL            string    `json:"url"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error"`
+	FailedAt       time.Time `json:"failed_at"`
+}
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256, keyed by
+// the subscription's secret, of the timestamp header, a dot and the body.
+// Receivers should compare it with the signature header in constant time.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a delivery against its body
+func Verify(secret string, header http.Header, body []byte) bool {
+	want := Sign(secret, header.Get(TimestampHeader), body)
+	return hmac.Equal([]byte(want), []byte(header.Get(SignatureHeader)))
+}
+
+// Dispatcher holds subscriptions in memory and delivers published events
+// to them in the background. The subscriptions are lost when the process
+// exits unless they are also kept in a store with Persist. Dead letters
+// are only ever kept in memory. It is safe for concurrent use.
+type Dispatcher struct {
+	// Client sends deliveries. The default one refuses to connect to
+	// private addresses.
+	Client *http.Client
+	// AllowPrivateAddresses lets webhooks reach loopback, private and
+	// link-local addresses, for receivers on the server's own network
+	AllowPrivateAddresses bool
+	// MaxAttempts is how many times a delivery is tried before it becomes
+	// a dead letter
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; each retry waits twice
+	// as long as the one before, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Workers is how many deliveries are made at once, and QueueSize how
+	// many more may wait for a worker before new ones are dropped as dead
+	// letters. Both take effect at the first Publish.
+	Workers   int
+	QueueSize int
+	// MaxDeadLetters is how many dead letters are kept; the oldest are
+	// dropped first
+	MaxDeadLetters int
+	// Now returns the current time; tests may replace it
+	Now func() time.Time
+
+	mu            sync.Mutex
+	store         SubscriptionStore
+	subscriptions []Subscription
+	deadLetters   []DeadLetter
+
+	start    sync.Once
+	queue    chan delivery
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inFlight sync.WaitGroup
+}
+
+// delivery is an event on its way to a subscription
+type delivery struct {
+	subscription Subscription
+	event        Event
+	body         []byte
+}
+
+// NewDispatcher returns a dispatcher with no subscriptions that tries each
+// delivery five times over about a minute
+func NewDispatcher() *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		MaxAttempts:    5,
+		BaseDelay:      time.Second,
+		MaxDelay:       time.Minute,
+		Workers:        16,
+		QueueSize:      1000,
+		MaxDeadLetters: 1000,
+		Now:            time.Now,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+	d.Client = d.newClient()
+	return d
+}
+
+// Persist keeps subscriptions in store from now on, starting with those
+// already saved there
+func (d *Dispatcher) Persist(store SubscriptionStore) error {
+	subscriptions, err := store.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.store = store
+	d.subscriptions = subscriptions
+	return nil
+}
+
+// Subscribe registers url for the events of an org. URLs that name a
+// private address are rejected with ErrPrivateAddress unless
+// AllowPrivateAddresses is set.
+func (d *Dispatcher) Subscribe(orgID, url string, events []string) (Subscription, error) {
+	if err := d.checkURL(url); err != nil {
+		return Subscription{}, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Subscription{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := Subscription{
+		ID:        "webhook-" + id,
+		OrgID:     orgID,
+		URL:       url,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: d.Now().UTC(),
+	}
+	if d.store != nil {
+		if err := d.store.CreateWebhook(s); err != nil {
+			return Subscription{}, err
+		}
+	}
+	d.subscriptions = append(d.subscriptions, s)
+	return s, nil
+}
+
+// Subscriptions lists the subscriptions of an org
+func (d *Dispatcher) Subscriptions(orgID string) []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscriptions := []Subscription{}
+	for _, s := range d.subscriptions {
+		if s.OrgID == orgID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	return subscriptions
+}
+
+// Unsubscribe removes one of an org's subscriptions, returning it.
+// Deliveries already under way are still attempted.
+func (d *Dispatcher) Unsubscribe(orgID, id string) (Subscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, s := range d.subscriptions {
+		if s.ID == id && s.OrgID == orgID {
+			if d.store != nil {
+				if err := d.store.DeleteWebhook(id); err != nil {
+					return Subscription{}, err
+				}
+			}
+			d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+			return s, nil
+		}
+	}
+	return Subscription{}, fmt.Errorf("webhook %s: %w", id, models.ErrNotFound)
+}
+
+// DeadLetters lists the deliveries to an org's subscriptions that failed
+func (d *Dispatcher) DeadLetters(orgID string) []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	letters := []DeadLetter{}
+	for _, l := range d.deadLetters {
+		if l.OrgID == orgID {
+			letters = append(letters, l)
+		}
+	}
+	return letters
+}
+
+// Publish queues the event for every subscription that wants it, filling
+// in its ID and time if they are missing. It does not wait for delivery.
+// Deliveries that find the queue full, or the dispatcher closed, become
+// dead letters straight away.
+func (d *Dispatcher) Publish(event Event) error {
+	if event.ID == "" {
+		id, err := randomHex(16)
+		if err != nil {
+			return err
+		}
+		event.ID = id
+	}
+	if event.Time.IsZero() {
+		event.Time = d.Now().UTC()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	d.start.Do(func() {
+		d.queue = make(chan delivery, d.QueueSize)
+		for range max(d.Workers, 1) {
+			go d.work()
+		}
+	})
+
+	// Deliveries are queued under the lock that Close cancels under, so
+	// none is queued after the workers have drained the queue and stopped
+	type dropped struct {
+		subscription Subscription
+		err          error
+	}
+	var drops []dropped
+	d.mu.Lock()
+	for _, s := range d.subscriptions {
+		if !s.wants(event) {
+			continue
+		}
+		if err := d.ctx.Err(); err != nil {
+			drops = append(drops, dropped{s, err})
+			continue
+		}
+		d.inFlight.Add(1)
+		select {
+		case d.queue <- delivery{subscription: s, event: event, body: body}:
+		default:
+			d.inFlight.Done()
+			drops = append(drops, dropped{s, ErrQueueFull})
+		}
+	}
+	d.mu.Unlock()
+
+	for _, drop := range drops {
+		d.bury(drop.subscription, event, 0, drop.err)
+	}
+	return nil
+}
+
+// work makes queued deliveries until the dispatcher is closed, then
+// buries whatever is left in the queue
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case next := <-d.queue:
+			d.deliver(next.subscription, next.event, next.body)
+		case <-d.ctx.Done():
+			for {
+				select {
+				case next := <-d.queue:
+					d.bury(next.subscription, next.event, 0, d.ctx.Err())
+					d.inFlight.Done()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Wait blocks until every queued delivery has succeeded or become a dead
+// letter
+func (d *Dispatcher) Wait() {
+	d.inFlight.Wait()
+}
+
+// Close abandons pending retries and waits for deliveries in progress.
+// Abandoned deliveries become dead letters.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	d.cancel()
+	d.mu.Unlock()
+	d.inFlight.Wait()
+}
+
+// deliver sends the event to a subscription until it is accepted or the
+// attempts run out
+func (d *Dispatcher) deliver(s Subscription, event Event, body []byte) {
+	defer d.inFlight.Done()
+
+	var err error
+	attempts := 0
+	for attempts < d.MaxAttempts {
+		if attempts > 0 {
+			timer := time.NewTimer(d.backoff(attempts))
+			select {
+			case <-timer.C:
+			case <-d.ctx.Done():
+				timer.Stop()
+				d.bury(s, event, attempts, d.ctx.Err())
+				return
+			}
+		}
+
+		attempts++
+		if err = d.send(s, event, body); err == nil {
+			return
+		}
+	}
+	d.bury(s, event, attempts, err)
+}
+
+// backoff is the wait before retry number attempt
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > d.MaxDelay {
+		return d.MaxDelay
+	}
+	return delay
+}
+
+// send makes one delivery attempt. Any response other than a 2xx is a
+// failure.
+func (d *Dispatcher) send(s Subscription, event Event, body []byte) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(d.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(s.Secret, timestamp, body))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", res.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) bury(s Subscription, event Event, attempts int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deadLetters = append(d.deadLetters, DeadLetter{
+		SubscriptionID: s.ID,
+		OrgID:          s.OrgID,
+		URL:            s.URL,
+		Event:          event,
+		Attempts:       attempts,
+		LastError:      err.Error(),
+		FailedAt:       d.Now().UTC(),
+	})
+	if excess := len(d.deadLetters) - d.MaxDeadLetters; excess > 0 {
+		d.deadLetters = slices.Delete(d.deadLetters, 0, excess)
+	}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
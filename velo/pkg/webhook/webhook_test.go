@@ -0,0 +1,299 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/webhook"
+)
+
+// receiver records the deliveries it gets, failing the first failures of
+// them with a 500
+type receiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	failures   int
+	deliveries []delivery
+}
+
+type delivery struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func newReceiver(t *testing.T, failures int) *receiver {
+	r := &receiver{failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.deliveries = append(r.deliveries, delivery{header: req.Header, body: body, at: time.Now()})
+		if len(r.deliveries) <= r.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+func newDispatcher(t *testing.T) *webhook.Dispatcher {
+	d := webhook.NewDispatcher()
+	// The receivers listen on loopback
+	d.AllowPrivateAddresses = true
+	d.BaseDelay = 5 * time.Millisecond
+	d.MaxDelay = 50 * time.Millisecond
+	d.MaxAttempts = 3
+	t.Cleanup(d.Close)
+	return d
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("delivers signed events", func(t *testing.T) {
+		d := newDispatcher(t)
+		r := newReceiver(t, 0)
+		s, _ := d.Subscribe("acme", r.URL, nil)
+
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme", Data: map[string]string{"id": "item-1"}})
+		d.Wait()
+
+		got := r.received()
+		if len(got) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(got))
+		}
+		if !webhook.Verify(s.Secret, got[0].header, got[0].body) {
+			t.Error("delivery signature does not verify with the subscription's secret")
+		}
+		if webhook.Verify("not-the-secret", got[0].header, got[0].body) {
+			t.Error("delivery signature verifies with the wrong secret")
+		}
+		if got[0].header.Get(webhook.EventHeader) != "item.created" {
+			t.Errorf("got event header %q, want item.created", got[0].header.Get(webhook.EventHeader))
+		}
+
+		var event webhook.Event
+		json.Unmarshal(got[0].body, &event)
+		if event.ID == "" || event.ID != got[0].header.Get(webhook.DeliveryHeader) {
+			t.Errorf("got event ID %q and delivery header %q, want them set and equal", event.ID, got[0].header.Get(webhook.DeliveryHeader))
+		}
+		if event.Type != "item.created" || event.OrgID != "acme" || event.Time.IsZero() {
+			t.Errorf("got event %+v", event)
+		}
+	})
+
+	t.Run("sends only the events a subscription wants", func(t *testing.T) {
+		d := newDispatcher(t)
+		acme, globex, global, deletes := newReceiver(t, 0), newReceiver(t, 0), newReceiver(t, 0), newReceiver(t, 0)
+		d.Subscribe("acme", acme.URL, nil)
+		d.Subscribe("globex", globex.URL, nil)
+		d.Subscribe("", global.URL, nil)
+		d.Subscribe("acme", deletes.URL, []string{"item.deleted"})
+
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme"})
+		d.Publish(webhook.Event{Type: "user.created"})
+		d.Wait()
+
+		for name, c := range map[string]struct {
+			r    *receiver
+			want int
+		}{"acme": {acme, 1}, "globex": {globex, 0}, "global": {global, 2}, "deletes": {deletes, 0}} {
+			if got := len(c.r.received()); got != c.want {
+				t.Errorf("%s got %d deliveries, want %d", name, got, c.want)
+			}
+		}
+	})
+
+	t.Run("retries failed deliveries with growing delays", func(t *testing.T) {
+		d := newDispatcher(t)
+		r := newReceiver(t, 2)
+		d.Subscribe("acme", r.URL, nil)
+
+		d.Publish(webhook.Event{Type: "item.updated", OrgID: "acme"})
+		d.Wait()
+
+		got := r.received()
+		if len(got) != 3 {
+			t.Fatalf("got %d attempts, want 3", len(got))
+		}
+		if first, second := got[1].at.Sub(got[0].at), got[2].at.Sub(got[1].at); first < 5*time.Millisecond || second < 10*time.Millisecond {
+			t.Errorf("got retries after %v and %v, want at least 5ms and 10ms", first, second)
+		}
+		if letters := d.DeadLetters("acme"); len(letters) != 0 {
+			t.Errorf("got dead letters %+v, want none", letters)
+		}
+	})
+
+	t.Run("keeps undeliverable events as dead letters", func(t *testing.T) {
+		d := newDispatcher(t)
+		r := newReceiver(t, 10)
+		s, _ := d.Subscribe("acme", r.URL, nil)
+
+		d.Publish(webhook.Event{Type: "item.deleted", OrgID: "acme"})
+		d.Wait()
+
+		letters := d.DeadLetters("acme")
+		if len(letters) != 1 {
+			t.Fatalf("got %d dead letters, want 1", len(letters))
+		}
+		if letters[0].SubscriptionID != s.ID || letters[0].Attempts != 3 || letters[0].Event.Type != "item.deleted" || letters[0].LastError == "" {
+			t.Errorf("got dead letter %+v", letters[0])
+		}
+		if len(d.DeadLetters("globex")) != 0 {
+			t.Error("want dead letters to be kept per org")
+		}
+	})
+
+	t.Run("closing buries pending retries", func(t *testing.T) {
+		d := webhook.NewDispatcher()
+		d.AllowPrivateAddresses = true
+		d.BaseDelay = time.Hour
+		d.MaxDelay = time.Hour
+		r := newReceiver(t, 10)
+		d.Subscribe("acme", r.URL, nil)
+
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme"})
+		for len(r.received()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		d.Close()
+
+		if letters := d.DeadLetters("acme"); len(letters) != 1 || letters[0].Attempts != 1 {
+			t.Errorf("got dead letters %+v, want one after a single attempt", letters)
+		}
+	})
+
+	t.Run("drops deliveries the queue has no room for", func(t *testing.T) {
+		d := newDispatcher(t)
+		d.Workers = 1
+		d.QueueSize = 1
+		release := make(chan struct{})
+		r := newReceiver(t, 0)
+		blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		t.Cleanup(blocking.Close)
+		defer close(release)
+
+		d.Subscribe("acme", blocking.URL, []string{"item.created"})
+		d.Subscribe("acme", r.URL, []string{"item.updated"})
+
+		// The first delivery holds the only worker and the second fills
+		// the queue
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme"})
+		for len(d.DeadLetters("acme")) == 0 {
+			d.Publish(webhook.Event{Type: "item.updated", OrgID: "acme"})
+		}
+
+		letters := d.DeadLetters("acme")
+		if letters[0].LastError != webhook.ErrQueueFull.Error() || letters[0].Attempts != 0 {
+			t.Errorf("got dead letter %+v, want one dropped for a full queue", letters[0])
+		}
+	})
+
+	t.Run("keeps only the newest dead letters", func(t *testing.T) {
+		d := newDispatcher(t)
+		d.MaxAttempts = 1
+		d.MaxDeadLetters = 2
+		r := newReceiver(t, 10)
+		d.Subscribe("acme", r.URL, nil)
+
+		for _, id := range []string{"1", "2", "3"} {
+			d.Publish(webhook.Event{ID: id, Type: "item.created", OrgID: "acme"})
+			d.Wait()
+		}
+
+		letters := d.DeadLetters("acme")
+		if len(letters) != 2 || letters[0].Event.ID != "2" || letters[1].Event.ID != "3" {
+			t.Errorf("got dead letters %+v, want the last two", letters)
+		}
+	})
+
+	t.Run("unsubscribes", func(t *testing.T) {
+		d := newDispatcher(t)
+		r := newReceiver(t, 0)
+		s, _ := d.Subscribe("acme", r.URL, nil)
+
+		if _, err := d.Unsubscribe("globex", s.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("got %v unsubscribing from another org, want ErrNotFound", err)
+		}
+		if _, err := d.Unsubscribe("acme", s.ID); err != nil {
+			t.Fatalf("Unsubscribe returned error: %v", err)
+		}
+		if subs := d.Subscriptions("acme"); len(subs) != 0 {
+			t.Errorf("got subscriptions %+v, want none", subs)
+		}
+
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme"})
+		d.Wait()
+		if len(r.received()) != 0 {
+			t.Error("want no deliveries after unsubscribing")
+		}
+	})
+}
+
+func TestDispatcherPrivateAddresses(t *testing.T) {
+	t.Run("rejects subscriptions to private addresses", func(t *testing.T) {
+		d := webhook.NewDispatcher()
+		t.Cleanup(d.Close)
+
+		for _, url := range []string{
+			"http://127.0.0.1/hook",
+			"http://localhost:8080/hook",
+			"http://169.254.169.254/latest/meta-data",
+			"http://10.0.0.1/hook",
+			"http://192.168.1.1/hook",
+			"http://[::1]/hook",
+			"http://[::ffff:127.0.0.1]/hook",
+			"http://[fd00::1]/hook",
+			"http://0.0.0.0/hook",
+		} {
+			if _, err := d.Subscribe("acme", url, nil); !errors.Is(err, webhook.ErrPrivateAddress) {
+				t.Errorf("Subscribe(%s) returned %v, want ErrPrivateAddress", url, err)
+			}
+		}
+		if _, err := d.Subscribe("acme", "https://93.184.215.14/hook", nil); err != nil {
+			t.Errorf("Subscribe to a public address returned %v", err)
+		}
+	})
+
+	t.Run("refuses to connect to private addresses", func(t *testing.T) {
+		d := webhook.NewDispatcher()
+		d.MaxAttempts = 1
+		t.Cleanup(d.Close)
+		r := newReceiver(t, 0)
+
+		// Stands in for a public name that resolves to a private address
+		d.AllowPrivateAddresses = true
+		d.Subscribe("acme", r.URL, nil)
+		d.AllowPrivateAddresses = false
+
+		d.Publish(webhook.Event{Type: "item.created", OrgID: "acme"})
+		d.Wait()
+
+		if len(r.received()) != 0 {
+			t.Error("want no deliveries to a private address")
+		}
+		letters := d.DeadLetters("acme")
+		if len(letters) != 1 || !strings.Contains(letters[0].LastError, webhook.ErrPrivateAddress.Error()) {
+			t.Errorf("got dead letters %+v, want one refused for its address", letters)
+		}
+	})
+}
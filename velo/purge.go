@@ -0,0 +1,44 @@
+package velo
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Purger permanently removes items that have been soft-deleted for longer
+// than Retention
+type Purger struct {
+	Store     models.ItemStore
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// PurgeOnce removes every item deleted before now minus the retention
+func (p *Purger) PurgeOnce(now time.Time) (int, error) {
+	return p.Store.PurgeItems(now.Add(-p.Retention))
+}
+
+// Run purges once per Interval until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := p.PurgeOnce(now)
+			if err != nil {
+				log.Printf("purging deleted items failed: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("purged %d deleted items", purged)
+			}
+		}
+	}
+}
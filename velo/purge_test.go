@@ -0,0 +1,34 @@
+package velo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo"
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestPurger(t *testing.T) {
+	store := testutils.NewStubAppStore()
+	now := time.Now()
+
+	old, _ := store.CreateItem(models.CreateItemInput{Name: "deleted long ago"})
+	recent, _ := store.CreateItem(models.CreateItemInput{Name: "deleted recently"})
+	live, _ := store.CreateItem(models.CreateItemInput{Name: "never deleted"})
+	store.SoftDeleteItem(old.ID, now.Add(-48*time.Hour))
+	store.SoftDeleteItem(recent.ID, now.Add(-time.Hour))
+
+	purger := &velo.Purger{Store: store, Retention: 24 * time.Hour}
+
+	purged, err := purger.PurgeOnce(now)
+	if err != nil {
+		t.Fatalf("PurgeOnce returned error: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d items, want 1", purged)
+	}
+
+	items, _ := store.GetItems()
+	testutils.AssertContainsIDs(t, items, recent.ID, live.ID)
+}
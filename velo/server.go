@@ -2,9 +2,11 @@ package velo
 
 import (
 	"net/http"
+	"slices"
 
 	"github.com/espennoreng/learn-go-with-tests/velo/models"
 	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/metrics"
 )
 
 // AppServer is the main server that handles HTTP requests
@@ -13,19 +15,32 @@ type AppServer struct {
 	http.Handler
 }
 
-// NewAppServer creates and configures a new server instance
-func NewAppServer(store models.AppStore) *AppServer {
+// NewAppServer creates and configures a new server instance. The options
+// configure the API handler.
+func NewAppServer(store models.AppStore, opts ...api.Option) *AppServer {
 	s := &AppServer{
 		store: store,
 	}
 
-	// Create API handlers with the provided store
-	apiHandler := api.NewHandler(store)
+	// Time every store operation and every request, and expose both at
+	// GET /metrics
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTPMetrics(registry)
+	store = metrics.NewStoreMetrics(registry).Instrument(store)
+	opts = append(slices.Clip(opts), api.WithMetrics(registry))
 
-	// Configure routing
+	// Create API handlers with the provided store, behind session
+	// authentication and role checks
+	apiHandler := api.RequireSession(store, api.Authorize(api.Policies, api.NewHandler(store, opts...)))
+
+	// Mount every API route, labelling its metrics with the route's pattern;
+	// anything else still reaches the API so that it answers with a 404 or
+	// 405 problem
 	router := http.NewServeMux()
-	router.Handle("/items/", apiHandler)
-	router.Handle("/items", apiHandler)
+	for _, route := range api.Routes {
+		router.Handle(route.String(), httpMetrics.Instrument(route.String(), apiHandler))
+	}
+	router.Handle("/", httpMetrics.Instrument("unmatched", apiHandler))
 
 	s.Handler = router
 	return s
@@ -0,0 +1,75 @@
+package velo_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/api"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/auth"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestAppServerRoutes(t *testing.T) {
+	auth.PasswordIterations = 1000
+	server := velo.NewAppServer(testutils.NewStubAppStore())
+
+	body, _ := json.Marshal(api.CreateUserRequest{Name: "Per", Password: "hunter2hunter2"})
+	response := testutils.MakeRequest(t, server, http.MethodPost, "/users", body)
+	testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+	body, _ = json.Marshal(api.LoginRequest{Name: "Per", Password: "hunter2hunter2"})
+	response = testutils.MakeRequest(t, server, http.MethodPost, "/sessions", body)
+	testutils.AssertStatus(t, response.Code, http.StatusCreated)
+
+	var login api.LoginResponse
+	json.NewDecoder(response.Body).Decode(&login)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+login.Token)
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("serves users and sessions", func(t *testing.T) {
+		testutils.AssertStatus(t, request(http.MethodGet, "/users/"+login.Session.UserID).Code, http.StatusOK)
+		testutils.AssertStatus(t, request(http.MethodGet, "/sessions/"+login.Session.ID).Code, http.StatusOK)
+	})
+
+	t.Run("unknown paths get a 404 problem", func(t *testing.T) {
+		response := request(http.MethodGet, "/unknown")
+		testutils.AssertStatus(t, response.Code, http.StatusNotFound)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+	})
+
+	t.Run("wrong methods get a 405 problem", func(t *testing.T) {
+		response := request(http.MethodPut, "/sessions/"+login.Session.ID)
+		testutils.AssertStatus(t, response.Code, http.StatusMethodNotAllowed)
+		testutils.AssertContentType(t, response, api.ContentTypeProblemJSON)
+		if response.Header().Get("Allow") == "" {
+			t.Error("expected an Allow header")
+		}
+	})
+
+	t.Run("counts requests and store operations at /metrics", func(t *testing.T) {
+		request(http.MethodGet, "/unknown")
+		response := request(http.MethodGet, "/metrics")
+		testutils.AssertStatus(t, response.Code, http.StatusOK)
+
+		got := response.Body.String()
+		for _, want := range []string{
+			`velo_http_requests_total{route="POST /sessions",status="201"} 1`,
+			`velo_http_requests_total{route="unmatched",status="404"}`,
+			`velo_store_operation_duration_seconds_count{operation="GetSessionByToken"}`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("got\n%s\nwant it to contain %s", got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,909 @@
+// Package storetest holds a conformance suite that every models.AppStore
+// implementation is expected to pass.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+)
+
+// Factory returns a new, empty store. It is called once per subtest.
+type Factory func() models.AppStore
+
+// Run exercises the AppStore contract against stores built by newStore.
+func Run(t *testing.T, newStore Factory) {
+	t.Run("items", func(t *testing.T) { testItems(t, newStore) })
+	t.Run("item queries", func(t *testing.T) { testItemQueries(t, newStore) })
+	t.Run("item search", func(t *testing.T) { testItemSearch(t, newStore) })
+	t.Run("users", func(t *testing.T) { testUsers(t, newStore) })
+	t.Run("sessions", func(t *testing.T) { testSessions(t, newStore) })
+	t.Run("orgs", func(t *testing.T) { testOrgs(t, newStore) })
+	t.Run("concurrent access", func(t *testing.T) { testConcurrentAccess(t, newStore) })
+}
+
+func testItems(t *testing.T, newStore Factory) {
+	t.Run("create then get", func(t *testing.T) {
+		s := newStore()
+
+		created := mustCreateItem(t, s, models.CreateItemInput{
+			Name:       "an item",
+			ExternalID: "ext-1",
+			OrgID:      "org-1",
+			CreatedBy:  "user-1",
+		})
+		if created.ID == "" {
+			t.Fatal("expected CreateItem to generate an ID")
+		}
+		if created.CreatedAt.IsZero() {
+			t.Error("expected CreateItem to stamp CreatedAt")
+		}
+		if !created.UpdatedAt.Equal(created.CreatedAt) {
+			t.Errorf("expected a new item's UpdatedAt %v to equal CreatedAt %v", created.UpdatedAt, created.CreatedAt)
+		}
+
+		got, err := s.GetItem(created.ID)
+		if err != nil {
+			t.Fatalf("GetItem(%q) returned error: %v", created.ID, err)
+		}
+		if got.Name != "an item" || got.ExternalID != "ext-1" || got.OrgID != "org-1" || got.CreatedBy != "user-1" {
+			t.Errorf("GetItem returned %+v, which does not match what was created", got)
+		}
+	})
+
+	t.Run("generates unique IDs", func(t *testing.T) {
+		s := newStore()
+
+		first := mustCreateItem(t, s, models.CreateItemInput{Name: "first"})
+		second := mustCreateItem(t, s, models.CreateItemInput{Name: "second"})
+		if first.ID == second.ID {
+			t.Errorf("expected unique IDs, both were %q", first.ID)
+		}
+	})
+
+	t.Run("lists every item", func(t *testing.T) {
+		s := newStore()
+
+		items, err := s.GetItems()
+		if err != nil {
+			t.Fatalf("GetItems returned error: %v", err)
+		}
+		if len(items) != 0 {
+			t.Fatalf("expected a new store to be empty, got %d items", len(items))
+		}
+
+		first := mustCreateItem(t, s, models.CreateItemInput{Name: "first"})
+		second := mustCreateItem(t, s, models.CreateItemInput{Name: "second"})
+
+		items, err = s.GetItems()
+		if err != nil {
+			t.Fatalf("GetItems returned error: %v", err)
+		}
+		assertItemIDs(t, items, first.ID, second.ID)
+	})
+
+	t.Run("update changes only the given fields", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "before", OrgID: "org-1"})
+
+		updated, err := s.UpdateItem(created.ID, models.ItemUpdate{Name: ptr("after")})
+		if err != nil {
+			t.Fatalf("UpdateItem returned error: %v", err)
+		}
+		if updated.Name != "after" {
+			t.Errorf("got name %q, want %q", updated.Name, "after")
+		}
+		if updated.ID != created.ID {
+			t.Errorf("ID changed from %q to %q", created.ID, updated.ID)
+		}
+		if updated.OrgID != "org-1" {
+			t.Errorf("OrgID changed from %q to %q", "org-1", updated.OrgID)
+		}
+		if !updated.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("CreatedAt changed from %v to %v", created.CreatedAt, updated.CreatedAt)
+		}
+		if updated.UpdatedAt.Before(created.UpdatedAt) {
+			t.Errorf("UpdatedAt went backwards from %v to %v", created.UpdatedAt, updated.UpdatedAt)
+		}
+
+		got, _ := s.GetItem(created.ID)
+		if got.Name != "after" {
+			t.Errorf("update was not persisted, got name %q", got.Name)
+		}
+	})
+
+	t.Run("every change bumps the version", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "an item"})
+		if created.Version != 1 {
+			t.Fatalf("got version %d for a new item, want 1", created.Version)
+		}
+
+		updated, _ := s.UpdateItem(created.ID, models.ItemUpdate{Name: ptr("renamed")})
+		deleted, _ := s.SoftDeleteItem(created.ID, time.Now())
+		restored, _ := s.RestoreItem(created.ID)
+		if updated.Version != 2 || deleted.Version != 3 || restored.Version != 4 {
+			t.Errorf("got versions %d, %d, %d after update, delete and restore, want 2, 3, 4",
+				updated.Version, deleted.Version, restored.Version)
+		}
+
+		got, _ := s.GetItem(created.ID)
+		if got.Version != 4 {
+			t.Errorf("version was not persisted, got %d", got.Version)
+		}
+	})
+
+	t.Run("conditional update checks the version", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "an item"})
+
+		updated, err := s.UpdateItem(created.ID, models.ItemUpdate{Name: ptr("first"), IfVersion: 1})
+		if err != nil {
+			t.Fatalf("UpdateItem returned error for the current version: %v", err)
+		}
+
+		_, err = s.UpdateItem(created.ID, models.ItemUpdate{Name: ptr("second"), IfVersion: 1})
+		if !errors.Is(err, models.ErrVersionMismatch) {
+			t.Errorf("expected ErrVersionMismatch for a stale version, got %v", err)
+		}
+
+		got, _ := s.GetItem(created.ID)
+		if got.Name != "first" || got.Version != updated.Version {
+			t.Errorf("a rejected update changed the item: %+v", got)
+		}
+	})
+
+	t.Run("update can clear a field", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "an item", ExternalID: "ext-1"})
+
+		updated, err := s.UpdateItem(created.ID, models.ItemUpdate{ExternalID: ptr("")})
+		if err != nil {
+			t.Fatalf("UpdateItem returned error: %v", err)
+		}
+		if updated.ExternalID != "" {
+			t.Errorf("got external ID %q, want it cleared", updated.ExternalID)
+		}
+		if updated.Name != "an item" {
+			t.Errorf("got name %q, want %q", updated.Name, "an item")
+		}
+	})
+
+	t.Run("external IDs are unique within an org", func(t *testing.T) {
+		s := newStore()
+		first := mustCreateItem(t, s, models.CreateItemInput{Name: "first", ExternalID: "ext-1", OrgID: "org-1"})
+		other := mustCreateItem(t, s, models.CreateItemInput{Name: "other org", ExternalID: "ext-1", OrgID: "org-2"})
+		mustCreateItem(t, s, models.CreateItemInput{Name: "no external ID", OrgID: "org-1"})
+		mustCreateItem(t, s, models.CreateItemInput{Name: "no external ID either", OrgID: "org-1"})
+
+		if _, err := s.CreateItem(models.CreateItemInput{Name: "duplicate", ExternalID: "ext-1", OrgID: "org-1"}); !errors.Is(err, models.ErrConflict) {
+			t.Errorf("expected CreateItem to return ErrConflict for a taken external ID, got %v", err)
+		}
+		if _, err := s.UpdateItem(other.ID, models.ItemUpdate{OrgID: ptr("org-1")}); !errors.Is(err, models.ErrConflict) {
+			t.Errorf("expected UpdateItem to return ErrConflict when moving onto a taken external ID, got %v", err)
+		}
+		if _, err := s.UpdateItem(first.ID, models.ItemUpdate{ExternalID: ptr("ext-1"), Name: ptr("renamed")}); err != nil {
+			t.Errorf("UpdateItem returned error for keeping the item's own external ID: %v", err)
+		}
+
+		page, err := s.QueryItems(models.ItemQuery{ExternalID: "ext-1", OrgID: "org-1"})
+		if err != nil {
+			t.Fatalf("QueryItems returned error: %v", err)
+		}
+		assertItemIDs(t, page.Items, first.ID)
+	})
+
+	t.Run("empty update changes nothing", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "unchanged"})
+
+		updated, err := s.UpdateItem(created.ID, models.ItemUpdate{})
+		if err != nil {
+			t.Fatalf("UpdateItem returned error: %v", err)
+		}
+		if updated.Name != "unchanged" {
+			t.Errorf("got name %q, want %q", updated.Name, "unchanged")
+		}
+	})
+
+	t.Run("delete removes the item", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "doomed"})
+
+		if err := s.DeleteItem(created.ID); err != nil {
+			t.Fatalf("DeleteItem returned error: %v", err)
+		}
+		if _, err := s.GetItem(created.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetItem to return ErrNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("soft delete hides the item from listings", func(t *testing.T) {
+		s := newStore()
+		deleted := mustCreateItem(t, s, models.CreateItemInput{Name: "deleted"})
+		kept := mustCreateItem(t, s, models.CreateItemInput{Name: "kept"})
+
+		got, err := s.SoftDeleteItem(deleted.ID, time.Now())
+		if err != nil {
+			t.Fatalf("SoftDeleteItem returned error: %v", err)
+		}
+		if !got.IsDeleted() {
+			t.Error("expected SoftDeleteItem to stamp DeletedAt")
+		}
+
+		page, _ := s.QueryItems(models.ItemQuery{})
+		assertItemIDs(t, page.Items, kept.ID)
+
+		page, _ = s.QueryItems(models.ItemQuery{IncludeDeleted: true})
+		assertItemIDs(t, page.Items, deleted.ID, kept.ID)
+
+		stored, err := s.GetItem(deleted.ID)
+		if err != nil {
+			t.Fatalf("expected GetItem to still find a soft-deleted item, got %v", err)
+		}
+		if !stored.IsDeleted() {
+			t.Error("expected the stored item to be marked deleted")
+		}
+
+		if _, err := s.SoftDeleteItem(deleted.ID, time.Now()); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected deleting twice to return ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("restore undoes a soft delete", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "restored"})
+		s.SoftDeleteItem(created.ID, time.Now())
+
+		restored, err := s.RestoreItem(created.ID)
+		if err != nil {
+			t.Fatalf("RestoreItem returned error: %v", err)
+		}
+		if restored.IsDeleted() {
+			t.Error("expected RestoreItem to clear DeletedAt")
+		}
+
+		page, _ := s.QueryItems(models.ItemQuery{})
+		assertItemIDs(t, page.Items, created.ID)
+	})
+
+	t.Run("restoring a live item is a conflict", func(t *testing.T) {
+		s := newStore()
+		created := mustCreateItem(t, s, models.CreateItemInput{Name: "live"})
+
+		if _, err := s.RestoreItem(created.ID); !errors.Is(err, models.ErrConflict) {
+			t.Errorf("expected RestoreItem to return ErrConflict for a live item, got %v", err)
+		}
+		if got, _ := s.GetItem(created.ID); got.Version != created.Version || !got.UpdatedAt.Equal(created.UpdatedAt) {
+			t.Errorf("got %+v, want the item unchanged", got)
+		}
+	})
+
+	t.Run("purge removes items deleted before the cutoff", func(t *testing.T) {
+		s := newStore()
+		now := time.Now()
+		old := mustCreateItem(t, s, models.CreateItemInput{Name: "old"})
+		recent := mustCreateItem(t, s, models.CreateItemInput{Name: "recent"})
+		live := mustCreateItem(t, s, models.CreateItemInput{Name: "live"})
+		s.SoftDeleteItem(old.ID, now.Add(-2*time.Hour))
+		s.SoftDeleteItem(recent.ID, now.Add(-time.Minute))
+
+		purged, err := s.PurgeItems(now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("PurgeItems returned error: %v", err)
+		}
+		if purged != 1 {
+			t.Errorf("purged %d items, want 1", purged)
+		}
+		if _, err := s.GetItem(old.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected purged item to be gone, got %v", err)
+		}
+
+		page, _ := s.QueryItems(models.ItemQuery{IncludeDeleted: true})
+		assertItemIDs(t, page.Items, recent.ID, live.ID)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStore()
+
+		if _, err := s.SoftDeleteItem("missing", time.Now()); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected SoftDeleteItem to return ErrNotFound for a missing item, got %v", err)
+		}
+		if _, err := s.RestoreItem("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected RestoreItem to return ErrNotFound for a missing item, got %v", err)
+		}
+		if _, err := s.GetItem("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetItem to return ErrNotFound for a missing item, got %v", err)
+		}
+		if _, err := s.UpdateItem("missing", models.ItemUpdate{Name: ptr("x")}); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected UpdateItem to return ErrNotFound for a missing item, got %v", err)
+		}
+		if err := s.DeleteItem("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected DeleteItem to return ErrNotFound for a missing item, got %v", err)
+		}
+	})
+}
+
+func testItemQueries(t *testing.T, newStore Factory) {
+	// seed creates items in two orgs with names that do not follow creation order
+	seed := func(t *testing.T) (models.AppStore, map[string]models.Item) {
+		s := newStore()
+		byName := map[string]models.Item{}
+		for _, in := range []models.CreateItemInput{
+			{Name: "charlie", OrgID: "org-1", CreatedBy: "ann"},
+			{Name: "alpha", OrgID: "org-1", CreatedBy: "bob"},
+			{Name: "echo", OrgID: "org-2", CreatedBy: "ann"},
+			{Name: "bravo", OrgID: "org-1", CreatedBy: "ann"},
+			{Name: "delta", OrgID: "org-1", CreatedBy: "bob"},
+		} {
+			byName[in.Name] = mustCreateItem(t, s, in)
+		}
+		if _, err := s.UpdateItem(byName["delta"].ID, models.ItemUpdate{IsActive: ptr(false)}); err != nil {
+			t.Fatalf("UpdateItem returned error: %v", err)
+		}
+		return s, byName
+	}
+
+	names := func(items []models.Item) []string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query models.ItemQuery
+		want  []string
+	}{
+		{"filters by org", models.ItemQuery{OrgID: "org-1", SortBy: models.SortByName}, []string{"alpha", "bravo", "charlie", "delta"}},
+		{"filters by creator", models.ItemQuery{CreatedBy: "ann", SortBy: models.SortByName}, []string{"bravo", "charlie", "echo"}},
+		{"filters by active flag", models.ItemQuery{OrgID: "org-1", IsActive: ptr(false)}, []string{"delta"}},
+		{"sorts by name descending", models.ItemQuery{SortBy: models.SortByName, Descending: true}, []string{"echo", "delta", "charlie", "bravo", "alpha"}},
+		{"limits the page", models.ItemQuery{SortBy: models.SortByName, Limit: 2}, []string{"alpha", "bravo"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := seed(t)
+
+			page, err := s.QueryItems(tc.query)
+			if err != nil {
+				t.Fatalf("QueryItems returned error: %v", err)
+			}
+			if got := names(page.Items); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("pages through every item exactly once", func(t *testing.T) {
+		for _, sort := range []models.ItemSort{models.SortByCreatedAt, models.SortByName} {
+			for _, descending := range []bool{false, true} {
+				s, _ := seed(t)
+				query := models.ItemQuery{SortBy: sort, Descending: descending, Limit: 2}
+
+				var got []models.Item
+				for pages := 0; ; pages++ {
+					if pages > 5 {
+						t.Fatalf("sort %s: pagination did not terminate", sort)
+					}
+					page, err := s.QueryItems(query)
+					if err != nil {
+						t.Fatalf("QueryItems returned error: %v", err)
+					}
+					got = append(got, page.Items...)
+					if !page.HasMore {
+						break
+					}
+					cursor := models.CursorFor(page.Items[len(page.Items)-1])
+					query.After = &cursor
+				}
+
+				all, _ := s.QueryItems(models.ItemQuery{SortBy: sort, Descending: descending})
+				if !slices.Equal(names(got), names(all.Items)) {
+					t.Errorf("sort %s descending=%v: paged %v, want %v", sort, descending, names(got), names(all.Items))
+				}
+			}
+		}
+	})
+}
+
+func testUsers(t *testing.T, newStore Factory) {
+	t.Run("create, get, update and delete", func(t *testing.T) {
+		s := newStore()
+
+		created, err := s.CreateUser(models.CreateUserInput{Name: "Per"})
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+		if created.ID == "" {
+			t.Fatal("expected CreateUser to generate an ID")
+		}
+		if created.CreatedAt.IsZero() {
+			t.Error("expected CreateUser to stamp CreatedAt")
+		}
+
+		got, err := s.GetUser(created.ID)
+		if err != nil {
+			t.Fatalf("GetUser returned error: %v", err)
+		}
+		if got.Name != "Per" {
+			t.Errorf("got name %q, want %q", got.Name, "Per")
+		}
+
+		updated, err := s.UpdateUser(created.ID, map[string]any{"Name": "Pål"})
+		if err != nil {
+			t.Fatalf("UpdateUser returned error: %v", err)
+		}
+		if updated.Name != "Pål" || updated.ID != created.ID {
+			t.Errorf("UpdateUser returned %+v", updated)
+		}
+
+		if err := s.DeleteUser(created.ID); err != nil {
+			t.Fatalf("DeleteUser returned error: %v", err)
+		}
+		if _, err := s.GetUser(created.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetUser to return ErrNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("looks users up by name", func(t *testing.T) {
+		s := newStore()
+		created, err := s.CreateUser(models.CreateUserInput{Name: "Per", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+
+		got, err := s.GetUserByName("Per")
+		if err != nil {
+			t.Fatalf("GetUserByName returned error: %v", err)
+		}
+		if got.ID != created.ID || got.PasswordHash != "hash" {
+			t.Errorf("GetUserByName returned %+v, want %+v", got, created)
+		}
+		if _, err := s.GetUserByName("Pål"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetUserByName to return ErrNotFound for a missing user, got %v", err)
+		}
+	})
+
+	t.Run("names are unique", func(t *testing.T) {
+		s := newStore()
+		if _, err := s.CreateUser(models.CreateUserInput{Name: "Per"}); err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+		other, err := s.CreateUser(models.CreateUserInput{Name: "Pål"})
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+
+		if _, err := s.CreateUser(models.CreateUserInput{Name: "Per"}); !errors.Is(err, models.ErrConflict) {
+			t.Errorf("expected CreateUser to return ErrConflict for a taken name, got %v", err)
+		}
+		if _, err := s.UpdateUser(other.ID, map[string]any{"Name": "Per"}); !errors.Is(err, models.ErrConflict) {
+			t.Errorf("expected UpdateUser to return ErrConflict for a taken name, got %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStore()
+
+		if _, err := s.GetUser("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetUser to return ErrNotFound for a missing user, got %v", err)
+		}
+		if _, err := s.UpdateUser("missing", map[string]any{"Name": "x"}); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected UpdateUser to return ErrNotFound for a missing user, got %v", err)
+		}
+		if err := s.DeleteUser("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected DeleteUser to return ErrNotFound for a missing user, got %v", err)
+		}
+	})
+}
+
+func testSessions(t *testing.T, newStore Factory) {
+	t.Run("create, get and delete", func(t *testing.T) {
+		s := newStore()
+		user, err := s.CreateUser(models.CreateUserInput{Name: "Per"})
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+
+		created, err := s.CreateSession(models.CreateSessionInput{UserID: user.ID})
+		if err != nil {
+			t.Fatalf("CreateSession returned error: %v", err)
+		}
+		if created.ID == "" {
+			t.Fatal("expected CreateSession to generate an ID")
+		}
+
+		got, err := s.GetSession(created.ID)
+		if err != nil {
+			t.Fatalf("GetSession returned error: %v", err)
+		}
+		if got.UserID != user.ID {
+			t.Errorf("got user ID %q, want %q", got.UserID, user.ID)
+		}
+
+		if err := s.DeleteSession(created.ID); err != nil {
+			t.Fatalf("DeleteSession returned error: %v", err)
+		}
+		if _, err := s.GetSession(created.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetSession to return ErrNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("looks sessions up by token hash", func(t *testing.T) {
+		s := newStore()
+		user, _ := s.CreateUser(models.CreateUserInput{Name: "Per"})
+		expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+		created, err := s.CreateSession(models.CreateSessionInput{UserID: user.ID, TokenHash: "token-hash", ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateSession returned error: %v", err)
+		}
+
+		got, err := s.GetSessionByToken("token-hash")
+		if err != nil {
+			t.Fatalf("GetSessionByToken returned error: %v", err)
+		}
+		if got.ID != created.ID || got.UserID != user.ID {
+			t.Errorf("GetSessionByToken returned %+v, want %+v", got, created)
+		}
+		if !got.ExpiresAt.Equal(expires) {
+			t.Errorf("got ExpiresAt %v, want %v", got.ExpiresAt, expires)
+		}
+
+		if _, err := s.GetSessionByToken("other-hash"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetSessionByToken to return ErrNotFound for an unknown token, got %v", err)
+		}
+		if _, err := s.GetSessionByToken(""); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetSessionByToken to return ErrNotFound for an empty token, got %v", err)
+		}
+	})
+
+	t.Run("requires an existing user", func(t *testing.T) {
+		s := newStore()
+
+		if _, err := s.CreateSession(models.CreateSessionInput{UserID: "missing"}); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected CreateSession to return ErrNotFound for a missing user, got %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStore()
+
+		if _, err := s.GetSession("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetSession to return ErrNotFound for a missing session, got %v", err)
+		}
+		if err := s.DeleteSession("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected DeleteSession to return ErrNotFound for a missing session, got %v", err)
+		}
+	})
+}
+
+func testOrgs(t *testing.T, newStore Factory) {
+	t.Run("create, get, list and delete", func(t *testing.T) {
+		s := newStore()
+
+		created, err := s.CreateOrg(models.CreateOrgInput{Name: "Acme"})
+		if err != nil {
+			t.Fatalf("CreateOrg returned error: %v", err)
+		}
+		if created.ID == "" || created.CreatedAt.IsZero() {
+			t.Fatalf("expected CreateOrg to generate an ID and CreatedAt, got %+v", created)
+		}
+
+		got, err := s.GetOrg(created.ID)
+		if err != nil {
+			t.Fatalf("GetOrg returned error: %v", err)
+		}
+		if got.Name != "Acme" {
+			t.Errorf("got name %q, want %q", got.Name, "Acme")
+		}
+
+		orgs, err := s.GetOrgs()
+		if err != nil {
+			t.Fatalf("GetOrgs returned error: %v", err)
+		}
+		if len(orgs) != 1 || orgs[0].ID != created.ID {
+			t.Errorf("GetOrgs returned %+v, want only %s", orgs, created.ID)
+		}
+
+		if err := s.DeleteOrg(created.ID); err != nil {
+			t.Fatalf("DeleteOrg returned error: %v", err)
+		}
+		if _, err := s.GetOrg(created.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetOrg to return ErrNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("orgs with items", func(t *testing.T) {
+		s := newStore()
+		org, _ := s.CreateOrg(models.CreateOrgInput{Name: "Acme"})
+		item, _ := s.CreateItem(models.CreateItemInput{Name: "bike", OrgID: org.ID})
+
+		if err := s.DeleteOrg(org.ID); !errors.Is(err, models.ErrConflict) {
+			t.Fatalf("expected DeleteOrg to return ErrConflict while the org has items, got %v", err)
+		}
+		if _, err := s.GetOrg(org.ID); err != nil {
+			t.Errorf("expected the org to be kept, got %v", err)
+		}
+
+		if _, err := s.SoftDeleteItem(item.ID, time.Now()); err != nil {
+			t.Fatalf("SoftDeleteItem returned error: %v", err)
+		}
+		if err := s.DeleteOrg(org.ID); err != nil {
+			t.Fatalf("expected DeleteOrg to succeed once the items are deleted, got %v", err)
+		}
+		if _, err := s.GetItem(item.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected the org's deleted items to be purged with it, got %v", err)
+		}
+		if _, err := s.RestoreItem(item.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected restoring an item of a deleted org to return ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("members", func(t *testing.T) {
+		s := newStore()
+		acme, _ := s.CreateOrg(models.CreateOrgInput{Name: "Acme"})
+		globex, _ := s.CreateOrg(models.CreateOrgInput{Name: "Globex"})
+		per, _ := s.CreateUser(models.CreateUserInput{Name: "Per"})
+		pal, _ := s.CreateUser(models.CreateUserInput{Name: "Pål"})
+
+		for _, m := range []struct{ org, user string }{{acme.ID, per.ID}, {acme.ID, pal.ID}, {globex.ID, per.ID}} {
+			membership, err := s.AddMember(m.org, m.user, models.RoleViewer)
+			if err != nil {
+				t.Fatalf("AddMember returned error: %v", err)
+			}
+			if membership.OrgID != m.org || membership.UserID != m.user || membership.Role != models.RoleViewer {
+				t.Errorf("AddMember returned %+v", membership)
+			}
+		}
+
+		promoted, err := s.AddMember(acme.ID, per.ID, models.RoleEditor)
+		if err != nil {
+			t.Fatalf("expected adding an existing member to succeed, got %v", err)
+		}
+		if promoted.Role != models.RoleEditor {
+			t.Errorf("expected adding an existing member to change their role, got %+v", promoted)
+		}
+
+		members, err := s.GetMembers(acme.ID)
+		if err != nil {
+			t.Fatalf("GetMembers returned error: %v", err)
+		}
+		if len(members) != 2 {
+			t.Errorf("got %d members of %s, want 2: %+v", len(members), acme.ID, members)
+		}
+
+		memberships, err := s.GetMemberships(per.ID)
+		if err != nil {
+			t.Fatalf("GetMemberships returned error: %v", err)
+		}
+		if len(memberships) != 2 {
+			t.Errorf("got %d memberships for %s, want 2: %+v", len(memberships), per.ID, memberships)
+		}
+
+		if err := s.RemoveMember(acme.ID, per.ID); err != nil {
+			t.Fatalf("RemoveMember returned error: %v", err)
+		}
+		if err := s.RemoveMember(acme.ID, per.ID); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected RemoveMember to return ErrNotFound for a non-member, got %v", err)
+		}
+
+		if err := s.DeleteOrg(globex.ID); err != nil {
+			t.Fatalf("DeleteOrg returned error: %v", err)
+		}
+		memberships, _ = s.GetMemberships(per.ID)
+		if len(memberships) != 0 {
+			t.Errorf("expected deleting orgs to remove their memberships, got %+v", memberships)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s := newStore()
+		org, _ := s.CreateOrg(models.CreateOrgInput{Name: "Acme"})
+		user, _ := s.CreateUser(models.CreateUserInput{Name: "Per"})
+
+		if _, err := s.GetOrg("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetOrg to return ErrNotFound for a missing org, got %v", err)
+		}
+		if err := s.DeleteOrg("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected DeleteOrg to return ErrNotFound for a missing org, got %v", err)
+		}
+		if _, err := s.GetMembers("missing"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected GetMembers to return ErrNotFound for a missing org, got %v", err)
+		}
+		if _, err := s.AddMember("missing", user.ID, models.RoleViewer); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected AddMember to return ErrNotFound for a missing org, got %v", err)
+		}
+		if _, err := s.AddMember(org.ID, "missing", models.RoleViewer); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("expected AddMember to return ErrNotFound for a missing user, got %v", err)
+		}
+	})
+
+	t.Run("remembers admins", func(t *testing.T) {
+		s := newStore()
+		admin, err := s.CreateUser(models.CreateUserInput{Name: "root", IsAdmin: true})
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+
+		got, err := s.GetUser(admin.ID)
+		if err != nil {
+			t.Fatalf("GetUser returned error: %v", err)
+		}
+		if !got.IsAdmin {
+			t.Error("expected the user to be an admin")
+		}
+	})
+}
+
+func testConcurrentAccess(t *testing.T, newStore Factory) {
+	const workers = 20
+
+	s := newStore()
+	seed := mustCreateItem(t, s, models.CreateItemInput{Name: "shared"})
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.CreateItem(models.CreateItemInput{Name: fmt.Sprintf("item %d", i)}); err != nil {
+				t.Errorf("CreateItem returned error: %v", err)
+			}
+			if _, err := s.UpdateItem(seed.ID, models.ItemUpdate{Name: ptr(fmt.Sprintf("name %d", i))}); err != nil {
+				t.Errorf("UpdateItem returned error: %v", err)
+			}
+			if _, err := s.GetItems(); err != nil {
+				t.Errorf("GetItems returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	items, err := s.GetItems()
+	if err != nil {
+		t.Fatalf("GetItems returned error: %v", err)
+	}
+	if len(items) != workers+1 {
+		t.Errorf("got %d items, want %d", len(items), workers+1)
+	}
+}
+
+func testItemSearch(t *testing.T, newStore Factory) {
+	search := func(t *testing.T, s models.AppStore, query models.ItemSearch) []string {
+		t.Helper()
+		results, err := s.SearchItems(query)
+		if err != nil {
+			t.Fatalf("SearchItems returned error: %v", err)
+		}
+		ids := []string{}
+		for _, result := range results {
+			ids = append(ids, result.Item.ID)
+		}
+		return ids
+	}
+
+	t.Run("matches prefixes and ranks exact matches first", func(t *testing.T) {
+		s := newStore()
+		prefix := mustCreateItem(t, s, models.CreateItemInput{Name: "Bluetooth speaker"})
+		exact := mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug"})
+		mustCreateItem(t, s, models.CreateItemInput{Name: "Red mug"})
+
+		got := search(t, s, models.ItemSearch{Text: "blue"})
+		if want := []string{exact.ID, prefix.ID}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("matches every word", func(t *testing.T) {
+		s := newStore()
+		both := mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug"})
+		mustCreateItem(t, s, models.CreateItemInput{Name: "Blue plate"})
+
+		got := search(t, s, models.ItemSearch{Text: "MUG blu"})
+		if want := []string{both.ID}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("is scoped by org", func(t *testing.T) {
+		s := newStore()
+		mine := mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug", OrgID: "org-1"})
+		mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug", OrgID: "org-2"})
+
+		got := search(t, s, models.ItemSearch{Text: "mug", OrgID: "org-1"})
+		if want := []string{mine.ID}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("limits the results", func(t *testing.T) {
+		s := newStore()
+		for i := range 3 {
+			mustCreateItem(t, s, models.CreateItemInput{Name: fmt.Sprintf("mug %d", i)})
+		}
+
+		if got := search(t, s, models.ItemSearch{Text: "mug", Limit: 2}); len(got) != 2 {
+			t.Errorf("got %d results, want 2", len(got))
+		}
+	})
+
+	t.Run("follows updates", func(t *testing.T) {
+		s := newStore()
+		item := mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug"})
+		if _, err := s.UpdateItem(item.ID, models.ItemUpdate{Name: ptr("Green cup")}); err != nil {
+			t.Fatalf("UpdateItem returned error: %v", err)
+		}
+
+		if got := search(t, s, models.ItemSearch{Text: "mug"}); len(got) != 0 {
+			t.Errorf("got %v for the old name, want nothing", got)
+		}
+		if got := search(t, s, models.ItemSearch{Text: "cup"}); !slices.Equal(got, []string{item.ID}) {
+			t.Errorf("got %v for the new name, want %v", got, []string{item.ID})
+		}
+	})
+
+	t.Run("leaves out deleted items", func(t *testing.T) {
+		s := newStore()
+		soft := mustCreateItem(t, s, models.CreateItemInput{Name: "Blue mug"})
+		hard := mustCreateItem(t, s, models.CreateItemInput{Name: "Red mug"})
+
+		if _, err := s.SoftDeleteItem(soft.ID, time.Now()); err != nil {
+			t.Fatalf("SoftDeleteItem returned error: %v", err)
+		}
+		if err := s.DeleteItem(hard.ID); err != nil {
+			t.Fatalf("DeleteItem returned error: %v", err)
+		}
+		if got := search(t, s, models.ItemSearch{Text: "mug"}); len(got) != 0 {
+			t.Errorf("got %v, want no deleted items", got)
+		}
+
+		if _, err := s.RestoreItem(soft.ID); err != nil {
+			t.Fatalf("RestoreItem returned error: %v", err)
+		}
+		if got := search(t, s, models.ItemSearch{Text: "mug"}); !slices.Equal(got, []string{soft.ID}) {
+			t.Errorf("got %v, want the restored item", got)
+		}
+	})
+}
+
+func mustCreateItem(t testing.TB, s models.AppStore, input models.CreateItemInput) models.Item {
+	t.Helper()
+
+	item, err := s.CreateItem(input)
+	if err != nil {
+		t.Fatalf("CreateItem returned error: %v", err)
+	}
+	return item
+}
+
+func assertItemIDs(t testing.TB, items []models.Item, want ...string) {
+	t.Helper()
+
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		seen[item.ID] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			t.Errorf("expected item %q to be listed", id)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -5,22 +5,66 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/pkg/search"
 )
 
 type StubAppStore struct {
-	mu    sync.RWMutex
-	Items []models.Item
+	mu          sync.RWMutex
+	nextID      int
+	Items       []models.Item
+	Users       []models.User
+	Sessions    []models.Session
+	Orgs        []models.Org
+	Memberships []models.Membership
 }
 
-func NewStubAppStore() *StubAppStore{
+func NewStubAppStore() *StubAppStore {
 	return &StubAppStore{}
 }
 
+// NewStubAppStoreWithData returns a stub store seeded with the test fixtures
+func NewStubAppStoreWithData() *StubAppStore {
+	return &StubAppStore{
+		Items:    CreateTestItems(),
+		Users:    CreateTestUsers(),
+		Sessions: CreateTestSessions(),
+	}
+}
+
+// newID returns a unique id with the given prefix. Callers must hold the lock.
+func (s *StubAppStore) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-stub-%03d", prefix, s.nextID)
+}
+
+func (s *StubAppStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := models.Item{
+		ID:         s.newID("item"),
+		Name:       input.Name,
+		ExternalID: input.ExternalID,
+		OrgID:      input.OrgID,
+		IsActive:   true,
+		CreatedBy:  input.CreatedBy,
+		Version:    1,
+	}
+	if slices.ContainsFunc(s.Items, item.SharesExternalID) {
+		return models.Item{}, fmt.Errorf("external ID %q in org %q: %w", item.ExternalID, item.OrgID, models.ErrConflict)
+	}
+	item.CreatedAt = time.Now()
+	item.UpdatedAt = item.CreatedAt
+	s.Items = append(s.Items, item)
+	return item, nil
+}
+
 func (s *StubAppStore) GetItem(id string) (models.Item, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -30,13 +74,39 @@ func (s *StubAppStore) GetItem(id string) (models.Item, error) {
 			return item, nil
 		}
 	}
-	return models.Item{}, fmt.Errorf("item not found: %s", id)
+	return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
 }
 
 func (s *StubAppStore) GetItems() ([]models.Item, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.Items, nil
+	return slices.Clone(s.Items), nil
+}
+
+func (s *StubAppStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return models.QueryItemSlice(s.Items, query), nil
+}
+
+// SearchItems indexes the items afresh on every call, so that it also finds
+// items tests put in Items directly
+func (s *StubAppStore) SearchItems(query models.ItemSearch) ([]models.ItemSearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index := search.NewIndex()
+	byID := map[string]models.Item{}
+	for _, item := range s.Items {
+		index.Put(item)
+		byID[item.ID] = item
+	}
+
+	results := []models.ItemSearchResult{}
+	for _, hit := range index.Search(query) {
+		results = append(results, models.ItemSearchResult{Item: byID[hit.ID], Score: hit.Score})
+	}
+	return results, nil
 }
 
 func (s *StubAppStore) DeleteItem(id string) error {
@@ -48,55 +118,364 @@ func (s *StubAppStore) DeleteItem(id string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("item not found when trying to delete %s from %v", id, s.Items)
+	return fmt.Errorf("item %s: %w", id, models.ErrNotFound)
 }
 
-func (s *StubAppStore) UpdateItem(id string, updates map[string]any) (models.Item, error) {
+func (s *StubAppStore) SoftDeleteItem(id string, at time.Time) (models.Item, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// Find the item
+
+	for i, item := range s.Items {
+		if item.ID == id && !item.IsDeleted() {
+			deletedAt := at.UTC()
+			s.Items[i].DeletedAt = &deletedAt
+			s.Items[i].UpdatedAt = time.Now()
+			s.Items[i].Version++
+			return s.Items[i], nil
+		}
+	}
+	return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) RestoreItem(id string) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for i, item := range s.Items {
 		if item.ID == id {
-			// Apply updates
-			if name, ok := updates["Name"].(string); ok {
-				s.Items[i].Name = name
-			}
-			if externalID, ok := updates["ExternalID"].(string); ok {
-				s.Items[i].ExternalID = externalID
+			if !item.IsDeleted() {
+				return models.Item{}, fmt.Errorf("item %s is not deleted: %w", id, models.ErrConflict)
 			}
-			if orgID, ok := updates["OrgID"].(string); ok {
-				s.Items[i].OrgID = orgID
+			s.Items[i].DeletedAt = nil
+			s.Items[i].UpdatedAt = time.Now()
+			s.Items[i].Version++
+			return s.Items[i], nil
+		}
+	}
+	return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) PurgeItems(deletedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.Items)
+	s.Items = slices.DeleteFunc(s.Items, func(item models.Item) bool {
+		return item.DeletedBefore(deletedBefore)
+	})
+	return before - len(s.Items), nil
+}
+
+func (s *StubAppStore) UpdateItem(id string, update models.ItemUpdate) (models.Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, item := range s.Items {
+		if item.ID == id {
+			if err := update.CheckVersion(item); err != nil {
+				return models.Item{}, err
 			}
-			if isActive, ok := updates["IsActive"].(string); ok {
-				s.Items[i].IsActive = isActive
+			item.Apply(update)
+			if slices.ContainsFunc(s.Items, item.SharesExternalID) {
+				return models.Item{}, fmt.Errorf("external ID %q in org %q: %w", item.ExternalID, item.OrgID, models.ErrConflict)
 			}
-			if createdBy, ok := updates["CreatedBy"].(string); ok {
-				s.Items[i].CreatedBy = createdBy
+			item.UpdatedAt = time.Now()
+			item.Version++
+			s.Items[i] = item
+			return item, nil
+		}
+	}
+
+	return models.Item{}, fmt.Errorf("item %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) CreateUser(input models.CreateUserInput) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.ContainsFunc(s.Users, func(u models.User) bool { return u.Name == input.Name }) {
+		return models.User{}, fmt.Errorf("user name %q: %w", input.Name, models.ErrConflict)
+	}
+
+	user := models.User{
+		ID:           s.newID("user"),
+		Name:         input.Name,
+		PasswordHash: input.PasswordHash,
+		IsAdmin:      input.IsAdmin,
+		CreatedAt:    time.Now(),
+	}
+	s.Users = append(s.Users, user)
+	return user, nil
+}
+
+func (s *StubAppStore) GetUser(id string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.Users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return models.User{}, fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) GetUserByName(name string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.Users {
+		if user.Name == name {
+			return user, nil
+		}
+	}
+	return models.User{}, fmt.Errorf("user named %q: %w", name, models.ErrNotFound)
+}
+
+func (s *StubAppStore) UpdateUser(id string, updates map[string]any) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, user := range s.Users {
+		if user.ID == id {
+			updated := user
+			updated.ApplyUpdates(updates)
+			if slices.ContainsFunc(s.Users, func(u models.User) bool { return u.ID != id && u.Name == updated.Name }) {
+				return models.User{}, fmt.Errorf("user name %q: %w", updated.Name, models.ErrConflict)
 			}
-			if deletedAt, ok := updates["DeletedAt"].(string); ok {
-				s.Items[i].DeletedAt = deletedAt
+			s.Users[i] = updated
+			return updated, nil
+		}
+	}
+	return models.User{}, fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, user := range s.Users {
+		if user.ID == id {
+			s.Users = append(s.Users[:i], s.Users[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("user %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) CreateSession(input models.CreateSessionInput) (models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.ContainsFunc(s.Users, func(u models.User) bool { return u.ID == input.UserID }) {
+		return models.Session{}, fmt.Errorf("user %s: %w", input.UserID, models.ErrNotFound)
+	}
+
+	session := models.Session{
+		ID:        s.newID("session"),
+		UserID:    input.UserID,
+		TokenHash: input.TokenHash,
+		CreatedAt: time.Now(),
+		ExpiresAt: input.ExpiresAt,
+	}
+	s.Sessions = append(s.Sessions, session)
+	return session, nil
+}
+
+func (s *StubAppStore) GetSession(id string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.Sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return models.Session{}, fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) GetSessionByToken(tokenHash string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.Sessions {
+		if tokenHash != "" && session.TokenHash == tokenHash {
+			return session, nil
+		}
+	}
+	return models.Session{}, fmt.Errorf("session for token: %w", models.ErrNotFound)
+}
+
+func (s *StubAppStore) DeleteSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, session := range s.Sessions {
+		if session.ID == id {
+			s.Sessions = append(s.Sessions[:i], s.Sessions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("session %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) CreateOrg(input models.CreateOrgInput) (models.Org, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := models.Org{
+		ID:        s.newID("org"),
+		Name:      input.Name,
+		CreatedAt: time.Now(),
+	}
+	s.Orgs = append(s.Orgs, org)
+	return org, nil
+}
+
+func (s *StubAppStore) GetOrg(id string) (models.Org, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, org := range s.Orgs {
+		if org.ID == id {
+			return org, nil
+		}
+	}
+	return models.Org{}, fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+}
+
+func (s *StubAppStore) GetOrgs() ([]models.Org, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.Orgs), nil
+}
+
+func (s *StubAppStore) DeleteOrg(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, org := range s.Orgs {
+		if org.ID == id {
+			if slices.ContainsFunc(s.Items, func(item models.Item) bool { return item.OrgID == id && !item.IsDeleted() }) {
+				return fmt.Errorf("org %s still has items: %w", id, models.ErrConflict)
 			}
+			s.Orgs = append(s.Orgs[:i], s.Orgs[i+1:]...)
+			s.Items = slices.DeleteFunc(s.Items, func(item models.Item) bool { return item.OrgID == id })
+			s.Memberships = slices.DeleteFunc(s.Memberships, func(m models.Membership) bool {
+				return m.OrgID == id
+			})
+			return nil
+		}
+	}
+	return fmt.Errorf("org %s: %w", id, models.ErrNotFound)
+}
 
-			return s.Items[i], nil
+func (s *StubAppStore) AddMember(orgID, userID string, role models.Role) (models.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.ContainsFunc(s.Orgs, func(o models.Org) bool { return o.ID == orgID }) {
+		return models.Membership{}, fmt.Errorf("org %s: %w", orgID, models.ErrNotFound)
+	}
+	if !slices.ContainsFunc(s.Users, func(u models.User) bool { return u.ID == userID }) {
+		return models.Membership{}, fmt.Errorf("user %s: %w", userID, models.ErrNotFound)
+	}
+
+	for i, m := range s.Memberships {
+		if m.OrgID == orgID && m.UserID == userID {
+			s.Memberships[i].Role = role
+			return s.Memberships[i], nil
+		}
+	}
+
+	membership := models.Membership{OrgID: orgID, UserID: userID, Role: role, CreatedAt: time.Now()}
+	s.Memberships = append(s.Memberships, membership)
+	return membership, nil
+}
+
+func (s *StubAppStore) RemoveMember(orgID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range s.Memberships {
+		if m.OrgID == orgID && m.UserID == userID {
+			s.Memberships = append(s.Memberships[:i], s.Memberships[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("membership of %s in %s: %w", userID, orgID, models.ErrNotFound)
+}
+
+func (s *StubAppStore) GetMembers(orgID string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !slices.ContainsFunc(s.Orgs, func(o models.Org) bool { return o.ID == orgID }) {
+		return nil, fmt.Errorf("org %s: %w", orgID, models.ErrNotFound)
+	}
+
+	members := []models.Membership{}
+	for _, m := range s.Memberships {
+		if m.OrgID == orgID {
+			members = append(members, m)
 		}
 	}
+	return members, nil
+}
+
+func (s *StubAppStore) GetMemberships(userID string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return models.Item{}, fmt.Errorf("item not found: %s", id)
+	memberships := []models.Membership{}
+	for _, m := range s.Memberships {
+		if m.UserID == userID {
+			memberships = append(memberships, m)
+		}
+	}
+	return memberships, nil
 }
 
+// ErrorStore wraps an AppStore and fails the calls used to test 500 responses
 type ErrorStore struct {
-    models.AppStore
-    ShouldErrorOnGetItems bool
+	models.AppStore
+	ShouldError bool
 }
 
 func (s *ErrorStore) GetItems() ([]models.Item, error) {
-    if s.ShouldErrorOnGetItems {
-        return nil, fmt.Errorf("forced error for testing")
-    }
-    return s.AppStore.GetItems()
+	if s.ShouldError {
+		return nil, fmt.Errorf("forced error for testing")
+	}
+	return s.AppStore.GetItems()
 }
 
+func (s *ErrorStore) QueryItems(query models.ItemQuery) (models.ItemPage, error) {
+	if s.ShouldError {
+		return models.ItemPage{}, fmt.Errorf("forced error for testing")
+	}
+	return s.AppStore.QueryItems(query)
+}
 
+func (s *ErrorStore) GetItem(id string) (models.Item, error) {
+	if s.ShouldError {
+		return models.Item{}, fmt.Errorf("forced error for testing")
+	}
+	return s.AppStore.GetItem(id)
+}
+
+func (s *ErrorStore) CreateItem(input models.CreateItemInput) (models.Item, error) {
+	if s.ShouldError {
+		return models.Item{}, fmt.Errorf("forced error for testing")
+	}
+	return s.AppStore.CreateItem(input)
+}
+
+func (s *ErrorStore) CreateUser(input models.CreateUserInput) (models.User, error) {
+	if s.ShouldError {
+		return models.User{}, fmt.Errorf("forced error for testing")
+	}
+	return s.AppStore.CreateUser(input)
+}
 
 func AssertStatus(t testing.TB, got, want int) {
 	t.Helper()
@@ -120,7 +499,6 @@ func AssertValidJSON(t testing.TB, body *bytes.Buffer, err error) {
 	}
 }
 
-
 func MakeRequest(t testing.TB, server http.Handler, method, url string, body []byte) *httptest.ResponseRecorder {
 	t.Helper()
 
@@ -137,7 +515,47 @@ func MakeRequest(t testing.TB, server http.Handler, method, url string, body []b
 	return res
 }
 
-func AssertItemsContain(t testing.TB, items []models.Item, expectedIDs ...string) {
+// MakeRequestWithHeader is MakeRequest with one more header set, such as
+// If-Match or Accept. It replaces the JSON Content-Type if it names that.
+func MakeRequestWithHeader(t testing.TB, server http.Handler, method, url, header, value string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var req *http.Request
+	if body != nil {
+		req, _ = http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req, _ = http.NewRequest(method, url, nil)
+	}
+	req.Header.Set(header, value)
+
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res
+}
+
+// AssertContainsID checks that an item, user or session has the expected ID
+func AssertContainsID(t testing.TB, got any, want string) {
+	t.Helper()
+
+	var id string
+	switch v := got.(type) {
+	case models.Item:
+		id = v.ID
+	case models.User:
+		id = v.ID
+	case models.Session:
+		id = v.ID
+	default:
+		t.Fatalf("AssertContainsID: unsupported type %T", got)
+	}
+
+	if id != want {
+		t.Errorf("got ID %q, want %q", id, want)
+	}
+}
+
+func AssertContainsIDs(t testing.TB, items []models.Item, expectedIDs ...string) {
 	t.Helper()
 
 	if len(items) != len(expectedIDs) {
@@ -164,20 +582,49 @@ func CreateTestItems() []models.Item {
 			Name:       "First Test Item",
 			ExternalID: "ext-001",
 			OrgID:      "org-123",
-			IsActive:   "true",
+			IsActive:   true,
 			CreatedAt:  now,
+			UpdatedAt:  now,
 			CreatedBy:  "test-user",
-			DeletedAt:  "",
+			Version:    1,
 		},
 		{
 			ID:         "item-002",
 			Name:       "Second Test Item",
 			ExternalID: "ext-002",
 			OrgID:      "org-123",
-			IsActive:   "true",
+			IsActive:   true,
 			CreatedAt:  now,
+			UpdatedAt:  now,
 			CreatedBy:  "test-user",
-			DeletedAt:  "",
+			Version:    1,
+		},
+	}
+}
+
+func CreateTestUsers() []models.User {
+	now := time.Now()
+	return []models.User{
+		{
+			ID:        "user-001",
+			Name:      "First Test User",
+			CreatedAt: now,
+		},
+		{
+			ID:        "user-002",
+			Name:      "Second Test User",
+			CreatedAt: now,
 		},
 	}
-}
\ No newline at end of file
+}
+
+func CreateTestSessions() []models.Session {
+	now := time.Now()
+	return []models.Session{
+		{
+			ID:        "session-001",
+			UserID:    "user-001",
+			CreatedAt: now,
+		},
+	}
+}
@@ -0,0 +1,15 @@
+package testutils_test
+
+import (
+	"testing"
+
+	"github.com/espennoreng/learn-go-with-tests/velo/models"
+	"github.com/espennoreng/learn-go-with-tests/velo/storetest"
+	"github.com/espennoreng/learn-go-with-tests/velo/testutils"
+)
+
+func TestStubAppStore(t *testing.T) {
+	storetest.Run(t, func() models.AppStore {
+		return testutils.NewStubAppStore()
+	})
+}